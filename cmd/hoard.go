@@ -14,12 +14,15 @@ import (
 const configFile = "config-file"
 const endHour = "end-hour"
 const enforceCompression = "enforce-compression"
+const errorsFlag = "errors"
 const feed = "feed"
 const flattenFeeds = "flatten-feeds"
 const flattenHours = "flatten-hours"
 const fix = "fix"
 const keepPacked = "keep-packed"
 const logLevel = "log-level"
+const metadata = "metadata"
+const partials = "partials"
 const sync = "sync"
 const port = "port"
 const removeWorkspace = "remove-workspace"
@@ -162,6 +165,9 @@ func main() {
 						KeepPacked:      c.Bool(keepPacked),
 						FlattenTimeDirs: c.Bool(flattenHours),
 						FlattenFeedDirs: c.Bool(flattenFeeds),
+						Metadata:        c.Bool(metadata),
+						Errors:          c.Bool(errorsFlag),
+						Partials:        c.Bool(partials),
 						Start:           *c.Timestamp(startHour),
 						End:             *c.Timestamp(endHour),
 					})
@@ -183,6 +189,21 @@ func main() {
 						Usage: "place files from different hours in the same directories",
 						Value: false,
 					},
+					&cli.BoolFlag{
+						Name:  metadata,
+						Usage: "write the HTTP response metadata of each file to a " + hoard.MetadataFileSuffix + " file next to it",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  errorsFlag,
+						Usage: "retrieve the failed responses kept for each feed instead of the feed data",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  partials,
+						Usage: "also retrieve partial archives of hours that were in progress when they were uploaded",
+						Value: false,
+					},
 					&cli.TimestampFlag{
 						Name:        startHour,
 						Usage:       "the first hour to retrieve in the form YYYY-MM-DD-HH",
@@ -1,13 +1,16 @@
 package config
 
 import (
+	"bufio"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 
 	"github.com/DataDog/zstd"
 	"github.com/jamespfennell/xz"
+	kzstd "github.com/klauspost/compress/zstd"
 )
 
 type CompressionFormat int
@@ -16,6 +19,11 @@ const (
 	Gzip CompressionFormat = 0
 	Xz   CompressionFormat = 1
 	Zstd CompressionFormat = 2
+	// ZstdDict is zstd compression with a dictionary trained on the feed's data. Archives
+	// in this format have the same extension as zstd archives; the dictionary version is
+	// part of the archive file name. Reading such an archive as a zstd archive fails with
+	// ErrDictionaryRequired.
+	ZstdDict CompressionFormat = 3
 )
 
 const ExtensionRegex = `gz|xz|zstd`
@@ -25,6 +33,7 @@ func AllCompressionFormats() []CompressionFormat {
 		Gzip,
 		Xz,
 		Zstd,
+		ZstdDict,
 	}
 }
 
@@ -36,6 +45,9 @@ type formatImpl struct {
 	defaultLevel int
 	newReader    func(r io.Reader) (io.ReadCloser, error)
 	newWriter    func(w io.Writer, level int) io.WriteCloser
+	// newDictReader and newDictWriter are only set for formats that use dictionaries.
+	newDictReader func(r io.Reader, dict []byte) (io.ReadCloser, error)
+	newDictWriter func(w io.Writer, level int, dict []byte) io.WriteCloser
 }
 
 var gzipImpl = formatImpl{
@@ -74,18 +86,62 @@ var zstdImpl = formatImpl{
 	minLevel:     zstd.BestSpeed,
 	maxLevel:     zstd.BestCompression,
 	defaultLevel: zstd.DefaultCompression,
-	newReader: func(r io.Reader) (io.ReadCloser, error) {
-		return zstd.NewReader(r), nil
-	},
+	newReader:    newZstdReader,
 	newWriter: func(w io.Writer, level int) io.WriteCloser {
 		return zstd.NewWriterLevel(w, level)
 	},
 }
 
+// ErrDictionaryRequired is returned when reading zstd data that was compressed with a
+// dictionary without the dictionary.
+var ErrDictionaryRequired = errors.New("the data was compressed with a dictionary, which is required to read it")
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// Data compressed with a dictionary records the dictionary ID in its frame header.
+	// Without this check, the decoder would only fail with an obscure error when it reached
+	// the first block.
+	b, _ := br.Peek(kzstd.HeaderMaxSize)
+	var header kzstd.Header
+	if err := header.Decode(b); err == nil && header.DictionaryID != 0 {
+		return nil, fmt.Errorf("%w (dictionary ID %d)", ErrDictionaryRequired, header.DictionaryID)
+	}
+	// The reader of the zstd package stops after the first frame, so a decoder that
+	// supports streams of multiple frames, like seekable archives, is used instead.
+	d, err := kzstd.NewReader(br, kzstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+var zstdDictImpl = formatImpl{
+	id:            "zstd-dict",
+	extension:     "zstd",
+	minLevel:      zstd.BestSpeed,
+	maxLevel:      zstd.BestCompression,
+	defaultLevel:  zstd.DefaultCompression,
+	newReader:     zstdImpl.newReader,
+	newWriter:     zstdImpl.newWriter,
+	newDictReader: newZstdDictReader,
+	newDictWriter: func(w io.Writer, level int, dict []byte) io.WriteCloser {
+		return zstd.NewWriterLevelDict(w, level, dict)
+	},
+}
+
+func newZstdDictReader(r io.Reader, dict []byte) (io.ReadCloser, error) {
+	d, err := kzstd.NewReader(r, kzstd.WithDecoderConcurrency(1), kzstd.WithDecoderDicts(dict))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
 var formatToImpl = map[CompressionFormat]formatImpl{
-	Gzip: gzipImpl,
-	Xz:   xzImpl,
-	Zstd: zstdImpl,
+	Gzip:     gzipImpl,
+	Xz:       xzImpl,
+	Zstd:     zstdImpl,
+	ZstdDict: zstdDictImpl,
 }
 
 func (format *CompressionFormat) impl() formatImpl {
@@ -183,6 +239,30 @@ func (spec Compression) NewWriter(w io.Writer) io.WriteCloser {
 	return spec.Format.impl().newWriter(w, spec.LevelActual())
 }
 
+// UsesDictionary returns true if the compression format uses a dictionary.
+func (spec Compression) UsesDictionary() bool {
+	return spec.Format.impl().newDictReader != nil
+}
+
+// NewReaderWithDictionary returns a reader that decompresses data that was compressed using
+// the dictionary. For formats that do not use dictionaries, the dictionary is ignored.
+func (spec Compression) NewReaderWithDictionary(r io.Reader, dict []byte) (io.ReadCloser, error) {
+	if !spec.UsesDictionary() {
+		return spec.NewReader(r)
+	}
+	return spec.Format.impl().newDictReader(r, dict)
+}
+
+// NewWriterWithDictionary returns a writer that compresses data using the dictionary. For
+// formats that do not use dictionaries, the dictionary is ignored.
+func (spec Compression) NewWriterWithDictionary(w io.Writer, dict []byte) io.WriteCloser {
+	if !spec.UsesDictionary() {
+		return spec.NewWriter(w)
+	}
+	spec.fixLevel()
+	return spec.Format.impl().newDictWriter(w, spec.LevelActual(), dict)
+}
+
 func (spec Compression) Equals(other Compression) bool {
 	return spec.Format == other.Format &&
 		spec.LevelActual() == other.LevelActual()
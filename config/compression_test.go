@@ -1,11 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/dict"
 	"gopkg.in/yaml.v2"
 )
 
@@ -38,6 +42,13 @@ func TestSpec_UnmarshalYAML(t *testing.T) {
 			},
 			"format: xz",
 		},
+		{
+			"format: zstd-dict",
+			Compression{
+				Format: ZstdDict,
+			},
+			"format: zstd-dict",
+		},
 		{
 			"format: gzip\nlevel: 1",
 			NewSpecWithLevel(Gzip, 1),
@@ -65,3 +76,41 @@ func TestSpec_UnmarshalYAML(t *testing.T) {
 		})
 	}
 }
+
+func TestCompression_ZstdDictRequiresDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 50; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id": %d, "route": "A", "stop": "stop-%d", "status": "IN_TRANSIT_TO"}`, i, i%7)))
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 1 << 10, HashBytes: 6, ZstdDictCompat: true})
+	if err != nil {
+		t.Fatalf("Failed to train dictionary: %s", err)
+	}
+	spec := Compression{Format: ZstdDict}
+	var b bytes.Buffer
+	w := spec.NewWriterWithDictionary(&b, d)
+	if _, err := w.Write(samples[0]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	_, err = Compression{Format: Zstd}.NewReader(bytes.NewReader(b.Bytes()))
+	if !errors.Is(err, ErrDictionaryRequired) {
+		t.Errorf("Unexpected error %v; expected %v", err, ErrDictionaryRequired)
+	}
+
+	r, err := spec.NewReaderWithDictionary(bytes.NewReader(b.Bytes()), d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(content, samples[0]) {
+		t.Errorf("Unexpected content %q; expected %q", content, samples[0])
+	}
+}
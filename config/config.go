@@ -4,9 +4,16 @@ import (
 	_ "embed"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/jamespfennell/hoard/internal/feedtemplate"
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,11 +25,65 @@ type Feed struct {
 	UserPrefix  *string `yaml:"prefix,omitempty"`
 	Postfix     string
 	Periodicity time.Duration
-	URL         string
-	Headers     map[string]string
-	Compression Compression
+	// DynamicPeriodicity enables collecting the feed less frequently when it keeps returning
+	// the same data, up to the maximum periodicity. It is opt-in because collecting less
+	// frequently can miss short-lived changes in the data. MaxPeriodicity can only be set if
+	// it is enabled.
+	DynamicPeriodicity bool          `yaml:"dynamicPeriodicity,omitempty"`
+	MaxPeriodicity     time.Duration `yaml:"maxPeriodicity,omitempty"`
+	Schedule           Schedule      `yaml:",omitempty"`
+	Source             Source        `yaml:",omitempty"`
+	Stream             Stream        `yaml:",omitempty"`
+	Exec               Exec          `yaml:",omitempty"`
+	URL                string
+	URLs               []string    `yaml:"urls,omitempty"`
+	URLStrategy        URLStrategy `yaml:"urlStrategy,omitempty"`
+	Method             string      `yaml:",omitempty"`
+	Body               string      `yaml:",omitempty"`
+	BodyFile           string      `yaml:"bodyFile,omitempty"`
+	Headers            map[string]string
+	Compression        Compression
+	// SeekableArchives enables writing archives in which each downloaded file is compressed
+	// independently and that contain an index, so that single files can be read without
+	// decompressing the whole archive. It requires the zstd compression format.
+	SeekableArchives bool `yaml:"seekableArchives,omitempty"`
+	// Dictionary configures the training of the compression dictionary of the feed. It is only
+	// used with the zstd-dict compression format.
+	Dictionary Dictionary `yaml:",omitempty"`
+	// DeltaEncoding enables storing downloaded files in archives as deltas against the
+	// previous downloaded file.
+	DeltaEncoding DeltaEncoding `yaml:"deltaEncoding,omitempty"`
+	// BucketWidth is the width of the time buckets in which downloads are archived. It must
+	// divide an hour, like 5m or 15m. By default, downloads are archived in hourly buckets.
+	BucketWidth time.Duration `yaml:"bucketWidth,omitempty"`
+	// PartialArchivePeriod enables uploading partial archives of the current bucket with
+	// this period, so that the data is available remotely before the bucket ends. Partial
+	// archives are merged into the final archive of the bucket by the merge and audit tasks.
+	PartialArchivePeriod time.Duration  `yaml:"partialArchivePeriod,omitempty"`
+	MaxSize              int64          `yaml:"maxSize,omitempty"`
+	Retries              Retries        `yaml:",omitempty"`
+	CircuitBreaker       CircuitBreaker `yaml:"circuitBreaker,omitempty"`
+	HTTPClient           HTTPClient     `yaml:"httpClient,omitempty"`
+	Auth                 *Auth          `yaml:",omitempty"`
+	Validation           Validation     `yaml:",omitempty"`
+	Normalization        Normalization  `yaml:",omitempty"`
+	Errors               Errors         `yaml:",omitempty"`
+	// RateLimit is the name of a rate limit that all requests for the feed go through, in
+	// addition to any rate limits that apply to the hosts of the feed's URLs.
+	RateLimit string `yaml:"rateLimit,omitempty"`
+
+	// isErrorsFeed is true if this feed was derived from another feed using ErrorsFeed.
+	isErrorsFeed bool
+	// headerSecrets maps the secret references in the header values to the values they refer
+	// to. The header values keep their references so that secrets are never parsed as
+	// templates; the references are replaced after the values are rendered.
+	headerSecrets map[string]string
 }
 
+// defaultMaxPeriodicityFactor determines the default maximum periodicity of a feed, in
+// terms of its configured periodicity.
+const defaultMaxPeriodicityFactor = 4
+
 func (f *Feed) Prefix() string {
 	if f.UserPrefix != nil {
 		return *f.UserPrefix
@@ -30,6 +91,592 @@ func (f *Feed) Prefix() string {
 	return f.ID + "_"
 }
 
+func (f *Feed) validate() error {
+	if f.URL != "" && len(f.URLs) > 0 {
+		return fmt.Errorf("at most one of url and urls can be specified")
+	}
+	for _, u := range f.URLsActual() {
+		if err := validateTemplate(u); err != nil {
+			return fmt.Errorf("invalid URL template: %w", err)
+		}
+	}
+	if err := f.validateSource(); err != nil {
+		return err
+	}
+	if f.MaxPeriodicity != 0 && !f.DynamicPeriodicity {
+		return fmt.Errorf("the maximum periodicity can only be specified if dynamic periodicity is enabled")
+	}
+	if f.MaxPeriodicity != 0 && f.MaxPeriodicity < f.Periodicity {
+		return fmt.Errorf("the maximum periodicity %s cannot be less than the periodicity %s", f.MaxPeriodicity, f.Periodicity)
+	}
+	switch f.URLStrategy {
+	case "", URLStrategyFailover, URLStrategyRoundRobin, URLStrategyRace:
+	default:
+		return fmt.Errorf("unknown URL strategy %q", f.URLStrategy)
+	}
+	for key, value := range f.Headers {
+		if err := validateTemplate(value); err != nil {
+			return fmt.Errorf("invalid template for header %s: %w", key, err)
+		}
+	}
+	if f.Method != "" && !isHTTPToken(f.Method) {
+		return fmt.Errorf("invalid HTTP method %q", f.Method)
+	}
+	if f.MaxSize < 0 {
+		return fmt.Errorf("the maximum size cannot be negative")
+	}
+	if f.Body != "" && f.BodyFile != "" {
+		return fmt.Errorf("at most one of body and bodyFile can be specified")
+	}
+	if f.BodyFile != "" {
+		if _, err := os.Stat(f.BodyFile); err != nil {
+			return fmt.Errorf("failed to read the body file: %w", err)
+		}
+	}
+	if f.Auth != nil {
+		if err := f.Auth.validate(); err != nil {
+			return fmt.Errorf("invalid auth configuration: %w", err)
+		}
+	}
+	if err := f.Validation.validate(); err != nil {
+		return fmt.Errorf("invalid validation configuration: %w", err)
+	}
+	if err := f.Normalization.validate(); err != nil {
+		return fmt.Errorf("invalid normalization configuration: %w", err)
+	}
+	if err := f.Errors.validate(); err != nil {
+		return fmt.Errorf("invalid errors configuration: %w", err)
+	}
+	if f.BucketWidth != 0 && (f.BucketWidth < time.Minute || f.BucketWidth > time.Hour ||
+		f.BucketWidth%time.Minute != 0 || time.Hour%f.BucketWidth != 0) {
+		return fmt.Errorf("invalid bucket width %s: expected a whole number of minutes that divides an hour", f.BucketWidth)
+	}
+	if f.SeekableArchives && f.Compression.Format != Zstd {
+		return fmt.Errorf("seekable archives require the zstd compression format")
+	}
+	if err := f.Dictionary.validate(); err != nil {
+		return fmt.Errorf("invalid dictionary configuration: %w", err)
+	}
+	if err := f.DeltaEncoding.validate(); err != nil {
+		return fmt.Errorf("invalid delta encoding configuration: %w", err)
+	}
+	if f.PartialArchivePeriod != 0 && (f.PartialArchivePeriod < time.Minute || f.PartialArchivePeriod >= f.BucketWidthActual()) {
+		return fmt.Errorf("invalid partial archive period %s: expected at least 1m and less than the bucket width %s",
+			f.PartialArchivePeriod, f.BucketWidthActual())
+	}
+	if err := f.Schedule.validate(); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	if len(f.Schedule.Windows) > 0 && f.IsStreaming() {
+		return fmt.Errorf("a schedule cannot be specified for streaming feeds")
+	}
+	return f.HTTPClient.validate()
+}
+
+// BucketWidthActual returns the width of the time buckets in which downloads are archived.
+func (f *Feed) BucketWidthActual() time.Duration {
+	if f.BucketWidth == 0 {
+		return time.Hour
+	}
+	return f.BucketWidth
+}
+
+// validateTemplate checks that the template can be parsed and rendered. Some errors, like
+// references to unknown fields, are only detected when the template is rendered.
+func validateTemplate(s string) error {
+	t, err := feedtemplate.Parse(s)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = t.Render(feedtemplate.Data{Time: now, LastSuccess: now})
+	return err
+}
+
+// URLsActual returns the URLs the feed is downloaded from, in the order they were specified.
+func (f *Feed) URLsActual() []string {
+	if len(f.URLs) > 0 {
+		return f.URLs
+	}
+	return []string{f.URL}
+}
+
+// URLStrategy determines how the URLs of a feed with multiple URLs are used.
+type URLStrategy string
+
+const (
+	// URLStrategyFailover tries the URLs in order until one succeeds.
+	URLStrategyFailover URLStrategy = "failover"
+	// URLStrategyRoundRobin is like failover, except that each download starts with the
+	// URL after the one the previous download started with.
+	URLStrategyRoundRobin URLStrategy = "roundRobin"
+	// URLStrategyRace requests all of the URLs at the same time and uses the first
+	// successful response.
+	URLStrategyRace URLStrategy = "race"
+)
+
+// URLStrategyActual returns the URL strategy of the feed.
+func (f *Feed) URLStrategyActual() URLStrategy {
+	if f.URLStrategy == "" {
+		return URLStrategyFailover
+	}
+	return f.URLStrategy
+}
+
+// MethodActual returns the HTTP method used to download the feed.
+func (f *Feed) MethodActual() string {
+	if f.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(f.Method)
+}
+
+// isHTTPToken returns true if the string is a valid token as defined in RFC 7230. HTTP
+// methods must be tokens.
+func isHTTPToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxPeriodicityActual returns the longest period with which the feed will be collected.
+// If dynamic periodicity is not enabled, this is the periodicity.
+func (f *Feed) MaxPeriodicityActual() time.Duration {
+	if !f.DynamicPeriodicity {
+		return f.Periodicity
+	}
+	if f.MaxPeriodicity == 0 {
+		return defaultMaxPeriodicityFactor * f.Periodicity
+	}
+	return f.MaxPeriodicity
+}
+
+// Retries specifies how failed downloads are retried within a single download cycle.
+type Retries struct {
+	// Attempts is the maximum number of times a failed download is retried.
+	Attempts       int
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff,omitempty"`
+}
+
+const defaultInitialBackoff = time.Second
+
+// InitialBackoffActual returns the time to wait before the first retry.
+func (r Retries) InitialBackoffActual() time.Duration {
+	if r.InitialBackoff <= 0 {
+		return defaultInitialBackoff
+	}
+	return r.InitialBackoff
+}
+
+// MaxBackoffActual returns the maximum time to wait between two retries.
+func (r Retries) MaxBackoffActual() time.Duration {
+	if r.MaxBackoff < r.InitialBackoffActual() {
+		return r.InitialBackoffActual()
+	}
+	return r.MaxBackoff
+}
+
+// CircuitBreaker specifies when to stop downloading a feed that is consistently failing.
+//
+// After FailureThreshold consecutive failed download cycles the circuit breaker opens. While
+// it is open, downloads are only attempted once every ProbePeriodicity. The circuit breaker
+// closes again as soon as a download succeeds.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit breaker.
+	// If zero, the circuit breaker is disabled.
+	FailureThreshold int           `yaml:"failureThreshold,omitempty"`
+	ProbePeriodicity time.Duration `yaml:"probePeriodicity,omitempty"`
+}
+
+const defaultProbePeriodicity = time.Minute
+
+// ProbePeriodicityActual returns how frequently downloads are attempted while the circuit
+// breaker is open.
+func (b CircuitBreaker) ProbePeriodicityActual() time.Duration {
+	if b.ProbePeriodicity <= 0 {
+		return defaultProbePeriodicity
+	}
+	return b.ProbePeriodicity
+}
+
+// AuthTypeOAuth2ClientCredentials is the auth type for the OAuth2 client credentials flow.
+const AuthTypeOAuth2ClientCredentials = "oauth2ClientCredentials"
+
+// Auth specifies how the downloader authenticates with the feed server.
+//
+// Currently the only supported type is the OAuth2 client credentials flow. An access token
+// is obtained from the token URL and sent with each request in the Authorization header.
+// The token is cached and refreshed shortly before it expires, or after the feed server
+// rejects it.
+type Auth struct {
+	Type         string
+	TokenURL     string   `yaml:"tokenURL"`
+	ClientID     string   `yaml:"clientID"`
+	ClientSecret string   `yaml:"clientSecret"`
+	Scopes       []string `yaml:",omitempty"`
+	// Params are additional form parameters sent to the token URL.
+	Params map[string]string `yaml:",omitempty"`
+}
+
+func (a *Auth) validate() error {
+	if a.Type != AuthTypeOAuth2ClientCredentials {
+		return fmt.Errorf("unknown auth type %q; the only supported type is %q", a.Type, AuthTypeOAuth2ClientCredentials)
+	}
+	u, err := url.Parse(a.TokenURL)
+	if err != nil {
+		return fmt.Errorf("invalid token URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid token URL %q: the scheme and host must be specified", a.TokenURL)
+	}
+	if a.ClientID == "" {
+		return fmt.Errorf("the client ID must be specified")
+	}
+	return nil
+}
+
+// Validation specifies the checks a successful response must pass before it is stored.
+//
+// Responses that fail validation are rejected and the download is considered to have failed.
+type Validation struct {
+	// ContentTypes is a list of allowed media types for the Content-Type header of the
+	// response. Types of the form "application/*" match all subtypes. If empty, any
+	// Content-Type is allowed.
+	ContentTypes []string `yaml:"contentTypes,omitempty"`
+	// MinSize and MaxSize bound the size of the response body in bytes. A MaxSize of zero
+	// means there is no upper bound.
+	MinSize int64 `yaml:"minSize,omitempty"`
+	MaxSize int64 `yaml:"maxSize,omitempty"`
+	// JSON requires the response body to be valid JSON.
+	JSON bool `yaml:",omitempty"`
+	// Protobuf requires the response body to be a valid protocol buffer message in the
+	// wire format.
+	Protobuf bool `yaml:",omitempty"`
+	// Match and NotMatch are regular expressions that the response body must, respectively
+	// must not, match.
+	Match    string `yaml:",omitempty"`
+	NotMatch string `yaml:"notMatch,omitempty"`
+	// Quarantine enables keeping responses that fail validation in the quarantine
+	// directory of the workspace, so that they can be inspected.
+	Quarantine bool `yaml:",omitempty"`
+
+	// match and notMatch are the compiled Match and NotMatch, set when the validation is
+	// validated.
+	match    *regexp.Regexp
+	notMatch *regexp.Regexp
+}
+
+func (v *Validation) validate() error {
+	for _, contentType := range v.ContentTypes {
+		if !strings.Contains(contentType, "/") {
+			return fmt.Errorf("invalid content type %q: expected a media type of the form type/subtype", contentType)
+		}
+	}
+	if v.MinSize < 0 || v.MaxSize < 0 {
+		return fmt.Errorf("sizes cannot be negative")
+	}
+	if v.MaxSize > 0 && v.MinSize > v.MaxSize {
+		return fmt.Errorf("the minimum size %d is greater than the maximum size %d", v.MinSize, v.MaxSize)
+	}
+	if v.JSON && v.Protobuf {
+		return fmt.Errorf("at most one of json and protobuf can be specified")
+	}
+	var err error
+	if v.match, err = compileOptional(v.Match); err != nil {
+		return fmt.Errorf("invalid match regular expression: %w", err)
+	}
+	if v.notMatch, err = compileOptional(v.NotMatch); err != nil {
+		return fmt.Errorf("invalid notMatch regular expression: %w", err)
+	}
+	return nil
+}
+
+// MatchParsed returns the compiled Match, or nil if Match is empty.
+func (v Validation) MatchParsed() *regexp.Regexp {
+	if v.match != nil {
+		return v.match
+	}
+	// The validation has not been validated, which only happens in tests.
+	r, _ := compileOptional(v.Match)
+	return r
+}
+
+// NotMatchParsed returns the compiled NotMatch, or nil if NotMatch is empty.
+func (v Validation) NotMatchParsed() *regexp.Regexp {
+	if v.notMatch != nil {
+		return v.notMatch
+	}
+	// The validation has not been validated, which only happens in tests.
+	r, _ := compileOptional(v.NotMatch)
+	return r
+}
+
+// compileOptional compiles the regular expression, returning nil if it is empty.
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// Normalization specifies how a response is normalized before it is hashed. The hash is
+// used to de-duplicate responses, so normalization makes it possible to ignore parts of
+// the response that change even when the data itself has not changed, like timestamps.
+// The response is always stored as it was received.
+//
+// The normalizations are applied in the order they are listed here.
+type Normalization struct {
+	// IgnoreJSONPaths is a list of paths in a JSON response to remove. Each path is a list
+	// of object keys and array indices separated by dots; the wildcard * matches any key
+	// or index. For example, "entity.*.vehicle.timestamp".
+	IgnoreJSONPaths []string `yaml:"ignoreJSONPaths,omitempty"`
+	// IgnoreProtobufFields is a list of field number paths in a protocol buffer response
+	// to remove. Each path is a list of field numbers separated by dots. For example, the
+	// timestamp in the header of a GTFS realtime feed is "1.3". All occurrences of
+	// repeated fields are removed.
+	IgnoreProtobufFields []string `yaml:"ignoreProtobufFields,omitempty"`
+	// StripRegexps is a list of regular expressions. All matches are removed.
+	StripRegexps []string `yaml:"stripRegexps,omitempty"`
+
+	// stripRegexps are the compiled StripRegexps, set when the normalization is validated.
+	stripRegexps []*regexp.Regexp
+}
+
+// IsEmpty returns true if no normalizations are configured.
+func (n Normalization) IsEmpty() bool {
+	return len(n.IgnoreJSONPaths) == 0 && len(n.IgnoreProtobufFields) == 0 && len(n.StripRegexps) == 0
+}
+
+func (n *Normalization) validate() error {
+	for _, path := range n.IgnoreJSONPaths {
+		for _, key := range strings.Split(path, ".") {
+			if key == "" {
+				return fmt.Errorf("invalid JSON path %q: keys cannot be empty", path)
+			}
+		}
+	}
+	for _, path := range n.IgnoreProtobufFields {
+		if _, err := ParseProtobufFieldPath(path); err != nil {
+			return err
+		}
+	}
+	n.stripRegexps = nil
+	for _, expr := range n.StripRegexps {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+		n.stripRegexps = append(n.stripRegexps, r)
+	}
+	return nil
+}
+
+// StripRegexpsParsed returns the compiled StripRegexps.
+func (n Normalization) StripRegexpsParsed() []*regexp.Regexp {
+	if n.stripRegexps != nil || len(n.StripRegexps) == 0 {
+		return n.stripRegexps
+	}
+	// The normalization has not been validated, which only happens in tests.
+	var result []*regexp.Regexp
+	for _, expr := range n.StripRegexps {
+		if r, err := regexp.Compile(expr); err == nil {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// ParseProtobufFieldPath parses a path of protocol buffer field numbers, like "1.3".
+func ParseProtobufFieldPath(path string) ([]int32, error) {
+	var fieldNumbers []int32
+	for _, piece := range strings.Split(path, ".") {
+		n, err := strconv.ParseInt(piece, 10, 32)
+		if err != nil || n < 1 || n > 1<<29-1 {
+			return nil, fmt.Errorf("invalid protobuf field path %q: %q is not a field number", path, piece)
+		}
+		fieldNumbers = append(fieldNumbers, int32(n))
+	}
+	return fieldNumbers, nil
+}
+
+// RateLimit is a token bucket rate limit on requests that is shared by all feeds it applies
+// to. A rate limit applies to requests to any of its hosts, and to all requests of feeds
+// that reference it by name.
+type RateLimit struct {
+	Name  string
+	Hosts []string `yaml:",omitempty"`
+	// Requests is the number of requests allowed per period.
+	Requests int
+	Period   time.Duration `yaml:",omitempty"`
+	// Burst is the maximum number of requests that can be sent at once.
+	Burst int `yaml:",omitempty"`
+	// MaxDelay is the maximum time a request waits for the rate limit. Requests that would
+	// wait longer are dropped. If zero, requests wait as long as needed.
+	MaxDelay time.Duration `yaml:"maxDelay,omitempty"`
+}
+
+// PeriodActual returns the period of the rate limit.
+func (r *RateLimit) PeriodActual() time.Duration {
+	if r.Period == 0 {
+		return time.Second
+	}
+	return r.Period
+}
+
+// BurstActual returns the maximum number of requests that can be sent at once.
+func (r *RateLimit) BurstActual() int {
+	if r.Burst == 0 {
+		return 1
+	}
+	return r.Burst
+}
+
+func (r *RateLimit) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("the name must be specified")
+	}
+	if r.Requests <= 0 {
+		return fmt.Errorf("the number of requests must be positive")
+	}
+	if r.Period < 0 || r.Burst < 0 || r.MaxDelay < 0 {
+		return fmt.Errorf("the period, burst and maximum delay cannot be negative")
+	}
+	return nil
+}
+
+// Replicas specifies how multiple Hoard replicas collecting the same feeds coordinate.
+//
+// If phase coordination is enabled, each replica regularly writes a heartbeat object to
+// object storage. Replicas use the heartbeats to determine which replicas are running, and
+// offset their download times so that the downloads of all replicas are spread evenly over
+// each feed's period.
+type Replicas struct {
+	// ID identifies this replica. It defaults to the hostname.
+	ID              string        `yaml:",omitempty"`
+	CoordinatePhase bool          `yaml:"coordinatePhase,omitempty"`
+	HeartbeatPeriod time.Duration `yaml:"heartbeatPeriod,omitempty"`
+}
+
+const defaultHeartbeatPeriod = 30 * time.Second
+
+// IDActual returns the ID of this replica.
+func (r Replicas) IDActual() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "hoard"
+	}
+	return hostname
+}
+
+// HeartbeatPeriodActual returns how frequently this replica writes its heartbeat.
+func (r Replicas) HeartbeatPeriodActual() time.Duration {
+	if r.HeartbeatPeriod <= 0 {
+		return defaultHeartbeatPeriod
+	}
+	return r.HeartbeatPeriod
+}
+
+func (r Replicas) validate() error {
+	if strings.ContainsAny(r.ID, "/\\") {
+		return fmt.Errorf("the replica ID %q cannot contain slashes", r.ID)
+	}
+	if r.HeartbeatPeriod < 0 {
+		return fmt.Errorf("the heartbeat period cannot be negative")
+	}
+	return nil
+}
+
+// Dictionary specifies how the compression dictionary of a feed is trained. A new version of
+// the dictionary is trained periodically on a sample of the feed's recent downloads, so that
+// the dictionary follows changes in the data. Old versions are kept because they are needed
+// to decompress the archives compressed using them.
+type Dictionary struct {
+	// RetrainPeriod is how often a new version of the dictionary is trained. It defaults to
+	// 7 days.
+	RetrainPeriod time.Duration `yaml:"retrainPeriod,omitempty"`
+	// MaxSize is the maximum size of the dictionary in bytes. It defaults to 110 KiB.
+	MaxSize int `yaml:"maxSize,omitempty"`
+	// Samples is the maximum number of recent downloads the dictionary is trained on. It
+	// defaults to 1000.
+	Samples int `yaml:"samples,omitempty"`
+}
+
+const defaultDictionaryRetrainPeriod = 7 * 24 * time.Hour
+const defaultDictionaryMaxSize = 110 << 10
+const defaultDictionarySamples = 1000
+
+// RetrainPeriodActual returns how often a new version of the dictionary is trained.
+func (d Dictionary) RetrainPeriodActual() time.Duration {
+	if d.RetrainPeriod <= 0 {
+		return defaultDictionaryRetrainPeriod
+	}
+	return d.RetrainPeriod
+}
+
+// MaxSizeActual returns the maximum size of the dictionary in bytes.
+func (d Dictionary) MaxSizeActual() int {
+	if d.MaxSize <= 0 {
+		return defaultDictionaryMaxSize
+	}
+	return d.MaxSize
+}
+
+// SamplesActual returns the maximum number of downloads the dictionary is trained on.
+func (d Dictionary) SamplesActual() int {
+	if d.Samples <= 0 {
+		return defaultDictionarySamples
+	}
+	return d.Samples
+}
+
+func (d Dictionary) validate() error {
+	if d.RetrainPeriod < 0 || d.MaxSize < 0 || d.Samples < 0 {
+		return fmt.Errorf("the retrain period, maximum size and number of samples cannot be negative")
+	}
+	if d.RetrainPeriod != 0 && d.RetrainPeriod < time.Hour {
+		return fmt.Errorf("invalid retrain period %s: expected at least 1h", d.RetrainPeriod)
+	}
+	return nil
+}
+
+// DeltaEncoding specifies storing downloaded files in archives as binary deltas. The first
+// downloaded file of each run is stored in full and the following ones as deltas against the
+// previous downloaded file. A downloaded file is stored in full if its delta is not smaller.
+type DeltaEncoding struct {
+	Enabled bool `yaml:",omitempty"`
+	// MaxChainLength is the maximum number of consecutive deltas before a downloaded file is
+	// stored in full again. Reading a single downloaded file requires decoding at most this
+	// many deltas. It defaults to 16.
+	MaxChainLength int `yaml:"maxChainLength,omitempty"`
+}
+
+const defaultDeltaMaxChainLength = 16
+
+// MaxChainLengthActual returns the maximum number of consecutive deltas.
+func (d DeltaEncoding) MaxChainLengthActual() int {
+	if d.MaxChainLength <= 0 {
+		return defaultDeltaMaxChainLength
+	}
+	return d.MaxChainLength
+}
+
+func (d DeltaEncoding) validate() error {
+	if d.MaxChainLength < 0 {
+		return fmt.Errorf("the maximum chain length cannot be negative")
+	}
+	return nil
+}
+
 type ObjectStorage struct {
 	Endpoint   string
 	AccessKey  string `yaml:"accessKey"`
@@ -44,11 +691,16 @@ type Config struct {
 	WorkspacePath string `yaml:"workspacePath"`
 
 	Feeds          []Feed
+	RateLimits     []RateLimit     `yaml:"rateLimits,omitempty"`
 	ObjectStorage  []ObjectStorage `yaml:"objectStorage"`
 	Secrets        []string
-	DisableMerging bool `yaml:"disableMerging"`
+	Replicas       Replicas `yaml:",omitempty"`
+	DisableMerging bool     `yaml:"disableMerging"`
 	Sync           bool
 	LogLevel       string `yaml:"logLevel"`
+
+	// resolvedSecrets contains the values obtained by resolving secret references.
+	resolvedSecrets []string
 }
 
 func NewConfigWithDefaults() *Config {
@@ -64,20 +716,57 @@ func NewConfig(b []byte) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse the config file as a YAML Hoard config: %w", err)
 	}
+	if err := c.resolveSecretReferences(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
-func (c *Config) String() string {
-	b, err := yaml.Marshal(c)
-	if err != nil {
-		return "Error while marshalling config to YAML."
+func (c *Config) validate() error {
+	if err := c.Replicas.validate(); err != nil {
+		return fmt.Errorf("invalid replicas configuration: %w", err)
 	}
-	s := string(b)
-	for _, secret := range c.Secrets {
-		n := 40
-		s = strings.ReplaceAll(s, secret, "<span class=\"secret\">"+strings.Repeat("&nbsp;", n)+"</span>")
+	rateLimitNames := map[string]bool{}
+	for _, rateLimit := range c.RateLimits {
+		if err := rateLimit.validate(); err != nil {
+			return fmt.Errorf("invalid configuration for rate limit %s: %w", rateLimit.Name, err)
+		}
+		if rateLimitNames[rateLimit.Name] {
+			return fmt.Errorf("multiple rate limits have the name %s", rateLimit.Name)
+		}
+		rateLimitNames[rateLimit.Name] = true
+	}
+	feedIDs := map[string]bool{}
+	for _, feed := range c.Feeds {
+		feedIDs[feed.ID] = true
+	}
+	for i := range c.Feeds {
+		feed := &c.Feeds[i]
+		if errorsFeed := feed.ErrorsFeed(); errorsFeed != nil && feedIDs[errorsFeed.ID] {
+			return fmt.Errorf("invalid configuration for feed %s: the ID of its errors feed, %s, is already used", feed.ID, errorsFeed.ID)
+		}
+		if err := feed.validate(); err != nil {
+			return fmt.Errorf("invalid configuration for feed %s: %w", feed.ID, err)
+		}
+		if feed.RateLimit != "" && !rateLimitNames[feed.RateLimit] {
+			return fmt.Errorf("invalid configuration for feed %s: unknown rate limit %s", feed.ID, feed.RateLimit)
+		}
+	}
+	return nil
+}
+
+// AllFeeds returns the feeds in the config followed by their errors feeds.
+func (c *Config) AllFeeds() []Feed {
+	feeds := append([]Feed{}, c.Feeds...)
+	for i := range c.Feeds {
+		if errorsFeed := c.Feeds[i].ErrorsFeed(); errorsFeed != nil {
+			feeds = append(feeds, *errorsFeed)
+		}
 	}
-	return s
+	return feeds
 }
 
 func (c *Config) LogLevelParsed() slog.Level {
@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestConfig_SampleConfigIsReadable(t *testing.T) {
 	_, err := NewConfig([]byte(SampleConfig))
@@ -8,3 +14,316 @@ func TestConfig_SampleConfigIsReadable(t *testing.T) {
 		t.Errorf("Sample config is not readable: %s\n", err)
 	}
 }
+
+func TestConfig_InvalidFeed(t *testing.T) {
+	for i, feedConfig := range []string{
+		"httpClient: {proxy: \"not a url\"}",
+		"httpClient: {certFile: client.pem}",
+		"httpClient: {maxRedirects: -1}",
+		"method: \"GE T\"",
+		"body: a\n    bodyFile: b",
+		"bodyFile: /does/not/exist",
+		"url: \"https://example.com/{{ .Time\"",
+		"url: \"https://example.com/{{ .NotAField }}\"",
+		"headers: {key: \"{{ strftime \\\"%Q\\\" .Time }}\"}",
+		"validation: {minSize: 10, maxSize: 5}",
+		"validation: {match: \"(\"}",
+		"validation: {contentTypes: [json]}",
+		"url: https://a.com\n    urls: [https://b.com]",
+		"urls: [\"https://a.com/{{ .Time\"]",
+		"urlStrategy: random",
+		"periodicity: 5s\n    maxPeriodicity: 30s",
+		"periodicity: 5s\n    dynamicPeriodicity: true\n    maxPeriodicity: 1s",
+		"source: ftp",
+		"source: file\n    url: https://example.com",
+		"url: file://",
+		"source: exec",
+		"source: exec\n    exec: {command: [date], timeout: -1s}",
+		"exec: {command: [date]}",
+		"source: websocket\n    method: POST",
+		"source: sse\n    validation: {contentTypes: [application/json]}",
+		"source: sse\n    stream: {batchWindow: -1s}",
+		"stream: {batchWindow: 10s}",
+		"source: exec\n    exec: {command: [date]}\n    stream: {maxBackoff: 1m}",
+		"normalization: {ignoreJSONPaths: [\"a..b\"]}",
+		"normalization: {ignoreProtobufFields: [\"1.x\"]}",
+		"normalization: {ignoreProtobufFields: [\"0\"]}",
+		"normalization: {stripRegexps: [\"(\"]}",
+		"schedule: {timeZone: Not/AZone}",
+		"schedule: {windows: [{start: \"5:00\"}]}",
+		"schedule: {windows: [{end: \"24:01\"}]}",
+		"schedule: {windows: [{days: [someday]}]}",
+		"schedule: {windows: [{periodicity: -1s}]}",
+		"source: sse\n    schedule: {windows: [{start: \"05:00\"}]}",
+		"bucketWidth: 7m",
+		"bucketWidth: 90s",
+		"bucketWidth: 2h",
+		"seekableArchives: true",
+		"compression: {format: zstd-dict}\n    seekableArchives: true",
+		"compression: {format: zstd-dict}\n    dictionary: {maxSize: -1}",
+		"compression: {format: zstd-dict}\n    dictionary: {retrainPeriod: 30m}",
+		"deltaEncoding: {enabled: true, maxChainLength: -1}",
+		"partialArchivePeriod: 30s",
+		"partialArchivePeriod: 1h",
+		"bucketWidth: 15m\n    partialArchivePeriod: 15m",
+		"errors: {maxSize: -1}",
+		"errors: {maxCount: -1}",
+		"auth: {type: basic, tokenURL: \"https://example.com\", clientID: id}",
+		"auth: {type: oauth2ClientCredentials, tokenURL: \"not a url\", clientID: id}",
+		"auth: {type: oauth2ClientCredentials, tokenURL: \"https://example.com\"}",
+	} {
+		_, err := NewConfig([]byte("feeds:\n  - id: feed\n    " + feedConfig + "\n"))
+		if err == nil {
+			t.Errorf("Case %d: expected error for invalid feed config %q; received none", i, feedConfig)
+		}
+	}
+}
+
+func TestConfig_StringHidesAuthClientSecret(t *testing.T) {
+	c, err := NewConfig([]byte(`feeds:
+  - id: feed
+    auth:
+      type: oauth2ClientCredentials
+      tokenURL: https://example.com/token
+      clientID: id
+      clientSecret: verysecretvalue
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Contains(c.String(), "verysecretvalue") {
+		t.Errorf("Client secret appears in the config string:\n%s", c.String())
+	}
+}
+
+func TestConfig_SecretReferences(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret_key")
+	if err := os.WriteFile(secretFile, []byte("secretkeyfromfile\n"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	t.Setenv("HOARD_TEST_API_KEY", "apikeyfromenv")
+	t.Setenv("HOARD_TEST_ACCESS_KEY", "accesskeyfromenv")
+	// Secrets are not parsed as templates.
+	t.Setenv("HOARD_TEST_TOKEN", "{{ not a template")
+
+	c, err := NewConfig([]byte(`feeds:
+  - id: feed
+    headers:
+      Authorization: "Bearer ${HOARD_TEST_API_KEY}"
+      X-Plain: "$notareference"
+      X-Token: "${HOARD_TEST_TOKEN}"
+      X-File: file:` + secretFile + `
+objectStorage:
+  - endpoint: example.com
+    accessKey: ${HOARD_TEST_ACCESS_KEY}
+    secretKey: file:` + secretFile + `
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for key, expected := range map[string]string{
+		"Authorization": "Bearer apikeyfromenv",
+		"X-Plain":       "$notareference",
+		"X-Token":       "{{ not a template",
+		"X-File":        "secretkeyfromfile",
+	} {
+		if actual := c.Feeds[0].ResolveHeaderSecrets(c.Feeds[0].Headers[key]); actual != expected {
+			t.Errorf("Unexpected value %q for header %s; expected %q", actual, key, expected)
+		}
+	}
+	if actual := c.ObjectStorage[0].AccessKey; actual != "accesskeyfromenv" {
+		t.Errorf("Unexpected access key %q", actual)
+	}
+	if actual := c.ObjectStorage[0].SecretKey; actual != "secretkeyfromfile" {
+		t.Errorf("Unexpected secret key %q", actual)
+	}
+	for _, secret := range []string{"apikeyfromenv", "accesskeyfromenv", "secretkeyfromfile", "not a template"} {
+		if strings.Contains(c.String(), secret) {
+			t.Errorf("Secret %q appears in the config string:\n%s", secret, c.String())
+		}
+	}
+}
+
+func TestConfig_StringHidesSecretsThatYAMLQuotes(t *testing.T) {
+	secret := "*quoted: \"secret\" # with \\ backslash\n\tand a second line"
+	t.Setenv("HOARD_TEST_SECRET", secret)
+	c, err := NewConfig([]byte(`feeds:
+  - id: feed
+    url: "https://example.com/feed?key=abc'def"
+    auth:
+      type: oauth2ClientCredentials
+      tokenURL: https://example.com/token
+      clientID: id
+      clientSecret: ${HOARD_TEST_SECRET}
+objectStorage:
+  - endpoint: example.com
+    secretKey: ${HOARD_TEST_SECRET}
+secrets:
+  - abc'def
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, fragment := range []string{"quoted", "backslash", "second line", "abc"} {
+		if strings.Contains(c.String(), fragment) {
+			t.Errorf("Secret fragment %q appears in the config string:\n%s", fragment, c.String())
+		}
+	}
+	if c.ObjectStorage[0].SecretKey != secret || c.Feeds[0].Auth.ClientSecret != secret {
+		t.Errorf("Config was modified when converted to a string")
+	}
+}
+
+func TestConfig_InvalidSecretReferences(t *testing.T) {
+	for _, config := range []string{
+		"feeds:\n  - id: feed\n    headers: {Key: \"${HOARD_TEST_NOT_SET}\"}\n",
+		"objectStorage:\n  - secretKey: file:/does/not/exist\n",
+	} {
+		_, err := NewConfig([]byte(config))
+		if err == nil {
+			t.Errorf("Expected error for config %q; recieved none", config)
+		}
+	}
+}
+
+func TestConfig_InvalidRateLimits(t *testing.T) {
+	for _, config := range []string{
+		"rateLimits:\n  - requests: 1\n",
+		"rateLimits:\n  - name: a\n",
+		"rateLimits:\n  - name: a\n    requests: 1\n    maxDelay: -1s\n",
+		"rateLimits:\n  - name: a\n    requests: 1\n  - name: a\n    requests: 2\n",
+		"feeds:\n  - id: feed\n    rateLimit: a\n",
+		"feeds:\n  - id: feed\n    errors: {keep: true}\n  - id: feed_errors\n",
+		"replicas: {id: a/b}\n",
+		"replicas: {heartbeatPeriod: -1s}\n",
+	} {
+		_, err := NewConfig([]byte(config))
+		if err == nil {
+			t.Errorf("Expected error for config %q; recieved none", config)
+		}
+	}
+}
+
+func TestConfig_ErrorsFeeds(t *testing.T) {
+	c, err := NewConfig([]byte("feeds:\n  - id: a\n    errors: {keep: true}\n  - id: b\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	feeds := c.AllFeeds()
+
+	if len(feeds) != 3 {
+		t.Fatalf("Unexpected number of feeds %d; expected 3", len(feeds))
+	}
+	errorsFeed := feeds[2]
+	if errorsFeed.ID != "a_errors" || errorsFeed.Prefix() != "a_errors_" || !errorsFeed.IsErrorsFeed() {
+		t.Errorf("Unexpected errors feed %+v", errorsFeed)
+	}
+	if errorsFeed.ErrorsFeed() != nil {
+		t.Errorf("Unexpected errors feed of an errors feed")
+	}
+}
+
+func TestFeed_Schedule(t *testing.T) {
+	feed := Feed{
+		Periodicity: 5 * time.Second,
+		Schedule: Schedule{
+			TimeZone: "America/New_York",
+			Windows: []ScheduleWindow{
+				// Service hours, which end after midnight.
+				{Start: "05:00", End: "01:00"},
+				// Slower collection on weekend nights.
+				{Days: []string{"sat", "sun"}, Start: "01:00", End: "05:00", Periodicity: time.Minute},
+			},
+		},
+	}
+	if err := feed.Schedule.validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if feed.Schedule.loc == nil || feed.Schedule.loc.String() != loc.String() {
+		t.Errorf("Unexpected time zone %s; expected it to be loaded when validating", feed.Schedule.loc)
+	}
+	// 2021-01-06 is a Wednesday and 2021-01-09 is a Saturday.
+	for _, testCase := range []struct {
+		t                   time.Time
+		expectedPeriodicity time.Duration
+		expectedScheduled   bool
+		expectedNext        time.Time
+	}{
+		{
+			time.Date(2021, 1, 6, 12, 0, 0, 0, loc),
+			5 * time.Second, true,
+			time.Date(2021, 1, 6, 12, 0, 0, 0, loc),
+		},
+		{
+			time.Date(2021, 1, 7, 0, 30, 0, 0, loc),
+			5 * time.Second, true,
+			time.Date(2021, 1, 7, 0, 30, 0, 0, loc),
+		},
+		{
+			time.Date(2021, 1, 7, 3, 0, 0, 0, loc),
+			0, false,
+			time.Date(2021, 1, 7, 5, 0, 0, 0, loc),
+		},
+		{
+			time.Date(2021, 1, 9, 3, 0, 0, 0, loc),
+			time.Minute, true,
+			time.Date(2021, 1, 9, 3, 0, 0, 0, loc),
+		},
+		{
+			time.Date(2021, 1, 9, 3, 0, 0, 0, loc).UTC(),
+			time.Minute, true,
+			time.Date(2021, 1, 9, 3, 0, 0, 0, loc),
+		},
+	} {
+		periodicity, scheduled := feed.PeriodicityAt(testCase.t)
+		if periodicity != testCase.expectedPeriodicity || scheduled != testCase.expectedScheduled {
+			t.Errorf("Unexpected periodicity (%s, %t) at %s; expected (%s, %t)",
+				periodicity, scheduled, testCase.t, testCase.expectedPeriodicity, testCase.expectedScheduled)
+		}
+		if next := feed.NextScheduledTime(testCase.t); !next.Equal(testCase.expectedNext) {
+			t.Errorf("Unexpected next scheduled time %s at %s; expected %s", next, testCase.t, testCase.expectedNext)
+		}
+	}
+}
+
+func TestFeed_IsScheduledBetween(t *testing.T) {
+	feed := Feed{
+		Schedule: Schedule{
+			TimeZone: "America/New_York",
+			Windows: []ScheduleWindow{
+				{Start: "05:00", End: "01:00"},
+				{Days: []string{"sat"}, Start: "02:30", End: "02:45"},
+			},
+		},
+	}
+	if err := feed.Schedule.validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	loc := feed.Schedule.loc
+	// 2021-01-06 is a Wednesday and 2021-01-09 is a Saturday.
+	for _, testCase := range []struct {
+		start, end time.Time
+		expected   bool
+	}{
+		{time.Date(2021, 1, 6, 12, 0, 0, 0, loc), time.Date(2021, 1, 6, 13, 0, 0, 0, loc), true},
+		{time.Date(2021, 1, 7, 0, 0, 0, 0, loc), time.Date(2021, 1, 7, 1, 0, 0, 0, loc), true},
+		{time.Date(2021, 1, 7, 1, 0, 0, 0, loc), time.Date(2021, 1, 7, 5, 0, 0, 0, loc), false},
+		{time.Date(2021, 1, 7, 4, 0, 0, 0, loc), time.Date(2021, 1, 7, 5, 0, 0, 0, loc), false},
+		{time.Date(2021, 1, 7, 4, 59, 0, 0, loc), time.Date(2021, 1, 7, 5, 0, 0, 0, loc), false},
+		{time.Date(2021, 1, 7, 4, 59, 0, 0, loc), time.Date(2021, 1, 7, 5, 1, 0, 0, loc), true},
+		{time.Date(2021, 1, 8, 2, 0, 0, 0, loc), time.Date(2021, 1, 8, 3, 0, 0, 0, loc), false},
+		{time.Date(2021, 1, 9, 2, 0, 0, 0, loc), time.Date(2021, 1, 9, 3, 0, 0, 0, loc), true},
+		{time.Date(2021, 1, 9, 7, 0, 0, 0, time.UTC), time.Date(2021, 1, 9, 8, 0, 0, 0, time.UTC), true},
+	} {
+		if scheduled := feed.IsScheduledBetween(testCase.start, testCase.end); scheduled != testCase.expected {
+			t.Errorf("Unexpected result %t for [%s, %s); expected %t",
+				scheduled, testCase.start, testCase.end, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import "fmt"
+
+// errorsFeedIDSuffix is appended to the ID of a feed to obtain the ID of its errors feed.
+const errorsFeedIDSuffix = "_errors"
+
+// ErrorsFeed returns the feed containing the failed responses of this feed, or nil if
+// failed responses are not kept. The errors feed is never downloaded; failed responses are
+// stored in its downloads directory by the downloader of this feed, and it is then packed,
+// uploaded and audited like any other feed.
+func (f *Feed) ErrorsFeed() *Feed {
+	if !f.Errors.Keep || f.isErrorsFeed {
+		return nil
+	}
+	prefix := f.Prefix() + "errors_"
+	return &Feed{
+		ID:            f.ID + errorsFeedIDSuffix,
+		UserPrefix:    &prefix,
+		Postfix:       f.Postfix,
+		Periodicity:   f.Periodicity,
+		Compression:   f.Compression,
+		Dictionary:    f.Dictionary,
+		DeltaEncoding: f.DeltaEncoding,
+		BucketWidth:   f.BucketWidth,
+		isErrorsFeed:  true,
+	}
+}
+
+// IsErrorsFeed returns true if the feed was obtained from another feed using ErrorsFeed.
+func (f *Feed) IsErrorsFeed() bool {
+	return f.isErrorsFeed
+}
+
+// Errors specifies keeping responses that could not be stored: responses with a non-2xx
+// status code and responses that fail validation. These are stored in a separate errors
+// feed; see Feed.ErrorsFeed.
+type Errors struct {
+	Keep bool `yaml:",omitempty"`
+	// MaxSize is the maximum number of bytes kept of each response; longer responses are
+	// truncated. It defaults to 1 MiB.
+	MaxSize int64 `yaml:"maxSize,omitempty"`
+	// MaxCount is the maximum number of responses kept in each hour. It defaults to 60.
+	MaxCount int `yaml:"maxCount,omitempty"`
+}
+
+const defaultErrorsMaxSize = 1 << 20
+
+const defaultErrorsMaxCount = 60
+
+// MaxSizeActual returns the maximum number of bytes kept of each failed response.
+func (e Errors) MaxSizeActual() int64 {
+	if e.MaxSize <= 0 {
+		return defaultErrorsMaxSize
+	}
+	return e.MaxSize
+}
+
+// MaxCountActual returns the maximum number of failed responses kept in each hour.
+func (e Errors) MaxCountActual() int {
+	if e.MaxCount <= 0 {
+		return defaultErrorsMaxCount
+	}
+	return e.MaxCount
+}
+
+func (e Errors) validate() error {
+	if e.MaxSize < 0 || e.MaxCount < 0 {
+		return fmt.Errorf("the maximum size and count cannot be negative")
+	}
+	return nil
+}
+
+// ErrorsFeeds returns the errors feeds of the feeds in the config.
+func (c *Config) ErrorsFeeds() []Feed {
+	var feeds []Feed
+	for i := range c.Feeds {
+		if errorsFeed := c.Feeds[i].ErrorsFeed(); errorsFeed != nil {
+			feeds = append(feeds, *errorsFeed)
+		}
+	}
+	return feeds
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// HTTPClient specifies the HTTP client used to download a feed.
+type HTTPClient struct {
+	// Timeout is the maximum duration of a single request, including reading the body.
+	Timeout time.Duration `yaml:",omitempty"`
+	// Proxy is the URL of a proxy to send requests through. If empty, the proxy is
+	// determined using the standard environment variables.
+	Proxy string `yaml:",omitempty"`
+	// CAFile is the path to a PEM encoded bundle of certificate authorities used to
+	// verify the server. If empty, the system certificate authorities are used.
+	CAFile string `yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are paths to a PEM encoded client certificate and key that are
+	// presented to the server for mutual TLS.
+	CertFile           string `yaml:"certFile,omitempty"`
+	KeyFile            string `yaml:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+	// MaxRedirects is the maximum number of redirects to follow. If zero, redirects
+	// are not followed. If not specified, up to 10 redirects are followed.
+	MaxRedirects *int `yaml:"maxRedirects,omitempty"`
+}
+
+const defaultTimeout = 30 * time.Second
+
+// TimeoutActual returns the maximum duration of a single request.
+func (c HTTPClient) TimeoutActual() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c HTTPClient) validate() error {
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q: the scheme and host must be specified", c.Proxy)
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("the client certificate and key files must be specified together")
+	}
+	if c.MaxRedirects != nil && *c.MaxRedirects < 0 {
+		return fmt.Errorf("the maximum number of redirects cannot be negative")
+	}
+	return nil
+}
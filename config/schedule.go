@@ -0,0 +1,244 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule restricts the times at which a feed is downloaded to a list of windows. Within a
+// window the feed is downloaded with the window's periodicity, or the feed's periodicity if
+// the window does not specify one. Outside of the windows the feed is not downloaded. A
+// schedule without windows means the feed is always downloaded.
+type Schedule struct {
+	// TimeZone is the IANA name of the time zone, like America/New_York, in which the
+	// windows are interpreted. It defaults to UTC.
+	TimeZone string           `yaml:"timeZone,omitempty"`
+	Windows  []ScheduleWindow `yaml:",omitempty"`
+
+	// loc is the time zone, loaded when the schedule is validated.
+	loc *time.Location
+}
+
+// ScheduleWindow is a time window, repeated daily or on specific days of the week, in which
+// a feed is downloaded.
+type ScheduleWindow struct {
+	// Days are the days of the week, like mon or sat, on which the window starts. If empty,
+	// the window starts every day.
+	Days []string `yaml:",omitempty"`
+	// Start and End are times of the form HH:MM and default to 00:00. If End is not after
+	// Start, the window ends on the following day; in particular if they are equal the
+	// window lasts a full day.
+	Start       string        `yaml:",omitempty"`
+	End         string        `yaml:",omitempty"`
+	Periodicity time.Duration `yaml:",omitempty"`
+
+	// start and end are Start and End in minutes since midnight, parsed when the window is
+	// validated.
+	start, end int
+	parsed     bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (s *Schedule) validate() error {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone: %w", err)
+	}
+	s.loc = loc
+	for i := range s.Windows {
+		if err := s.Windows[i].validate(); err != nil {
+			return fmt.Errorf("invalid window %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (w *ScheduleWindow) validate() error {
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q: expected one of sun, mon, tue, wed, thu, fri or sat", day)
+		}
+	}
+	start, err := parseClockTime(w.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseClockTime(w.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+	if w.Periodicity < 0 {
+		return fmt.Errorf("the periodicity cannot be negative")
+	}
+	w.start, w.end, w.parsed = start, end, true
+	return nil
+}
+
+// parseClockTime parses a time of the form HH:MM into the number of minutes since midnight.
+// The time 24:00 is allowed so that windows can end at midnight.
+func parseClockTime(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q: expected a time of the form HH:MM", s)
+	}
+	if h < 0 || m < 0 || m >= 60 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q: expected a time between 00:00 and 24:00", s)
+	}
+	return h*60 + m, nil
+}
+
+func (s Schedule) location() *time.Location {
+	if s.loc != nil {
+		return s.loc
+	}
+	// The schedule has not been validated, which only happens in tests.
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// clockTimes returns the start and end of the window in minutes since midnight.
+func (w *ScheduleWindow) clockTimes() (int, int) {
+	if w.parsed {
+		return w.start, w.end
+	}
+	// The window has not been validated, which only happens in tests.
+	start, _ := parseClockTime(w.Start)
+	end, _ := parseClockTime(w.End)
+	return start, end
+}
+
+// startsOn returns true if the window starts on the day of the week.
+func (w *ScheduleWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if the window contains the time, which must be in the time zone of
+// the schedule.
+func (w *ScheduleWindow) contains(t time.Time) bool {
+	start, end := w.clockTimes()
+	m := t.Hour()*60 + t.Minute()
+	if start < end {
+		return w.startsOn(t.Weekday()) && start <= m && m < end
+	}
+	previousDay := (t.Weekday() + 6) % 7
+	return (w.startsOn(t.Weekday()) && m >= start) || (w.startsOn(previousDay) && m < end)
+}
+
+// window returns the first window that contains the time, or nil if there is none.
+func (s Schedule) window(t time.Time) *ScheduleWindow {
+	local := t.In(s.location())
+	for i := range s.Windows {
+		if s.Windows[i].contains(local) {
+			return &s.Windows[i]
+		}
+	}
+	return nil
+}
+
+// PeriodicityAt returns the periodicity with which the feed is downloaded at the time. The
+// boolean return value is false if the time is outside of the feed's schedule windows.
+func (f *Feed) PeriodicityAt(t time.Time) (time.Duration, bool) {
+	if len(f.Schedule.Windows) == 0 {
+		return f.Periodicity, true
+	}
+	w := f.Schedule.window(t)
+	if w == nil {
+		return 0, false
+	}
+	if w.Periodicity > 0 {
+		return w.Periodicity, true
+	}
+	return f.Periodicity, true
+}
+
+// NextScheduledTime returns the first time, no earlier than the provided time, at which the
+// feed is scheduled to be downloaded.
+func (f *Feed) NextScheduledTime(t time.Time) time.Time {
+	if _, scheduled := f.PeriodicityAt(t); scheduled {
+		return t
+	}
+	loc := f.Schedule.location()
+	local := t.In(loc)
+	var next time.Time
+	for i := range f.Schedule.Windows {
+		w := &f.Schedule.Windows[i]
+		start, _ := w.clockTimes()
+		for days := 0; days <= 7; days++ {
+			candidate := time.Date(local.Year(), local.Month(), local.Day()+days, start/60, start%60, 0, 0, loc)
+			if !candidate.After(t) || !w.startsOn(candidate.Weekday()) {
+				continue
+			}
+			if next.IsZero() || candidate.Before(next) {
+				next = candidate
+			}
+			break
+		}
+	}
+	if next.IsZero() {
+		return t.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// IsScheduledBetween returns true if the feed is scheduled to be downloaded at some point in
+// the half-open interval [start, end).
+func (f *Feed) IsScheduledBetween(start, end time.Time) bool {
+	if !start.Before(end) {
+		return false
+	}
+	if len(f.Schedule.Windows) == 0 {
+		return true
+	}
+	loc := f.Schedule.location()
+	first := start.In(loc)
+	for i := range f.Schedule.Windows {
+		w := &f.Schedule.Windows[i]
+		windowStart, windowEnd := w.clockTimes()
+		duration := windowEnd - windowStart
+		if duration <= 0 {
+			duration += 24 * 60
+		}
+		// Each occurrence of the window starts on a day and lasts less than two days, so
+		// only the occurrences that start between the day before start and the day of end
+		// can intersect the interval.
+		for days := -1; ; days++ {
+			occurrenceStart := time.Date(first.Year(), first.Month(), first.Day()+days,
+				windowStart/60, windowStart%60, 0, 0, loc)
+			if !occurrenceStart.Before(end) {
+				break
+			}
+			if !w.startsOn(occurrenceStart.Weekday()) {
+				continue
+			}
+			occurrenceEnd := occurrenceStart.Add(time.Duration(duration) * time.Minute)
+			if start.Before(occurrenceEnd) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ResolveHeaderSecrets replaces the secret references in a rendered header value of the feed
+// with the values they refer to. The references were resolved when the config was loaded.
+func (f *Feed) ResolveHeaderSecrets(value string) string {
+	if resolved, ok := f.headerSecrets[value]; ok && strings.HasPrefix(value, fileReferencePrefix) {
+		return resolved
+	}
+	return envVarReference.ReplaceAllStringFunc(value, func(reference string) string {
+		if resolved, ok := f.headerSecrets[reference]; ok {
+			return resolved
+		}
+		return reference
+	})
+}
+
+// envVarReference matches references to environment variables, like ${NAME}.
+var envVarReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// fileReferencePrefix is the prefix of values that are read from a file, like
+// file:/run/secrets/secret_key.
+const fileReferencePrefix = "file:"
+
+// resolveSecretReferences replaces secret references in the object storage keys and feed
+// auth client secrets with the values they refer to. A value can contain environment
+// variable references, or can be a single file reference. Resolved values are automatically
+// treated as secrets.
+//
+// Secret references in feed header values are also resolved, but the header values are
+// templates and keep their references; see Feed.ResolveHeaderSecrets.
+func (c *Config) resolveSecretReferences() error {
+	for i := range c.ObjectStorage {
+		o := &c.ObjectStorage[i]
+		if err := c.resolveSecretReference(&o.AccessKey, nil); err != nil {
+			return fmt.Errorf("invalid access key for object storage %s: %w", o.Endpoint, err)
+		}
+		if err := c.resolveSecretReference(&o.SecretKey, nil); err != nil {
+			return fmt.Errorf("invalid secret key for object storage %s: %w", o.Endpoint, err)
+		}
+	}
+	for i := range c.Feeds {
+		f := &c.Feeds[i]
+		f.headerSecrets = map[string]string{}
+		for key, value := range f.Headers {
+			if err := c.resolveSecretReference(&value, f.headerSecrets); err != nil {
+				return fmt.Errorf("invalid configuration for feed %s: invalid value for header %s: %w", f.ID, key, err)
+			}
+		}
+		if f.Auth != nil {
+			if err := c.resolveSecretReference(&f.Auth.ClientSecret, nil); err != nil {
+				return fmt.Errorf("invalid configuration for feed %s: invalid client secret: %w", f.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecretReference replaces the secret references in the value with the values they
+// refer to. If references is not nil, each reference and its value are also added to it.
+func (c *Config) resolveSecretReference(value *string, references map[string]string) error {
+	if path, ok := strings.CutPrefix(*value, fileReferencePrefix); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read secret file: %w", err)
+		}
+		reference := *value
+		// Files created by editors and by echo usually end with a newline.
+		*value = strings.TrimRight(string(b), "\r\n")
+		c.resolvedSecrets = append(c.resolvedSecrets, *value)
+		if references != nil {
+			references[reference] = *value
+		}
+		return nil
+	}
+	var err error
+	*value = envVarReference.ReplaceAllStringFunc(*value, func(reference string) string {
+		name := envVarReference.FindStringSubmatch(reference)[1]
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		c.resolvedSecrets = append(c.resolvedSecrets, resolved)
+		if references != nil {
+			references[reference] = resolved
+		}
+		return resolved
+	})
+	return err
+}
+
+// redactedSecret replaces the secrets in the config before it is marshalled to YAML. It is
+// a plain word so that YAML never quotes or escapes it, and it can be reliably replaced in
+// the output.
+const redactedSecret = "HOARD_REDACTED_SECRET"
+
+func (c *Config) String() string {
+	b, err := yaml.Marshal(c.redacted())
+	if err != nil {
+		return "Error while marshalling config to YAML."
+	}
+	n := 40
+	return strings.ReplaceAll(string(b), redactedSecret, "<span class=\"secret\">"+strings.Repeat("&nbsp;", n)+"</span>")
+}
+
+// redacted returns a copy of the config in which the secrets in the fields that can
+// contain them are replaced by redactedSecret. The config itself is not modified.
+func (c *Config) redacted() *Config {
+	secrets := c.secrets()
+	redact := func(s string) string {
+		for _, secret := range secrets {
+			s = strings.ReplaceAll(s, secret, redactedSecret)
+		}
+		return s
+	}
+	redactAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		result := make([]string, len(values))
+		for i, value := range values {
+			result[i] = redact(value)
+		}
+		return result
+	}
+	redactMap := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		result := make(map[string]string, len(values))
+		for key, value := range values {
+			result[key] = redact(value)
+		}
+		return result
+	}
+	r := *c
+	r.Secrets = redactAll(c.Secrets)
+	r.ObjectStorage = make([]ObjectStorage, len(c.ObjectStorage))
+	for i, o := range c.ObjectStorage {
+		o.AccessKey = redact(o.AccessKey)
+		o.SecretKey = redact(o.SecretKey)
+		r.ObjectStorage[i] = o
+	}
+	r.Feeds = make([]Feed, len(c.Feeds))
+	for i, f := range c.Feeds {
+		f.URL = redact(f.URL)
+		f.URLs = redactAll(f.URLs)
+		f.Body = redact(f.Body)
+		f.Headers = redactMap(f.Headers)
+		f.Exec.Command = redactAll(f.Exec.Command)
+		if f.Auth != nil {
+			auth := *f.Auth
+			auth.TokenURL = redact(auth.TokenURL)
+			auth.ClientID = redact(auth.ClientID)
+			auth.ClientSecret = redact(auth.ClientSecret)
+			auth.Params = redactMap(auth.Params)
+			f.Auth = &auth
+		}
+		r.Feeds[i] = f
+	}
+	return &r
+}
+
+// secrets returns all values that should not be displayed when the config is printed.
+// This includes the user provided secrets, all feed auth client secrets and all values
+// resolved from secret references.
+func (c *Config) secrets() []string {
+	var secrets []string
+	for _, secret := range c.resolvedSecrets {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	for _, secret := range c.Secrets {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	for _, feed := range c.Feeds {
+		if feed.Auth != nil && feed.Auth.ClientSecret != "" {
+			secrets = append(secrets, feed.Auth.ClientSecret)
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Source is the kind of source a feed is downloaded from.
+type Source string
+
+const (
+	// SourceHTTP is a feed that is polled with HTTP requests. This is the default.
+	SourceHTTP Source = "http"
+	// SourceSSE is a feed that pushes messages as Server-Sent Events.
+	SourceSSE Source = "sse"
+	// SourceWebSocket is a feed that pushes messages over a WebSocket connection.
+	SourceWebSocket Source = "websocket"
+	// SourceFile is a local spool directory, given as a file:// URL, in which another
+	// process writes files.
+	SourceFile Source = "file"
+	// SourceExec is a command that prints the feed data to stdout.
+	SourceExec Source = "exec"
+)
+
+// SourceActual returns the kind of source the feed is downloaded from. If the source is not
+// specified, feeds with a file:// URL are file sources and all other feeds are HTTP sources.
+func (f *Feed) SourceActual() Source {
+	if f.Source == "" {
+		if strings.HasPrefix(f.URL, "file://") {
+			return SourceFile
+		}
+		return SourceHTTP
+	}
+	return f.Source
+}
+
+// SpoolDir returns the directory of a file source.
+func (f *Feed) SpoolDir() string {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// IsStreaming returns true if the feed keeps a connection open and receives messages,
+// rather than being polled.
+func (f *Feed) IsStreaming() bool {
+	source := f.SourceActual()
+	return source == SourceSSE || source == SourceWebSocket
+}
+
+func (f *Feed) validateSource() error {
+	source := f.SourceActual()
+	if source != SourceExec && len(f.Exec.Command) > 0 {
+		return fmt.Errorf("a command can only be specified for exec feeds")
+	}
+	if !f.IsStreaming() && f.Stream != (Stream{}) {
+		return fmt.Errorf("stream settings can only be specified for sse and websocket feeds")
+	}
+	switch source {
+	case SourceHTTP:
+		return nil
+	case SourceWebSocket:
+		if f.Method != "" || f.Body != "" || f.BodyFile != "" {
+			return fmt.Errorf("the method and body cannot be specified for WebSocket feeds")
+		}
+	case SourceSSE:
+	case SourceFile:
+		u, err := url.Parse(f.URL)
+		if err != nil || u.Scheme != "file" || u.Path == "" || len(f.URLs) > 0 {
+			return fmt.Errorf("file feeds must have a single file:// URL with the path of a directory")
+		}
+	case SourceExec:
+		if len(f.Exec.Command) == 0 {
+			return fmt.Errorf("the command must be specified for exec feeds")
+		}
+		if f.Exec.Timeout < 0 {
+			return fmt.Errorf("the command timeout cannot be negative")
+		}
+	default:
+		return fmt.Errorf("unknown source %q", f.Source)
+	}
+	if len(f.Validation.ContentTypes) > 0 {
+		return fmt.Errorf("content type validation is only supported for HTTP feeds")
+	}
+	if f.Stream.BatchWindow < 0 || f.Stream.InitialBackoff < 0 || f.Stream.MaxBackoff < 0 {
+		return fmt.Errorf("the stream batch window and backoffs cannot be negative")
+	}
+	return nil
+}
+
+// Exec specifies the command run by an exec source. The command is run directly, not
+// through a shell, and its stdout is stored.
+type Exec struct {
+	// Command is the program to run followed by its arguments.
+	Command []string
+	Timeout time.Duration `yaml:",omitempty"`
+}
+
+const defaultExecTimeout = time.Minute
+
+// TimeoutActual returns the time after which the command is killed.
+func (e Exec) TimeoutActual() time.Duration {
+	if e.Timeout <= 0 {
+		return defaultExecTimeout
+	}
+	return e.Timeout
+}
+
+// Stream specifies how messages from a streaming feed are stored, and how the feed is
+// reconnected to when the connection fails.
+type Stream struct {
+	// BatchWindow is the length of the time windows in which messages are batched. All
+	// messages received in a window are stored in a single DFile, separated by newlines. If
+	// zero, each message is stored in its own DFile.
+	BatchWindow time.Duration `yaml:"batchWindow,omitempty"`
+	// InitialBackoff is the time to wait before the first reconnection attempt. The wait
+	// doubles with each failed attempt, up to MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff,omitempty"`
+}
+
+const defaultMaxReconnectBackoff = time.Minute
+
+// InitialBackoffActual returns the time to wait before the first reconnection attempt.
+func (s Stream) InitialBackoffActual() time.Duration {
+	if s.InitialBackoff <= 0 {
+		return defaultInitialBackoff
+	}
+	return s.InitialBackoff
+}
+
+// MaxBackoffActual returns the maximum time to wait between two reconnection attempts.
+func (s Stream) MaxBackoffActual() time.Duration {
+	if s.MaxBackoff <= 0 {
+		return max(defaultMaxReconnectBackoff, s.InitialBackoffActual())
+	}
+	return max(s.MaxBackoff, s.InitialBackoffActual())
+}
@@ -12,6 +12,8 @@ import (
 
 	"github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/hoard/internal/archive"
+	"github.com/jamespfennell/hoard/internal/ratelimit"
+	"github.com/jamespfennell/hoard/internal/replicas"
 	"github.com/jamespfennell/hoard/internal/server"
 	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/storage/astore"
@@ -32,6 +34,8 @@ const ManifestFileName = archive.ManifestFileName
 const DownloadsSubDir = tasks.DownloadsSubDir
 const ArchivesSubDir = tasks.ArchivesSubDir
 const TmpSubDir = tasks.TmpSubDir
+const QuarantineSubDir = tasks.QuarantineSubDir
+const MetadataFileSuffix = storage.MetadataFileSuffix
 
 // RunCollector runs a Hoard collection server.
 func RunCollector(ctx context.Context, c *config.Config) error {
@@ -48,16 +52,37 @@ func RunCollector(ctx context.Context, c *config.Config) error {
 		cancelFunc()
 		w.Done()
 	}()
-	for _, feed := range c.Feeds {
+	rateLimits := ratelimit.NewRegistry(c.RateLimits)
+	coordinator, err := replicas.NewCoordinator(c, log)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to initialize replica coordination, downloads will not be coordinated: %s", err))
+	}
+	if coordinator != nil {
+		w.Add(1)
+		go func() {
+			coordinator.Run(ctx)
+			w.Done()
+		}()
+	}
+	for _, feed := range c.AllFeeds() {
 		feed := feed
-		session := tasks.NewSession(&feed, c, log, ctx, true)
+		session := tasks.NewSession(&feed, c, rateLimits, coordinator, log, ctx, true)
+		if !feed.IsErrorsFeed() {
+			// Errors feeds are not downloaded; their files are stored by the downloader of
+			// the feed they were derived from.
+			w.Add(1)
+			go func() {
+				download.RunPeriodically(session)
+				w.Done()
+			}()
+		}
 		w.Add(4)
 		go func() {
-			download.RunPeriodically(session)
+			pack.RunPeriodically(session)
 			w.Done()
 		}()
 		go func() {
-			pack.RunPeriodically(session)
+			upload.RunPartialsPeriodically(session)
 			w.Done()
 		}()
 		go func() {
@@ -79,28 +104,28 @@ func RunCollector(ctx context.Context, c *config.Config) error {
 }
 
 func Download(c *config.Config) error {
-	return executeInSession(c, download.RunOnce)
+	return executeInSession(c, c.Feeds, download.RunOnce)
 }
 
 func Pack(c *config.Config) error {
-	return executeInSession(c, func(session *tasks.Session) error {
+	return executeInSession(c, c.AllFeeds(), func(session *tasks.Session) error {
 		return pack.RunOnce(session, false)
 	})
 }
 
 func Merge(c *config.Config) error {
-	return executeInSession(c, func(session *tasks.Session) error {
+	return executeInSession(c, c.AllFeeds(), func(session *tasks.Session) error {
 		_, err := merge.RunOnce(session, session.LocalAStore())
 		return err
 	})
 }
 
 func Upload(c *config.Config) error {
-	return executeInSession(c, upload.RunOnce)
+	return executeInSession(c, c.AllFeeds(), upload.RunOnce)
 }
 
 func Audit(c *config.Config, startOpt *time.Time, end time.Time, enforceCompression bool, fixProblems bool) error {
-	return executeInSession(c, func(session *tasks.Session) error {
+	return executeInSession(c, c.AllFeeds(), func(session *tasks.Session) error {
 		return audit.RunOnce(session, timeToHour(startOpt), *timeToHour(&end),
 			!c.DisableMerging, enforceCompression, fixProblems)
 	})
@@ -111,20 +136,37 @@ type RetrieveOptions struct {
 	KeepPacked      bool
 	FlattenTimeDirs bool
 	FlattenFeedDirs bool
-	Start           time.Time
-	End             time.Time
+	// Metadata enables writing the metadata of each downloaded file to a file next to it.
+	// It has no effect if KeepPacked is true, as the metadata is contained in the archives.
+	Metadata bool
+	// Errors enables retrieving the failed responses kept for each feed instead of the feed
+	// data.
+	Errors bool
+	// Partials enables retrieving partial archives, which contain data for buckets that were
+	// still in progress when the archives were uploaded. The data in partial archives is
+	// also contained in the final archives, once those have been uploaded.
+	Partials bool
+	Start    time.Time
+	End      time.Time
 }
 
 func Retrieve(c *config.Config, options RetrieveOptions) error {
-	statusWriter := retrieve.NewStatusWriter(c.Feeds)
-	return executeInSession(c, func(session *tasks.Session) error {
+	feeds := c.Feeds
+	if options.Errors {
+		feeds = c.ErrorsFeeds()
+		if len(feeds) == 0 {
+			return fmt.Errorf("no feeds are configured to keep failed responses")
+		}
+	}
+	statusWriter := retrieve.NewStatusWriter(feeds)
+	return executeInSession(c, feeds, func(session *tasks.Session) error {
 		start := *timeToHour(&options.Start)
 		end := *timeToHour(&options.End)
 		if options.KeepPacked {
-			return retrieve.RunOnceWithoutUnpacking(session, statusWriter, start, end,
+			return retrieve.RunOnceWithoutUnpacking(session, statusWriter, start, end, options.Partials,
 				aStoreForRetrieval(session.Feed(), options, session.Log()))
 		}
-		return retrieve.RunOnceWithUnpacking(session, statusWriter, start, end,
+		return retrieve.RunOnceWithUnpacking(session, statusWriter, start, end, options.Partials,
 			dStoreForRetrieval(session.Feed(), options, session.Log()))
 	})
 }
@@ -145,12 +187,13 @@ func newLogger(c *config.Config) *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: c.LogLevelParsed()}))
 }
 
-func executeInSession(c *config.Config, f func(session *tasks.Session) error) error {
+func executeInSession(c *config.Config, feeds []config.Feed, f func(session *tasks.Session) error) error {
 	var eg util.ErrorGroup
 	log := newLogger(c)
-	for _, feed := range c.Feeds {
+	rateLimits := ratelimit.NewRegistry(c.RateLimits)
+	for _, feed := range feeds {
 		feed := feed
-		session := tasks.NewSession(&feed, c, log, context.Background(), false)
+		session := tasks.NewSession(&feed, c, rateLimits, nil, log, context.Background(), false)
 		eg.Add(1)
 		f := func() {
 			err := f(session)
@@ -176,10 +219,21 @@ func dStoreForRetrieval(feed *config.Feed, options RetrieveOptions, log *slog.Lo
 		root = path.Join(root, feed.ID)
 	}
 	store := persistence.NewDiskPersistedStorage(root)
+	var d storage.WritableDStore
 	if options.FlattenTimeDirs {
-		return dstore.NewFlatPersistedDStore(store)
+		d = dstore.NewFlatPersistedDStore(store)
+	} else {
+		d = dstore.NewPersistedDStore(store, log)
 	}
-	return dstore.NewPersistedDStore(store, log)
+	if !options.Metadata {
+		// Unpacking writes metadata to DStores that support it. We hide that support.
+		d = dStoreWithoutMetadata{d}
+	}
+	return d
+}
+
+type dStoreWithoutMetadata struct {
+	storage.WritableDStore
 }
 
 // aStoreForRetrieval returns a AStore that the retrieve task can use to retrieve
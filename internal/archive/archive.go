@@ -10,8 +10,10 @@ import (
 	"github.com/jamespfennell/hoard/internal/archive/manifest"
 	"github.com/jamespfennell/hoard/internal/monitoring"
 	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
 	"github.com/jamespfennell/hoard/internal/storage/hour"
 	"io"
+	"io/fs"
 	"time"
 )
 
@@ -31,14 +33,41 @@ const ManifestFileName = ".hoard_manifest.json"
 // means the function can at least succeed if some DFiles can be written.
 func CreateFromDFiles(feed *config.Feed, dFiles []storage.DFile,
 	sourceDStore storage.ReadableDStore, targetAStore storage.WritableAStore) (storage.AFile, []storage.DFile, error) {
+	return createFromDFiles(feed, dFiles, sourceDStore, targetAStore, false)
+}
+
+// CreatePartialFromDFiles is the same as CreateFromDFiles except that the AFile created is
+// marked as partial. This is used when packing DFiles for an hour that is still in progress;
+// the DFiles should not be deleted afterward, as they will be packed again into the final
+// AFile for the hour.
+func CreatePartialFromDFiles(feed *config.Feed, dFiles []storage.DFile,
+	sourceDStore storage.ReadableDStore, targetAStore storage.WritableAStore) (storage.AFile, []storage.DFile, error) {
+	return createFromDFiles(feed, dFiles, sourceDStore, targetAStore, true)
+}
+
+func createFromDFiles(feed *config.Feed, dFiles []storage.DFile,
+	sourceDStore storage.ReadableDStore, targetAStore storage.WritableAStore, partial bool) (storage.AFile, []storage.DFile, error) {
 	if len(dFiles) == 0 {
 		return storage.AFile{}, nil, fmt.Errorf("archive cannot contain zero downloaded files")
 	}
 	storage.Sort(dFiles)
 	t := dFiles[0].Time
-	m := manifest.NewManifest(hour.Date(t.Year(), t.Month(), t.Day(), t.Hour()))
+	m := manifest.NewManifest(hour.FromTimeWithWidth(t, feed.BucketWidthActual()))
 	m.AddOriginalDFiles(dFiles)
-	arc := createArchive(feed, *m, sourceDStore)
+	if metadataDStore, ok := sourceDStore.(storage.ReadableMetadataDStore); ok {
+		for _, dFile := range dFiles {
+			metadata, err := metadataDStore.GetMetadata(dFile)
+			if err != nil {
+				fmt.Printf("Error when reading DFile metadata: %s\n", err)
+				continue
+			}
+			if metadata != nil {
+				m.AddDFileMetadata(dFile, *metadata)
+			}
+		}
+	}
+	arc := createArchive(feed, *m, sourceDStore, compressionFor(feed, targetAStore))
+	arc.partial = partial
 	if err := targetAStore.Store(arc.AFile(), arc.Reader()); err != nil {
 		_ = arc.Close()
 		return storage.AFile{}, nil, err
@@ -56,6 +85,9 @@ func CreateFromDFiles(feed *config.Feed, dFiles []storage.DFile,
 // successfully written to the archive. It is safe to delete these AFiles afterward because their contents are
 // guaranteed to be present in the new AFile.
 //
+// The new AFile is partial only if all of the AFiles incorporated into it are partial. Merging
+// a partial AFile into a final AFile thus removes the partial AFile.
+//
 // Errors encountered when creating the archive are handled in one of two ways. If the error concerns a single AFile
 // (for example, it doesn't exist in the AStore) then the error is essentially ignored and that DFile will not be
 // returned in the slice. Otherwise, errors are propagated through the returned error type. This two-prong approach
@@ -72,11 +104,13 @@ func CreateFromAFiles(feed *config.Feed, aFiles []storage.AFile, sourceAStore st
 	}
 	var unpackedAFiles []storage.AFile
 	var unpackedDFiles []storage.DFile
+	partial := true
 	for _, aFile := range aFiles {
 		childM, dFiles, err := unpackInternal(aFile, sourceAStore, dStore)
 		if err != nil {
 			continue
 		}
+		partial = partial && aFile.Partial
 		unpackedDFiles = append(unpackedDFiles, dFiles...)
 		unpackedAFiles = append(unpackedAFiles, aFile)
 		m.AddChildManifest(childM)
@@ -99,8 +133,9 @@ func CreateFromAFiles(feed *config.Feed, aFiles []storage.AFile, sourceAStore st
 	}
 	m.AddOriginalDFiles(unaccountedForDFiles)
 
-	a := createArchive(feed, *m, dStore)
+	a := createArchive(feed, *m, dStore, compressionFor(feed, targetAStore))
 	a.IncorporatedAFiles = unpackedAFiles
+	a.partial = partial
 
 	if err := targetAStore.Store(a.AFile(), a.Reader()); err != nil {
 		_ = a.Close()
@@ -110,6 +145,9 @@ func CreateFromAFiles(feed *config.Feed, aFiles []storage.AFile, sourceAStore st
 }
 
 // Unpack reads the contents of an AFile into the provided DStore.
+//
+// If the DStore is a storage.WritableMetadataDStore, the metadata of the DFiles recorded in
+// the archive's manifest is also stored.
 func Unpack(aFile storage.AFile, aStore storage.ReadableAStore, dStore storage.WritableDStore) error {
 	_, _, err := unpackInternal(aFile, aStore, dStore)
 	return err
@@ -117,6 +155,10 @@ func Unpack(aFile storage.AFile, aStore storage.ReadableAStore, dStore storage.W
 
 // Recompress reads the provided AFile from the source AStore and recompresses the archive so that its compression
 // settings match those of the feed configuration. If the compression settings already match, this is a no-op.
+//
+// If the feed uses seekable archives or a dictionary, the archive is rebuilt so that it is seekable or uses the latest
+// dictionary. In this case the new AFile may have a different hash. An error is returned if the feed uses a
+// dictionary but there is no dictionary yet.
 func Recompress(feed *config.Feed, aFile storage.AFile,
 	sourceAStore storage.ReadableAStore, targetAStore storage.WritableAStore) (newAFile storage.AFile, err error) {
 	newAFile = aFile
@@ -124,6 +166,21 @@ func Recompress(feed *config.Feed, aFile storage.AFile,
 	if newAFile.Compression.Equals(aFile.Compression) {
 		return
 	}
+	if feed.Compression.UsesDictionary() {
+		var version storage.Hash
+		version, _, err = latestDictionary(targetAStore)
+		if err != nil {
+			return
+		}
+		if version == "" {
+			err = fmt.Errorf("cannot recompress %s because feed %s does not have a dictionary yet", aFile, feed.ID)
+			return
+		}
+	}
+	if feed.SeekableArchives || feed.Compression.UsesDictionary() {
+		newAFile, _, err = CreateFromAFiles(feed, []storage.AFile{aFile}, sourceAStore, targetAStore, dstore.NewInMemoryDStore())
+		return
+	}
 	source, err := sourceAStore.Get(aFile)
 	if err != nil {
 		return
@@ -134,7 +191,7 @@ func Recompress(feed *config.Feed, aFile storage.AFile,
 			err = newErr
 		}
 	}()
-	decompressor, err := aFile.Compression.NewReader(source)
+	decompressor, err := newDecompressor(aFile, sourceAStore, source)
 	if err != nil {
 		return
 	}
@@ -169,7 +226,7 @@ func unpackInternal(aFile storage.AFile, aStore storage.ReadableAStore, dStore s
 		return nil, nil, err
 	}
 	defer reader.Close()
-	gzr, err := aFile.Compression.NewReader(reader)
+	gzr, err := newDecompressor(aFile, aStore, reader)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -177,7 +234,10 @@ func unpackInternal(aFile storage.AFile, aStore storage.ReadableAStore, dStore s
 	tr := tar.NewReader(gzr)
 
 	var m *manifest.Manifest
+	var deltas *deltaDecoder
 	var dFiles []storage.DFile
+	var dFileMetadata map[storage.DFile]storage.DFileMetadata
+	metadataDStore, _ := dStore.(storage.WritableMetadataDStore)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -195,6 +255,10 @@ func unpackInternal(aFile storage.AFile, aStore storage.ReadableAStore, dStore s
 				fmt.Printf("The manifest is corrupted: %s; skipping\n", err)
 				continue
 			}
+			dFileMetadata = m.DFileMetadata()
+			if m.Encoding() == manifest.EncodingDelta {
+				deltas = &deltaDecoder{}
+			}
 			continue
 		}
 		dFile, ok := storage.NewDFileFromString(header.Name)
@@ -203,20 +267,39 @@ func unpackInternal(aFile storage.AFile, aStore storage.ReadableAStore, dStore s
 			_, _ = io.ReadAll(tr)
 			continue
 		}
-		if err := dStore.Store(dFile, tr); err != nil {
+		var content io.Reader = tr
+		if deltas != nil {
+			b, err := deltas.read(header, tr)
+			if err != nil {
+				return nil, nil, err
+			}
+			content = bytes.NewReader(b)
+		} else if isDelta(header) {
+			fmt.Printf("Unable to decode DFile %s because the manifest does not record the delta encoding; skipping\n", dFile)
+			continue
+		}
+		if err := dStore.Store(dFile, content); err != nil {
 			fmt.Printf("Error when storing DFile: %s", err)
 			continue
 		}
 		dFiles = append(dFiles, dFile)
+		if metadata, ok := dFileMetadata[dFile]; ok && metadataDStore != nil {
+			if err := metadataDStore.StoreMetadata(dFile, metadata); err != nil {
+				fmt.Printf("Error when storing DFile metadata: %s\n", err)
+			}
+		}
 	}
 	return m, dFiles, nil
 }
 
-func createArchive(feed *config.Feed, m manifest.Manifest, dStore storage.ReadableDStore) *archive {
+func createArchive(feed *config.Feed, m manifest.Manifest, dStore storage.ReadableDStore, c archiveCompression) *archive {
 	dFiles := make([]storage.DFile, 0, len(m.DFiles()))
 	for manifestDFile := range m.DFiles() {
 		dFiles = append(dFiles, manifestDFile)
 	}
+	if feed.DeltaEncoding.Enabled {
+		m.SetEncoding(manifest.EncodingDelta)
+	}
 	reader, writer := io.Pipe()
 	a := &archive{
 		IncorporatedDFiles: dFiles,
@@ -224,6 +307,7 @@ func createArchive(feed *config.Feed, m manifest.Manifest, dStore storage.Readab
 		readCloser:         reader,
 		feed:               feed,
 		manifest:           m,
+		compression:        c,
 	}
 	go a.write(writer, dStore)
 	return a
@@ -238,6 +322,8 @@ type archive struct {
 	uncompressedSize    int
 	feed                *config.Feed
 	manifest            manifest.Manifest
+	partial             bool
+	compression         archiveCompression
 }
 
 func (archive *archive) AFile() storage.AFile {
@@ -245,7 +331,9 @@ func (archive *archive) AFile() storage.AFile {
 		Prefix:      archive.feed.Prefix(),
 		Hour:        archive.manifest.Hour(),
 		Hash:        archive.manifest.CalculateHash(),
-		Compression: archive.feed.Compression,
+		Compression: archive.compression.compression,
+		Partial:     archive.partial,
+		Dictionary:  archive.compression.dictionary,
 	}
 }
 func (archive *archive) Reader() io.Reader {
@@ -258,7 +346,14 @@ func (archive *archive) Close() error {
 
 func (archive *archive) write(writer *io.PipeWriter, dStore storage.ReadableDStore) {
 	compressedBytesWriter := byteCounterWriter{Writer: writer}
-	gzw := archive.feed.Compression.NewWriter(&compressedBytesWriter)
+	var gzw io.WriteCloser
+	var seekable *seekableWriter
+	if archive.feed.SeekableArchives {
+		seekable = newSeekableWriter(&compressedBytesWriter, archive.feed.Compression.LevelActual())
+		gzw = seekable
+	} else {
+		gzw = archive.compression.newWriter(&compressedBytesWriter)
+	}
 	uncompressedBytesWriter := byteCounterWriter{Writer: gzw}
 	defer func() {
 		_ = writer.CloseWithError(gzw.Close())
@@ -270,23 +365,57 @@ func (archive *archive) write(writer *io.PipeWriter, dStore storage.ReadableDSto
 			_ = writer.CloseWithError(err)
 		}
 	}()
+	// In seekable archives each entry is written to its own frame.
+	var lastFrame seekableFrame
+	endEntry := func(name string) error {
+		if seekable == nil {
+			return nil
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		var err error
+		lastFrame, err = seekable.EndFrame(name)
+		return err
+	}
 
 	b, _ := archive.manifest.Serialize()
 	if err := writeFileToArchive(tw, ManifestFileName, time.Now(), b); err != nil {
 		_ = writer.CloseWithError(err)
 		return
 	}
+	if err := endEntry(ManifestFileName); err != nil {
+		_ = writer.CloseWithError(err)
+		return
+	}
 	var lastHash storage.Hash
 	dFiles := make([]storage.DFile, 0, len(archive.manifest.DFiles()))
 	for dFile := range archive.manifest.DFiles() {
 		dFiles = append(dFiles, dFile)
 	}
 	storage.Sort(dFiles)
+	var deltas *deltaEncoder
+	if archive.manifest.Encoding() == manifest.EncodingDelta {
+		deltas = &deltaEncoder{maxChainLength: archive.feed.DeltaEncoding.MaxChainLengthActual()}
+	}
 	for _, dFile := range dFiles {
 		if lastHash == dFile.Hash {
+			if seekable != nil {
+				seekable.index.Frames[dFile.String()] = lastFrame
+			}
 			continue
 		}
-		if err := writeDFileToArchive(tw, dFile, dStore); err != nil {
+		var err error
+		if deltas != nil {
+			err = deltas.write(tw, dFile, dStore)
+		} else {
+			err = writeDFileToArchive(tw, dFile, dStore)
+		}
+		if err != nil {
+			_ = writer.CloseWithError(err)
+			return
+		}
+		if err := endEntry(dFile.String()); err != nil {
 			_ = writer.CloseWithError(err)
 			return
 		}
@@ -294,23 +423,46 @@ func (archive *archive) write(writer *io.PipeWriter, dStore storage.ReadableDSto
 	}
 }
 
+// writeDFileToArchive writes the DFile to the archive. If the size of the DFile can be
+// determined upfront, which is the case for DFiles stored on disk, the content is streamed
+// into the archive. Otherwise, the content is read into memory first.
 func writeDFileToArchive(tw *tar.Writer, dFile storage.DFile, dStore storage.ReadableDStore) error {
 	content, err := dStore.Get(dFile)
 	if err != nil {
 		return err
 	}
-	b, err := io.ReadAll(content)
+	statter, ok := content.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		b, err := io.ReadAll(content)
+		if err != nil {
+			_ = content.Close()
+			return err
+		}
+		if err := content.Close(); err != nil {
+			return err
+		}
+		return writeFileToArchive(tw, dFile.String(), dFile.Time, b)
+	}
+	info, err := statter.Stat()
 	if err != nil {
 		_ = content.Close()
 		return err
 	}
-	if err := content.Close(); err != nil {
+	hdr := &tar.Header{
+		Name:    dFile.String(),
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: dFile.Time,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		_ = content.Close()
 		return err
 	}
-	if err := writeFileToArchive(tw, dFile.String(), dFile.Time, b); err != nil {
+	if _, err := io.CopyN(tw, content, info.Size()); err != nil {
+		_ = content.Close()
 		return err
 	}
-	return nil
+	return content.Close()
 }
 
 func writeFileToArchive(tw *tar.Writer, fileName string, modTime time.Time, content []byte) error {
@@ -1,15 +1,24 @@
 package archive_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/hoard/internal/archive"
+	"github.com/jamespfennell/hoard/internal/dictionary"
 	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/storage/astore"
 	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/storage/hour"
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
 	"github.com/jamespfennell/hoard/internal/util/testutil"
+	"io"
+	"log/slog"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCreateFromDFiles(t *testing.T) {
@@ -90,6 +99,212 @@ func TestCreateFromAFiles(t *testing.T) {
 	testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data1, data2)
 }
 
+func TestCreateFromAFiles_Partial(t *testing.T) {
+	feed := &config.Feed{}
+	sourceAStore := astore.NewInMemoryAStore()
+	data1 := testutil.Data[0]
+	data2 := testutil.Data[1]
+	createPartial := func(data testutil.DFileData) storage.AFile {
+		dStore := dstore.NewInMemoryDStore()
+		testutil.ErrorOrFail(t, dStore.Store(data.DFile, bytes.NewReader(data.Content)))
+		aFile, _, err := archive.CreatePartialFromDFiles(feed, []storage.DFile{data.DFile}, dStore, sourceAStore)
+		testutil.ErrorOrFail(t, err)
+		if !aFile.Partial {
+			t.Errorf("Expected %s to be partial", aFile)
+		}
+		return aFile
+	}
+	partial1 := createPartial(data1)
+	partial2 := createPartial(data2)
+	final := testutil.CreateArchiveFromData(t, feed, sourceAStore, data2)
+
+	for _, testCase := range []struct {
+		aFiles          []storage.AFile
+		expectedPartial bool
+	}{
+		{[]storage.AFile{partial1, partial2}, true},
+		{[]storage.AFile{partial1, final}, false},
+	} {
+		newAFile, _, err := archive.CreateFromAFiles(feed, testCase.aFiles,
+			sourceAStore, astore.NewInMemoryAStore(), dstore.NewInMemoryDStore())
+		testutil.ErrorOrFail(t, err)
+		if newAFile.Partial != testCase.expectedPartial {
+			t.Errorf("Unexpected partial %t for merged AFile %s; expected %t",
+				newAFile.Partial, newAFile, testCase.expectedPartial)
+		}
+	}
+}
+
+func TestSeekableArchive(t *testing.T) {
+	feed := &config.Feed{
+		Compression:      config.NewSpecWithLevel(config.Zstd, 3),
+		SeekableArchives: true,
+	}
+	for _, aStore := range []storage.AStore{
+		astore.NewPersistedAStore(persistence.NewInMemoryPersistedStorage(), slog.Default()),
+		astore.NewInMemoryAStore(),
+	} {
+		aFile := testutil.CreateArchiveFromData(t, feed, aStore, testutil.Data...)
+
+		// Seekable archives can be unpacked like any other archive. The third DFile is a
+		// duplicate of the second and is not written to the archive, but it is in the index.
+		dStore := dstore.NewInMemoryDStore()
+		testutil.ErrorOrFail(t, archive.Unpack(aFile, aStore, dStore))
+		testutil.ExpectDStoreHasExactlyDFiles(t, dStore, testutil.Data[0], testutil.Data[1], testutil.Data[3])
+
+		dFiles, err := archive.ReadIndex(aFile, aStore)
+		testutil.ErrorOrFail(t, err)
+		if len(dFiles) != len(testutil.Data) {
+			t.Errorf("Unexpected number of DFiles %d in index; expected %d", len(dFiles), len(testutil.Data))
+		}
+		for _, data := range testutil.Data {
+			content, err := archive.ReadDFile(aFile, aStore, data.DFile)
+			testutil.ErrorOrFail(t, err)
+			if !testutil.CompareBytes(content, data.Content) {
+				t.Errorf("Unexpected content %v for %s; expected %v", content, data.DFile, data.Content)
+			}
+		}
+	}
+}
+
+func TestReadDFile_NotSeekable(t *testing.T) {
+	feed := &config.Feed{}
+	aStore := astore.NewInMemoryAStore()
+	aFile := testutil.CreateArchiveFromData(t, feed, aStore, testutil.Data...)
+
+	if _, err := archive.ReadIndex(aFile, aStore); !errors.Is(err, archive.ErrNotSeekable) {
+		t.Errorf("Unexpected error %v; expected %v", err, archive.ErrNotSeekable)
+	}
+	content, err := archive.ReadDFile(aFile, aStore, testutil.Data[3].DFile)
+	testutil.ErrorOrFail(t, err)
+	if !testutil.CompareBytes(content, testutil.Data[3].Content) {
+		t.Errorf("Unexpected content %v; expected %v", content, testutil.Data[3].Content)
+	}
+}
+
+func TestDictionaryArchive(t *testing.T) {
+	feed := &config.Feed{Compression: config.NewSpecWithLevel(config.ZstdDict, 3)}
+	dictionaries := dictionary.NewInMemoryStore(feed)
+	aStore := astore.WithDictionaries(astore.NewInMemoryAStore(), dictionaries)
+	data := []testutil.DFileData{testutil.Data[0], testutil.Data[1], testutil.Data[3]}
+
+	// Before the feed has a dictionary, archives are compressed using zstd without one.
+	oldAFile := testutil.CreateArchiveFromData(t, feed, aStore, data...)
+	if oldAFile.Compression.Format != config.Zstd {
+		t.Errorf("Unexpected compression format %d; expected %d", oldAFile.Compression.Format, config.Zstd)
+	}
+	if _, err := archive.Recompress(feed, oldAFile, aStore, aStore); err == nil {
+		t.Errorf("Expected error when recompressing before the feed has a dictionary")
+	}
+
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id":%d,"status":"ok","values":[%d,%d]}`, i, i*i, i%3)))
+	}
+	content, err := dictionary.Train(samples, 2048)
+	testutil.ErrorOrFail(t, err)
+	version, err := dictionaries.Add(content, time.Now())
+	testutil.ErrorOrFail(t, err)
+
+	aFile := testutil.CreateArchiveFromData(t, feed, aStore, data...)
+	if aFile.Compression.Format != config.ZstdDict || aFile.Dictionary != version {
+		t.Errorf("Unexpected AFile %s; expected dictionary version %s to be used", aFile, version)
+	}
+	dStore := dstore.NewInMemoryDStore()
+	testutil.ErrorOrFail(t, archive.Unpack(aFile, aStore, dStore))
+	testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data...)
+
+	newAFile, err := archive.Recompress(feed, oldAFile, aStore, aStore)
+	testutil.ErrorOrFail(t, err)
+	if newAFile.Dictionary != version {
+		t.Errorf("Unexpected dictionary version %s after recompressing; expected %s", newAFile.Dictionary, version)
+	}
+	dStore = dstore.NewInMemoryDStore()
+	testutil.ErrorOrFail(t, archive.Unpack(newAFile, aStore, dStore))
+	testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data...)
+
+	// The archive cannot be read from an AStore that does not provide the dictionary.
+	plainAStore := astore.NewInMemoryAStore()
+	testutil.ErrorOrFail(t, storage.CopyAFile(aStore, plainAStore, aFile))
+	if err := archive.Unpack(aFile, plainAStore, dstore.NewInMemoryDStore()); err == nil {
+		t.Errorf("Expected error when unpacking without the dictionary")
+	}
+}
+
+// similarData returns DFiles with large and mostly identical content, like consecutive
+// snapshots of a feed.
+func similarData(n int) []testutil.DFileData {
+	var data []testutil.DFileData
+	start := time.Date(2000, 1, 2, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		var b bytes.Buffer
+		for j := 0; j < 100; j++ {
+			_, _ = fmt.Fprintf(&b, `{"vehicle":%d,"position":%d},`, j, (i*j)%7)
+		}
+		dFile := storage.DFile{Time: start.Add(time.Duration(i) * time.Minute), Hash: storage.CalculateHash(b.Bytes())}
+		data = append(data, testutil.DFileData{Content: b.Bytes(), DFile: dFile, Hour: hour.FromTime(dFile.Time)})
+	}
+	return data
+}
+
+func TestDeltaEncodedArchive(t *testing.T) {
+	data := similarData(7)
+	for _, feed := range []*config.Feed{
+		{DeltaEncoding: config.DeltaEncoding{Enabled: true, MaxChainLength: 2}},
+		{
+			Compression:      config.NewSpecWithLevel(config.Zstd, 3),
+			SeekableArchives: true,
+			DeltaEncoding:    config.DeltaEncoding{Enabled: true, MaxChainLength: 2},
+		},
+	} {
+		aStore := astore.NewInMemoryAStore()
+		aFile := testutil.CreateArchiveFromData(t, feed, aStore, data...)
+
+		// With a maximum chain length of 2, every third entry is stored in full.
+		var deltas []bool
+		reader, err := aStore.Get(aFile)
+		testutil.ErrorOrFail(t, err)
+		decompressor, err := aFile.Compression.NewReader(reader)
+		testutil.ErrorOrFail(t, err)
+		tr := tar.NewReader(decompressor)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			testutil.ErrorOrFail(t, err)
+			if hdr.Name != archive.ManifestFileName {
+				_, ok := hdr.PAXRecords["HOARD.delta.base"]
+				deltas = append(deltas, ok)
+			}
+		}
+		expected := []bool{false, true, true, false, true, true, false}
+		if !reflect.DeepEqual(deltas, expected) {
+			t.Errorf("Unexpected delta encoded entries %v; expected %v", deltas, expected)
+		}
+
+		dStore := dstore.NewInMemoryDStore()
+		testutil.ErrorOrFail(t, archive.Unpack(aFile, aStore, dStore))
+		testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data...)
+
+		for _, d := range data {
+			content, err := archive.ReadDFile(aFile, aStore, d.DFile)
+			testutil.ErrorOrFail(t, err)
+			if !bytes.Equal(content, d.Content) {
+				t.Errorf("Unexpected content for %s", d.DFile)
+			}
+		}
+
+		// Merging decodes the deltas, and the new archive uses the encoding of the feed.
+		targetAStore := astore.NewInMemoryAStore()
+		newAFile, _, err := archive.CreateFromAFiles(&config.Feed{}, []storage.AFile{aFile}, aStore, targetAStore, dstore.NewInMemoryDStore())
+		testutil.ErrorOrFail(t, err)
+		dStore = dstore.NewInMemoryDStore()
+		testutil.ErrorOrFail(t, archive.Unpack(newAFile, targetAStore, dStore))
+		testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data...)
+	}
+}
+
 // TODO Merge two archives together, (A A) and (B) and ensure 3 files (ABA) are outputted
 //  ^ this test is basically why we have a manifest
 // TODO Case when two archives contain the identical DFile
@@ -132,3 +347,61 @@ func TestRecompress(t *testing.T) {
 		testutil.ExpectDStoreHasExactlyDFiles(t, dStore, data...)
 	}
 }
+
+func TestCreateFromDFiles_StreamsFromDisk(t *testing.T) {
+	data1 := testutil.Data[0]
+	data2 := testutil.Data[1]
+	dStore := dstore.NewPersistedDStore(persistence.NewDiskPersistedStorage(t.TempDir()), slog.Default())
+	testutil.ErrorOrFail(t, dStore.Store(data1.DFile, bytes.NewReader(data1.Content)))
+	testutil.ErrorOrFail(t, dStore.Store(data2.DFile, bytes.NewReader(data2.Content)))
+	aStore := astore.NewInMemoryAStore()
+
+	aFile, _, err := archive.CreateFromDFiles(
+		&config.Feed{}, []storage.DFile{data1.DFile, data2.DFile}, dStore, aStore)
+	testutil.ErrorOrFail(t, err)
+
+	unpacked := dstore.NewInMemoryDStore()
+	testutil.ErrorOrFail(t, archive.Unpack(aFile, aStore, unpacked))
+	testutil.ExpectDStoreHasExactlyDFiles(t, unpacked, data1, data2)
+}
+
+func TestCreateFromAFiles_Metadata(t *testing.T) {
+	feed := &config.Feed{}
+	sourceAStore := astore.NewInMemoryAStore()
+	targetAStore := astore.NewInMemoryAStore()
+	data1 := testutil.Data[0]
+	data2 := testutil.Data[1]
+	metadata1 := storage.DFileMetadata{StatusCode: 200, ServerIP: "127.0.0.1"}
+	metadata2 := storage.DFileMetadata{StatusCode: 200, Headers: map[string]string{"ETag": "tag"}}
+	var aFiles []storage.AFile
+	for _, testCase := range []struct {
+		data     testutil.DFileData
+		metadata storage.DFileMetadata
+	}{
+		{data1, metadata1},
+		{data2, metadata2},
+	} {
+		dStore := dstore.NewInMemoryDStore()
+		testutil.ErrorOrFail(t, dStore.Store(testCase.data.DFile, bytes.NewReader(testCase.data.Content)))
+		testutil.ErrorOrFail(t, dStore.StoreMetadata(testCase.data.DFile, testCase.metadata))
+		aFile, _, err := archive.CreateFromDFiles(feed, []storage.DFile{testCase.data.DFile}, dStore, sourceAStore)
+		testutil.ErrorOrFail(t, err)
+		aFiles = append(aFiles, aFile)
+	}
+
+	newAFile, _, err := archive.CreateFromAFiles(feed, aFiles, sourceAStore, targetAStore, dstore.NewInMemoryDStore())
+	testutil.ErrorOrFail(t, err)
+
+	dStore := dstore.NewInMemoryDStore()
+	testutil.ErrorOrFail(t, archive.Unpack(newAFile, targetAStore, dStore))
+	for dFile, expected := range map[storage.DFile]storage.DFileMetadata{
+		data1.DFile: metadata1,
+		data2.DFile: metadata2,
+	} {
+		actual, err := dStore.GetMetadata(dFile)
+		testutil.ErrorOrFail(t, err)
+		if actual == nil || !reflect.DeepEqual(*actual, expected) {
+			t.Errorf("Unexpected metadata for %s: %v; expected %v", dFile.String(), actual, expected)
+		}
+	}
+}
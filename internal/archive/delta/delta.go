@@ -0,0 +1,163 @@
+// Package delta contains a binary delta encoding, used to store a file in terms of a similar
+// base file.
+//
+// A delta is a sequence of instructions that build the target file. A copy instruction
+// copies a range of the base file, and an add instruction adds literal bytes. The encoder
+// finds ranges shared by the base and target using a rolling hash of fixed-size blocks of the
+// base, in the style of rsync and VCDIFF. It is designed for consecutive snapshots of the same
+// feed, which are usually mostly identical.
+//
+// The encoded delta is:
+//
+//	uvarint(target size) instruction*
+//
+// where each instruction is either
+//
+//	0x00 uvarint(length) <length literal bytes>
+//	0x01 uvarint(base offset) uvarint(length)
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	opAdd  = 0x00
+	opCopy = 0x01
+)
+
+// blockSize is the size of the blocks of the base that are indexed. Shared ranges shorter
+// than this are generally not found.
+const blockSize = 16
+
+// hashBase is the base of the polynomial rolling hash. Arithmetic is modulo 2^64.
+const hashBase = 1099511628211
+
+// ErrCorrupted is returned when decoding a delta that is invalid or that was encoded against
+// a different base.
+var ErrCorrupted = errors.New("the delta is corrupted")
+
+// Encode returns a delta that builds target from base.
+func Encode(base, target []byte) []byte {
+	var e encoder
+	e.out = binary.AppendUvarint(e.out, uint64(len(target)))
+	if len(base) < blockSize || len(target) < blockSize {
+		e.add(target)
+		return e.out
+	}
+	index := map[uint64]int{}
+	for pos := 0; pos+blockSize <= len(base); pos += blockSize {
+		h := hash(base[pos : pos+blockSize])
+		if _, ok := index[h]; !ok {
+			index[h] = pos
+		}
+	}
+	// hashBaseTop is hashBase^(blockSize-1), used to remove the first byte from the hash.
+	hashBaseTop := uint64(1)
+	for i := 0; i < blockSize-1; i++ {
+		hashBaseTop *= hashBase
+	}
+	addStart := 0
+	i := 0
+	h := hash(target[:blockSize])
+	for {
+		if pos, ok := index[h]; ok && bytes.Equal(base[pos:pos+blockSize], target[i:i+blockSize]) {
+			baseStart, targetStart := pos, i
+			for baseStart > 0 && targetStart > addStart && base[baseStart-1] == target[targetStart-1] {
+				baseStart--
+				targetStart--
+			}
+			baseEnd, targetEnd := pos+blockSize, i+blockSize
+			for baseEnd < len(base) && targetEnd < len(target) && base[baseEnd] == target[targetEnd] {
+				baseEnd++
+				targetEnd++
+			}
+			e.add(target[addStart:targetStart])
+			e.copy(baseStart, baseEnd-baseStart)
+			addStart, i = targetEnd, targetEnd
+			if i+blockSize > len(target) {
+				break
+			}
+			h = hash(target[i : i+blockSize])
+			continue
+		}
+		if i+blockSize >= len(target) {
+			break
+		}
+		h = (h-uint64(target[i])*hashBaseTop)*hashBase + uint64(target[i+blockSize])
+		i++
+	}
+	e.add(target[addStart:])
+	return e.out
+}
+
+// Decode builds the target from the base and a delta returned by Encode.
+func Decode(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	// The size is only a hint for the allocation, so it is capped in case it is corrupted.
+	target := make([]byte, 0, min(size, uint64(len(base)+len(delta))))
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case opAdd:
+			length, err := binary.ReadUvarint(r)
+			if err != nil || length > uint64(r.Len()) {
+				return nil, ErrCorrupted
+			}
+			start := len(delta) - r.Len()
+			target = append(target, delta[start:start+int(length)]...)
+			_, _ = r.Seek(int64(length), io.SeekCurrent)
+		case opCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, ErrCorrupted
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil || offset > uint64(len(base)) || length > uint64(len(base))-offset {
+				return nil, ErrCorrupted
+			}
+			target = append(target, base[offset:offset+length]...)
+		default:
+			return nil, ErrCorrupted
+		}
+	}
+	if uint64(len(target)) != size {
+		return nil, fmt.Errorf("%w: expected %d bytes but decoded %d", ErrCorrupted, size, len(target))
+	}
+	return target, nil
+}
+
+type encoder struct {
+	out []byte
+}
+
+func (e *encoder) add(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.out = append(e.out, opAdd)
+	e.out = binary.AppendUvarint(e.out, uint64(len(b)))
+	e.out = append(e.out, b...)
+}
+
+func (e *encoder) copy(offset, length int) {
+	e.out = append(e.out, opCopy)
+	e.out = binary.AppendUvarint(e.out, uint64(offset))
+	e.out = binary.AppendUvarint(e.out, uint64(length))
+}
+
+func hash(b []byte) uint64 {
+	var h uint64
+	for _, c := range b {
+		h = h*hashBase + uint64(c)
+	}
+	return h
+}
@@ -0,0 +1,84 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func snapshot(values ...int) []byte {
+	var b strings.Builder
+	for i, v := range values {
+		_, _ = fmt.Fprintf(&b, `{"entity":"vehicle_%d","position":%d,"status":"IN_TRANSIT_TO"},`, i, v)
+	}
+	return []byte(b.String())
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	for i, testCase := range []struct {
+		base   []byte
+		target []byte
+	}{
+		{nil, nil},
+		{nil, []byte("target")},
+		{[]byte("base"), []byte("target")},
+		{snapshot(1, 2, 3, 4, 5, 6), snapshot(1, 2, 3, 4, 5, 6)},
+		{snapshot(1, 2, 3, 4, 5, 6), snapshot(1, 2, 7, 4, 5, 6)},
+		{snapshot(1, 2, 3, 4, 5, 6), snapshot(0, 1, 2, 3, 4, 5, 6, 7)},
+		{snapshot(1, 2, 3, 4, 5, 6), snapshot(6, 5, 4, 3, 2, 1)},
+		{snapshot(1, 2, 3, 4, 5, 6), nil},
+		{random, append(append([]byte("prefix"), random[100:]...), random[:50]...)},
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			d := Encode(testCase.base, testCase.target)
+			actual, err := Decode(testCase.base, d)
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			if !bytes.Equal(actual, testCase.target) {
+				t.Errorf("Unexpected decoded target %q; expected %q", actual, testCase.target)
+			}
+		})
+	}
+}
+
+func TestEncode_SimilarFilesHaveSmallDeltas(t *testing.T) {
+	var values []int
+	for i := 0; i < 1000; i++ {
+		values = append(values, i)
+	}
+	base := snapshot(values...)
+	values[500] = -1
+	target := snapshot(values...)
+
+	d := Encode(base, target)
+	if len(d) > 100 {
+		t.Errorf("Unexpected delta size %d for a %d byte file with a single change", len(d), len(target))
+	}
+}
+
+func TestDecode_Corrupted(t *testing.T) {
+	base := snapshot(1, 2, 3, 4, 5, 6)
+	target := snapshot(1, 2, 7, 4, 5, 6)
+	d := Encode(base, target)
+	for i, testCase := range []struct {
+		base  []byte
+		delta []byte
+	}{
+		{base, nil},
+		{base, d[:len(d)-1]},
+		{base, append(append([]byte(nil), d...), 0x07)},
+		{base[:20], d},
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if _, err := Decode(testCase.base, testCase.delta); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("Unexpected error %v; expected %v", err, ErrCorrupted)
+			}
+		})
+	}
+}
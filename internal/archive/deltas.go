@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+
+	"github.com/jamespfennell/hoard/internal/archive/delta"
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// Archives with the delta encoding store runs of consecutive DFiles: the first DFile of each
+// run is stored in full and the following ones as deltas against the previous entry. The tar
+// header of a delta encoded entry contains a PAX record with the name of its base entry, and
+// the encoding is recorded in the manifest. Runs are at most the feed's maximum chain length
+// long, so reading any single DFile requires decoding a bounded number of deltas.
+
+// deltaBaseRecord is the PAX record that contains the name of the base of a delta encoded
+// entry.
+const deltaBaseRecord = "HOARD.delta.base"
+
+// deltaEncoder writes DFiles to an archive, storing each DFile as a delta against the
+// previous one if the delta is smaller.
+type deltaEncoder struct {
+	maxChainLength int
+	chainLength    int
+	baseName       string
+	base           []byte
+}
+
+func (e *deltaEncoder) write(tw *tar.Writer, dFile storage.DFile, dStore storage.ReadableDStore) error {
+	content, err := readDFile(dStore, dFile)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    dFile.String(),
+		Mode:    0600,
+		ModTime: dFile.Time,
+	}
+	body := content
+	e.chainLength++
+	if e.base == nil || e.chainLength > e.maxChainLength {
+		e.chainLength = 0
+	} else if d := delta.Encode(e.base, content); len(d) < len(content) {
+		hdr.PAXRecords = map[string]string{deltaBaseRecord: e.baseName}
+		body = d
+	} else {
+		e.chainLength = 0
+	}
+	e.baseName, e.base = hdr.Name, content
+	hdr.Size = int64(len(body))
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(body)
+	return err
+}
+
+// deltaDecoder reads the entries of an archive with the delta encoding, in order.
+type deltaDecoder struct {
+	baseName string
+	base     []byte
+}
+
+// read returns the content of the entry, decoding it if it is a delta.
+func (d *deltaDecoder) read(hdr *tar.Header, r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if baseName, ok := hdr.PAXRecords[deltaBaseRecord]; ok {
+		if baseName != d.baseName || d.base == nil {
+			return nil, fmt.Errorf("the base %s of the delta encoded entry %s is not the previous entry", baseName, hdr.Name)
+		}
+		if content, err = delta.Decode(d.base, content); err != nil {
+			return nil, fmt.Errorf("failed to decode the entry %s: %w", hdr.Name, err)
+		}
+	}
+	d.baseName, d.base = hdr.Name, content
+	return content, nil
+}
+
+func isDelta(hdr *tar.Header) bool {
+	_, ok := hdr.PAXRecords[deltaBaseRecord]
+	return ok
+}
+
+func readDFile(dStore storage.ReadableDStore, dFile storage.DFile) ([]byte, error) {
+	reader, err := dStore.Get(dFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return b, reader.Close()
+}
@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// archiveCompression is the compression used to write a new archive.
+type archiveCompression struct {
+	compression config.Compression
+	// dictionary is the hash of the version of the dictionary used, and dictionaryContent
+	// its content. These are only set if the compression format uses dictionaries.
+	dictionary        storage.Hash
+	dictionaryContent []byte
+}
+
+// compressionFor returns the compression for a new archive of the feed that will be written to
+// the AStore.
+//
+// If the feed's compression format uses a dictionary, the latest version of the dictionary
+// provided by the AStore is used. If there is no dictionary yet, for example because the feed
+// has only just started collecting data, the archive is compressed using zstd without a
+// dictionary. Such archives are recompressed by the auditor once a dictionary exists.
+func compressionFor(feed *config.Feed, aStore interface{}) archiveCompression {
+	c := archiveCompression{compression: feed.Compression}
+	if !feed.Compression.UsesDictionary() {
+		return c
+	}
+	version, content, err := latestDictionary(aStore)
+	if err != nil {
+		fmt.Printf("Error when reading the latest dictionary: %s; compressing without a dictionary\n", err)
+	}
+	if version == "" {
+		c.compression = config.NewSpecWithLevel(config.Zstd, feed.Compression.LevelActual())
+		return c
+	}
+	c.dictionary = version
+	c.dictionaryContent = content
+	return c
+}
+
+func latestDictionary(aStore interface{}) (storage.Hash, []byte, error) {
+	dictionaries := storage.DictionariesOf(aStore)
+	if dictionaries == nil {
+		return "", nil, nil
+	}
+	return dictionaries.Latest()
+}
+
+func (c archiveCompression) newWriter(w io.Writer) io.WriteCloser {
+	return c.compression.NewWriterWithDictionary(w, c.dictionaryContent)
+}
+
+// newDecompressor returns a reader that decompresses the content of the AFile. If the AFile
+// was compressed using a dictionary, the dictionary is obtained from the AStore.
+func newDecompressor(aFile storage.AFile, aStore storage.ReadableAStore, r io.Reader) (io.ReadCloser, error) {
+	if !aFile.Compression.UsesDictionary() {
+		return aFile.Compression.NewReader(r)
+	}
+	dictionaries := storage.DictionariesOf(aStore)
+	if dictionaries == nil {
+		return nil, fmt.Errorf("the archive %s needs a dictionary but no dictionaries are available", aFile)
+	}
+	content, err := dictionaries.Get(aFile.Dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the dictionary of the archive %s: %w", aFile, err)
+	}
+	return aFile.Compression.NewReaderWithDictionary(r, content)
+}
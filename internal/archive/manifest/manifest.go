@@ -9,6 +9,17 @@ import (
 	"time"
 )
 
+// Encoding is the way the DFiles in an archive are stored.
+type Encoding string
+
+const (
+	// EncodingFull means each DFile is stored in full.
+	EncodingFull Encoding = ""
+	// EncodingDelta means some DFiles are stored as deltas against the previous DFile in the
+	// archive. The base of a delta encoded DFile is recorded in its tar header.
+	EncodingDelta Encoding = "delta"
+)
+
 // TODO: test serialization and deserialization?
 func NewManifest(hr hour.Hour) *Manifest {
 	return &Manifest{
@@ -17,7 +28,8 @@ func NewManifest(hr hour.Hour) *Manifest {
 			ipAddress: util.GetPublicIPAddressOr("<unknown>"),
 			time:      time.Now().UTC(),
 		},
-		allDFiles: map[storage.DFile]bool{},
+		allDFiles:     map[storage.DFile]bool{},
+		dFileMetadata: map[storage.DFile]storage.DFileMetadata{},
 	}
 }
 
@@ -38,6 +50,10 @@ type Manifest struct {
 	originalDFiles []storage.DFile
 	missingDFiles  []storage.DFile
 	allDFiles      map[storage.DFile]bool
+	// dFileMetadata contains the metadata of original DFiles in this manifest. The
+	// metadata of DFiles in child manifests is stored in the child manifests.
+	dFileMetadata map[storage.DFile]storage.DFileMetadata
+	encoding      Encoding
 }
 
 type metadata struct {
@@ -86,12 +102,45 @@ func (m *Manifest) AddChildManifest(child *Manifest) {
 	m.hash = nil
 }
 
+// AddDFileMetadata records the metadata of an original DFile of the manifest.
+func (m *Manifest) AddDFileMetadata(dFile storage.DFile, metadata storage.DFileMetadata) {
+	m.dFileMetadata[dFile] = metadata
+}
+
+// DFileMetadata returns the metadata of all DFiles in the manifest, including DFiles
+// in child manifests, that have metadata.
+func (m *Manifest) DFileMetadata() map[storage.DFile]storage.DFileMetadata {
+	result := map[storage.DFile]storage.DFileMetadata{}
+	m.collectDFileMetadata(result)
+	return result
+}
+
+func (m *Manifest) collectDFileMetadata(result map[storage.DFile]storage.DFileMetadata) {
+	for i := range m.childManifests {
+		m.childManifests[i].collectDFileMetadata(result)
+	}
+	for dFile, metadata := range m.dFileMetadata {
+		result[dFile] = metadata
+	}
+}
+
 func (m *Manifest) MarkDFileMissing(dFile storage.DFile) {
 	m.missingDFiles = append(m.missingDFiles, dFile)
 	delete(m.allDFiles, dFile)
 	m.hash = nil
 }
 
+// SetEncoding records the way the DFiles in the archive are stored.
+func (m *Manifest) SetEncoding(encoding Encoding) {
+	m.encoding = encoding
+}
+
+// Encoding returns the way the DFiles in the archive are stored. The encodings of child
+// manifests describe the archives they were merged from and are not relevant to this one.
+func (m *Manifest) Encoding() Encoding {
+	return m.encoding
+}
+
 func (m *Manifest) Hour() hour.Hour {
 	return m.hour
 }
@@ -104,6 +153,13 @@ func (m *Manifest) toJsonSpec() *jsonSpec {
 		AssemblyTime:     m.metadata.time,
 		SourceDownloads:  m.originalDFiles,
 		MissingDownloads: m.missingDFiles,
+		Encoding:         m.encoding,
+	}
+	if len(m.dFileMetadata) > 0 {
+		spec.DownloadMetadata = map[string]storage.DFileMetadata{}
+		for dFile, metadata := range m.dFileMetadata {
+			spec.DownloadMetadata[dFile.String()] = metadata
+		}
 	}
 	for _, child := range m.childManifests {
 		spec.SourceArchives = append(spec.SourceArchives, *child.toJsonSpec())
@@ -120,6 +176,9 @@ type jsonSpec struct {
 	SourceArchives   []jsonSpec
 	SourceDownloads  []storage.DFile
 	MissingDownloads []storage.DFile
+	// DownloadMetadata is keyed by the string representation of the DFile
+	DownloadMetadata map[string]storage.DFileMetadata `json:",omitempty"`
+	Encoding         Encoding                         `json:",omitempty"`
 }
 
 func (j jsonSpec) toManifest() *Manifest {
@@ -130,7 +189,9 @@ func (j jsonSpec) toManifest() *Manifest {
 			ipAddress: j.Assembler,
 			time:      j.AssemblyTime,
 		},
-		allDFiles: map[storage.DFile]bool{},
+		allDFiles:     map[storage.DFile]bool{},
+		dFileMetadata: map[storage.DFile]storage.DFileMetadata{},
+		encoding:      j.Encoding,
 	}
 	for _, child := range j.SourceArchives {
 		m.AddChildManifest(child.toManifest())
@@ -139,5 +200,10 @@ func (j jsonSpec) toManifest() *Manifest {
 	for _, dFile := range j.MissingDownloads {
 		m.MarkDFileMissing(dFile)
 	}
+	for s, metadata := range j.DownloadMetadata {
+		if dFile, ok := storage.NewDFileFromString(s); ok {
+			m.AddDFileMetadata(dFile, metadata)
+		}
+	}
 	return &m
 }
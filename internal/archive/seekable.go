@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/zstd"
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/archive/delta"
+	"github.com/jamespfennell/hoard/internal/archive/manifest"
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// Seekable archives are zstd compressed tar files in which each tar entry is compressed as an
+// independent zstd frame. Concatenated zstd frames are a valid zstd stream, so seekable
+// archives can be read like any other archive. After the last frame, a zstd skippable frame
+// contains an index from the name of each entry to the position of its frame in the archive.
+// Zstd decompressors ignore skippable frames.
+//
+// The skippable frame ends with a footer that contains the size of the index and a magic
+// number. A single entry can thus be read using three range reads: the footer, the index and
+// the frame containing the entry.
+
+// zstdSkippableFrameMagic is the magic number of the skippable frame containing the index.
+// The zstd format reserves 16 magic numbers for skippable frames.
+const zstdSkippableFrameMagic = 0x184D2A5E
+
+// seekableFooterMagic identifies the footer of a seekable archive.
+const seekableFooterMagic = 0x48445358
+
+const seekableFooterSize = 8
+
+// ErrNotSeekable is returned when reading the index of an archive that is not seekable.
+var ErrNotSeekable = errors.New("the archive is not seekable")
+
+type seekableIndex struct {
+	// Frames maps the name of each entry in the archive to its frame.
+	Frames map[string]seekableFrame
+}
+
+type seekableFrame struct {
+	Offset int64
+	Size   int64
+}
+
+// seekableWriter writes the tar stream of an archive as a sequence of independent zstd
+// frames. Data written to it is streamed into the current frame, which is ended when EndFrame
+// is called.
+type seekableWriter struct {
+	w     *byteCounterWriter
+	level int
+	// frame is the zstd writer of the current frame. It is nil if no data has been written
+	// since the last frame ended.
+	frame  io.WriteCloser
+	offset int64
+	index  seekableIndex
+}
+
+func newSeekableWriter(w io.Writer, level int) *seekableWriter {
+	return &seekableWriter{
+		w:     &byteCounterWriter{Writer: w},
+		level: level,
+		index: seekableIndex{Frames: map[string]seekableFrame{}},
+	}
+}
+
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	if s.frame == nil {
+		s.frame = zstd.NewWriterLevel(s.w, s.level)
+	}
+	return s.frame.Write(p)
+}
+
+// EndFrame ends the current frame and records it in the index under the provided names. It
+// returns the frame so that other names can be mapped to it later.
+func (s *seekableWriter) EndFrame(names ...string) (seekableFrame, error) {
+	if s.frame == nil {
+		s.frame = zstd.NewWriterLevel(s.w, s.level)
+	}
+	err := s.frame.Close()
+	s.frame = nil
+	if err != nil {
+		return seekableFrame{}, err
+	}
+	frame := seekableFrame{Offset: s.offset, Size: int64(s.w.BytesWritten) - s.offset}
+	s.offset = int64(s.w.BytesWritten)
+	for _, name := range names {
+		s.index.Frames[name] = frame
+	}
+	return frame, nil
+}
+
+// Close ends the current frame, if data has been written to it, and writes the index.
+func (s *seekableWriter) Close() error {
+	if s.frame != nil {
+		if _, err := s.EndFrame(); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+	var frame bytes.Buffer
+	_ = binary.Write(&frame, binary.LittleEndian, uint32(zstdSkippableFrameMagic))
+	_ = binary.Write(&frame, binary.LittleEndian, uint32(len(b)+seekableFooterSize))
+	frame.Write(b)
+	_ = binary.Write(&frame, binary.LittleEndian, uint32(len(b)))
+	_ = binary.Write(&frame, binary.LittleEndian, uint32(seekableFooterMagic))
+	_, err = s.w.Write(frame.Bytes())
+	return err
+}
+
+// ReadIndex returns the DFiles in the AFile by reading only the index of the archive. If the
+// AStore is a storage.RangeReadableAStore, which is the case for remote object storage, the
+// rest of the archive is not downloaded.
+//
+// ErrNotSeekable is returned if the archive is not seekable.
+func ReadIndex(aFile storage.AFile, aStore storage.ReadableAStore) ([]storage.DFile, error) {
+	index, err := readSeekableIndex(aFile, aStore)
+	if err != nil {
+		return nil, err
+	}
+	var dFiles []storage.DFile
+	for name := range index.Frames {
+		if dFile, ok := storage.NewDFileFromString(name); ok {
+			dFiles = append(dFiles, dFile)
+		}
+	}
+	storage.Sort(dFiles)
+	return dFiles, nil
+}
+
+// ReadDFile returns the content of a single DFile in the AFile.
+//
+// If the archive is seekable only the index and the frame containing the DFile are read.
+// Otherwise, the archive is decompressed until the DFile is found.
+func ReadDFile(aFile storage.AFile, aStore storage.ReadableAStore, dFile storage.DFile) ([]byte, error) {
+	index, err := readSeekableIndex(aFile, aStore)
+	if errors.Is(err, ErrNotSeekable) {
+		return readDFileSequentially(aFile, aStore, dFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readSeekableEntry(aFile, aStore, index, dFile.String(), 0)
+}
+
+// readSeekableEntry returns the content of the entry with the name. If the entry is delta
+// encoded, its base entries are read too; depth is the number of deltas already being decoded.
+func readSeekableEntry(aFile storage.AFile, aStore storage.ReadableAStore, index *seekableIndex, name string, depth int) ([]byte, error) {
+	if depth > len(index.Frames) {
+		return nil, fmt.Errorf("the delta encoded entries of the archive %s form a cycle", aFile)
+	}
+	frame, ok := index.Frames[name]
+	if !ok {
+		return nil, fmt.Errorf("the entry %s is not in the archive %s", name, aFile)
+	}
+	compressed, err := storage.ReadAFileRange(aStore, aFile, frame.Offset, frame.Size)
+	if err != nil {
+		return nil, err
+	}
+	b, err := zstd.Decompress(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+	// Identical DFiles are only written to the archive once, so the entry in the frame may
+	// have a different name than the DFile.
+	tr := tar.NewReader(bytes.NewReader(b))
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(tr)
+	if err != nil || !isDelta(hdr) {
+		return content, err
+	}
+	base, err := readSeekableEntry(aFile, aStore, index, hdr.PAXRecords[deltaBaseRecord], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return delta.Decode(base, content)
+}
+
+func readSeekableIndex(aFile storage.AFile, aStore storage.ReadableAStore) (*seekableIndex, error) {
+	if aFile.Compression.Format != config.Zstd {
+		return nil, ErrNotSeekable
+	}
+	footer, err := storage.ReadAFileRange(aStore, aFile, -seekableFooterSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(footer) != seekableFooterSize || binary.LittleEndian.Uint32(footer[4:]) != seekableFooterMagic {
+		return nil, ErrNotSeekable
+	}
+	indexSize := int64(binary.LittleEndian.Uint32(footer[:4]))
+	b, err := storage.ReadAFileRange(aStore, aFile, -(indexSize + seekableFooterSize), 0)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != indexSize+seekableFooterSize {
+		return nil, fmt.Errorf("the index of the archive %s could not be read", aFile)
+	}
+	var index seekableIndex
+	if err := json.Unmarshal(b[:indexSize], &index); err != nil {
+		return nil, fmt.Errorf("the index of the archive %s is corrupted: %w", aFile, err)
+	}
+	return &index, nil
+}
+
+func readDFileSequentially(aFile storage.AFile, aStore storage.ReadableAStore, dFile storage.DFile) ([]byte, error) {
+	reader, err := aStore.Get(aFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	decompressor, err := newDecompressor(aFile, aStore, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	tr := tar.NewReader(decompressor)
+	var deltas *deltaDecoder
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == ManifestFileName {
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if m, err := manifest.Deserialize(b); err == nil && m.Encoding() == manifest.EncodingDelta {
+				deltas = &deltaDecoder{}
+			}
+			continue
+		}
+		entry, ok := storage.NewDFileFromString(header.Name)
+		match := ok && entry.Hash == dFile.Hash
+		if deltas != nil {
+			content, err := deltas.read(header, tr)
+			if err != nil || match {
+				return content, err
+			}
+			continue
+		}
+		if match {
+			return io.ReadAll(tr)
+		}
+	}
+	return nil, fmt.Errorf("the DFile %s is not in the archive %s", dFile, aFile)
+}
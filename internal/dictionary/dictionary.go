@@ -0,0 +1,300 @@
+// Package dictionary contains the versioned zstd compression dictionaries of feeds.
+//
+// A feed's dictionary is trained on a sample of its downloaded files. Each version of the
+// dictionary is stored as a separate object in every configured object storage, in a
+// directory alongside the feed directories. Versions are identified by the hash of their
+// content, so replicas that train dictionaries at the same time never overwrite each other's
+// versions. Versions are never modified or deleted, because archives compressed using a
+// version can only be decompressed using that same version.
+package dictionary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
+	"github.com/klauspost/compress/dict"
+)
+
+// dictionariesRoot is the directory, relative to the object storage prefix, in which
+// dictionaries are stored. Each feed's dictionaries are in a subdirectory named after the feed.
+const dictionariesRoot = "_dictionaries"
+
+// ErrMissing is returned when getting a version of the dictionary that does not exist.
+var ErrMissing = errors.New("the dictionary version does not exist")
+
+const timeLayout = "20060102T150405Z"
+
+var nameMatcher = regexp.MustCompile(`^(?P<time>\d{8}T\d{6}Z)_(?P<hash>[a-z0-9]{12})\.dict$`)
+
+// Version describes a version of a feed's dictionary.
+type Version struct {
+	// Hash is the hash of the content of the version, which identifies it.
+	Hash    storage.Hash
+	Created time.Time
+}
+
+func (v Version) name() string {
+	return fmt.Sprintf("%s_%s.dict", v.Created.UTC().Format(timeLayout), v.Hash)
+}
+
+func newVersionFromName(name string) (Version, bool) {
+	match := nameMatcher.FindStringSubmatch(name)
+	if match == nil {
+		return Version{}, false
+	}
+	created, err := time.Parse(timeLayout, match[1])
+	if err != nil {
+		return Version{}, false
+	}
+	return Version{Hash: storage.Hash(match[2]), Created: created}, true
+}
+
+// latestRefreshPeriod is how long the latest version is cached before the versions are listed
+// again. It is much shorter than the retrain period so that a version trained by another
+// replica is soon used by this replica too, and the replicas pack the same hours using the
+// same dictionary.
+const latestRefreshPeriod = time.Hour
+
+// Store reads and writes the dictionaries of a feed. It implements storage.Dictionaries.
+type Store struct {
+	feedID string
+	stores []persistence.PersistedStorage
+
+	now func() time.Time
+
+	mu            sync.Mutex
+	hashToContent map[storage.Hash][]byte
+	// latest is the most recent version when the versions were last listed, at
+	// latestListed. It is nil if there were no versions.
+	latest       *Version
+	latestListed time.Time
+}
+
+// NewStore creates the Store for the feed's dictionaries in the object storage.
+func NewStore(ctx context.Context, objectStorage []config.ObjectStorage, feed *config.Feed) (*Store, error) {
+	root := &config.Feed{ID: dictionariesRoot}
+	var stores []persistence.PersistedStorage
+	for i := range objectStorage {
+		store, err := persistence.NewObjectPersistedStorage(ctx, &objectStorage[i], root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize object storage for dictionaries: %w", err)
+		}
+		stores = append(stores, store)
+	}
+	return newStore(feed, stores), nil
+}
+
+// NewDiskStore creates a Store in which dictionaries are stored on the local filesystem, in a
+// subdirectory of the root directory named after the feed. This is used if no object storage
+// is configured.
+func NewDiskStore(root string, feed *config.Feed) *Store {
+	return newStore(feed, []persistence.PersistedStorage{persistence.NewDiskPersistedStorage(root)})
+}
+
+// NewInMemoryStore creates a Store in which dictionaries are stored in memory. This is used
+// for testing.
+func NewInMemoryStore(feed *config.Feed) *Store {
+	return newStore(feed, []persistence.PersistedStorage{persistence.NewInMemoryPersistedStorage()})
+}
+
+func newStore(feed *config.Feed, stores []persistence.PersistedStorage) *Store {
+	return &Store{
+		feedID:        feed.ID,
+		stores:        stores,
+		now:           time.Now,
+		hashToContent: map[storage.Hash][]byte{},
+	}
+}
+
+// Versions returns the versions of the dictionary that are in at least one of the object
+// storages, from oldest to newest.
+func (s *Store) Versions() ([]Version, error) {
+	hashToVersion := map[storage.Hash]Version{}
+	for _, store := range s.stores {
+		results, err := store.Search(s.prefix())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list dictionaries: %w", err)
+		}
+		for _, result := range results {
+			if result.Prefix.ID() != s.prefix().ID() {
+				continue
+			}
+			for _, name := range result.Names {
+				version, ok := newVersionFromName(name)
+				if !ok {
+					continue
+				}
+				if existing, ok := hashToVersion[version.Hash]; ok && existing.Created.Before(version.Created) {
+					continue
+				}
+				hashToVersion[version.Hash] = version
+			}
+		}
+	}
+	var versions []Version
+	for _, version := range hashToVersion {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].Created.Equal(versions[j].Created) {
+			return versions[i].Created.Before(versions[j].Created)
+		}
+		return versions[i].Hash < versions[j].Hash
+	})
+	s.mu.Lock()
+	s.latest = nil
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		s.latest = &latest
+	}
+	s.latestListed = s.now()
+	s.mu.Unlock()
+	return versions, nil
+}
+
+// Latest returns the most recent version of the dictionary and its content. The hash is empty
+// if the feed has no dictionary.
+//
+// Latest is called whenever an archive is created, so the latest version is cached and the
+// versions are only listed again once the refresh period of one hour has passed. Versions added using
+// this Store are seen immediately. If listing the versions fails, the cached version is used.
+func (s *Store) Latest() (storage.Hash, []byte, error) {
+	latest, err := s.latestVersion()
+	if err != nil || latest == nil {
+		return "", nil, err
+	}
+	content, err := s.get(*latest)
+	if err != nil {
+		return "", nil, err
+	}
+	return latest.Hash, content, nil
+}
+
+func (s *Store) latestVersion() (*Version, error) {
+	s.mu.Lock()
+	latest, listed := s.latest, !s.latestListed.IsZero()
+	fresh := listed && s.now().Sub(s.latestListed) < latestRefreshPeriod
+	s.mu.Unlock()
+	if fresh {
+		return latest, nil
+	}
+	if _, err := s.Versions(); err != nil {
+		if listed {
+			return latest, nil
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest, nil
+}
+
+// Get returns the content of the version of the dictionary with the hash. ErrMissing is
+// returned if the version does not exist.
+func (s *Store) Get(hash storage.Hash) ([]byte, error) {
+	s.mu.Lock()
+	content, ok := s.hashToContent[hash]
+	s.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+	versions, err := s.Versions()
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Hash == hash {
+			return s.get(version)
+		}
+	}
+	return nil, fmt.Errorf("version %s of the dictionary of feed %s: %w", hash, s.feedID, ErrMissing)
+}
+
+func (s *Store) get(version Version) ([]byte, error) {
+	s.mu.Lock()
+	content, ok := s.hashToContent[version.Hash]
+	s.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+	var errs []error
+	for _, store := range s.stores {
+		content, err := readAll(store, s.key(version))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if hash := storage.CalculateHash(content); hash != version.Hash {
+			errs = append(errs, fmt.Errorf("the content has hash %s", hash))
+			continue
+		}
+		s.mu.Lock()
+		s.hashToContent[version.Hash] = content
+		s.mu.Unlock()
+		return content, nil
+	}
+	return nil, fmt.Errorf("failed to read version %s of the dictionary of feed %s: %w", version.Hash, s.feedID, errs[0])
+}
+
+// Add stores the content as a new version of the dictionary and returns the hash that
+// identifies the version. The hash is only returned if the version was stored in all of the
+// object storages, so that all archives compressed using it can be decompressed using any
+// object storage.
+func (s *Store) Add(content []byte, now time.Time) (storage.Hash, error) {
+	version := Version{Hash: storage.CalculateHash(content), Created: now}
+	for _, store := range s.stores {
+		if err := store.Put(s.key(version), bytes.NewReader(content), now); err != nil {
+			return "", fmt.Errorf("failed to store version %s of the dictionary of feed %s: %w", version.Hash, s.feedID, err)
+		}
+	}
+	s.mu.Lock()
+	s.hashToContent[version.Hash] = content
+	if s.latest == nil || !version.Created.Before(s.latest.Created) {
+		s.latest = &version
+	}
+	s.mu.Unlock()
+	return version.Hash, nil
+}
+
+func (s *Store) prefix() persistence.Prefix {
+	return persistence.Prefix{s.feedID}
+}
+
+func (s *Store) key(version Version) persistence.Key {
+	return persistence.Key{Prefix: s.prefix(), Name: version.name()}
+}
+
+func readAll(store persistence.PersistedStorage, k persistence.Key) ([]byte, error) {
+	reader, err := store.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// MinSamples is the minimum number of samples needed to train a dictionary.
+const MinSamples = 10
+
+// Train trains a zstd dictionary of at most maxSize bytes on the samples.
+func Train(samples [][]byte, maxSize int) ([]byte, error) {
+	if len(samples) < MinSamples {
+		return nil, fmt.Errorf("at least %d samples are needed to train a dictionary; got %d", MinSamples, len(samples))
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		// The dictionary is used by the zstd C library when compressing.
+		ZstdDictCompat: true,
+	})
+}
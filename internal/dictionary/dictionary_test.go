@@ -0,0 +1,144 @@
+package dictionary
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+var feed = &config.Feed{ID: "feed_1"}
+
+func samples(n int) [][]byte {
+	var result [][]byte
+	for i := 0; i < n; i++ {
+		result = append(result, []byte(fmt.Sprintf(
+			`{"header":{"version":"2.0","timestamp":%d},"entity":[{"id":"trip_%d","vehicle":{"position":{"lat":40.7%d,"lon":-73.9%d}}}]}`,
+			1600000000+i, i%7, i, i)))
+	}
+	return result
+}
+
+func TestTrain(t *testing.T) {
+	d, err := Train(samples(200), 4096)
+	testutil.ErrorOrFail(t, err)
+
+	// The dictionary compresses data with the C library and decompresses with the Go library.
+	compression := config.Compression{Format: config.ZstdDict}
+	var b bytes.Buffer
+	w := compression.NewWriterWithDictionary(&b, d)
+	_, err = w.Write(samples(1)[0])
+	testutil.ErrorOrFail(t, err)
+	testutil.ErrorOrFail(t, w.Close())
+
+	r, err := compression.NewReaderWithDictionary(&b, d)
+	testutil.ErrorOrFail(t, err)
+	actual, err := io.ReadAll(r)
+	testutil.ErrorOrFail(t, err)
+	if !bytes.Equal(actual, samples(1)[0]) {
+		t.Errorf("Unexpected decompressed data %q", actual)
+	}
+}
+
+func TestTrain_TooFewSamples(t *testing.T) {
+	if _, err := Train(samples(MinSamples-1), 4096); err == nil {
+		t.Errorf("Expected error when training on too few samples")
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewInMemoryStore(feed)
+	version, content, err := s.Latest()
+	testutil.ErrorOrFail(t, err)
+	if version != "" || content != nil {
+		t.Errorf("Unexpected latest version %s; expected none", version)
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, d := range []string{"first", "second"} {
+		version, err := s.Add([]byte(d), now.Add(time.Duration(i)*time.Hour))
+		testutil.ErrorOrFail(t, err)
+		if expected := storage.CalculateHash([]byte(d)); version != expected {
+			t.Errorf("Unexpected version %s; expected %s", version, expected)
+		}
+	}
+
+	// A new Store reads the dictionaries from storage rather than its cache.
+	s = newStore(feed, s.stores)
+	version, content, err = s.Latest()
+	testutil.ErrorOrFail(t, err)
+	if version != storage.CalculateHash([]byte("second")) || string(content) != "second" {
+		t.Errorf("Unexpected latest version %s with content %q; expected content %q", version, content, "second")
+	}
+	content, err = s.Get(storage.CalculateHash([]byte("first")))
+	testutil.ErrorOrFail(t, err)
+	if string(content) != "first" {
+		t.Errorf("Unexpected content %q; expected %q", content, "first")
+	}
+	if _, err := s.Get(storage.CalculateHash([]byte("third"))); !errors.Is(err, ErrMissing) {
+		t.Errorf("Unexpected error %v; expected %v", err, ErrMissing)
+	}
+	versions, err := s.Versions()
+	testutil.ErrorOrFail(t, err)
+	if len(versions) != 2 || !versions[0].Created.Equal(now) {
+		t.Errorf("Unexpected versions %v", versions)
+	}
+}
+
+func TestStore_ConcurrentReplicas(t *testing.T) {
+	// Two replicas sharing the same storage train different dictionaries at the same time.
+	s1 := NewInMemoryStore(feed)
+	s2 := newStore(feed, s1.stores)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	version1, err := s1.Add([]byte("first"), now)
+	testutil.ErrorOrFail(t, err)
+	version2, err := s2.Add([]byte("second"), now)
+	testutil.ErrorOrFail(t, err)
+
+	s := newStore(feed, s1.stores)
+	for version, expected := range map[storage.Hash]string{version1: "first", version2: "second"} {
+		content, err := s.Get(version)
+		testutil.ErrorOrFail(t, err)
+		if string(content) != expected {
+			t.Errorf("Unexpected content %q of version %s; expected %q", content, version, expected)
+		}
+	}
+}
+
+func TestStore_LatestIsCached(t *testing.T) {
+	s := NewInMemoryStore(feed)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.Add([]byte("first"), now)
+	testutil.ErrorOrFail(t, err)
+
+	// Another replica trains a new version.
+	second, err := newStore(feed, s.stores).Add([]byte("second"), now.Add(time.Hour))
+	testutil.ErrorOrFail(t, err)
+	latest, _, err := s.Latest()
+	testutil.ErrorOrFail(t, err)
+	if latest != second {
+		t.Errorf("Unexpected latest version %s; expected %s", latest, second)
+	}
+
+	third, err := newStore(feed, s.stores).Add([]byte("third"), now.Add(2*time.Hour))
+	testutil.ErrorOrFail(t, err)
+	latest, _, err = s.Latest()
+	testutil.ErrorOrFail(t, err)
+	if latest != second {
+		t.Errorf("Unexpected latest version %s; expected the cached version %s", latest, second)
+	}
+
+	now = now.Add(latestRefreshPeriod)
+	latest, _, err = s.Latest()
+	testutil.ErrorOrFail(t, err)
+	if latest != third {
+		t.Errorf("Unexpected latest version %s; expected %s", latest, third)
+	}
+}
@@ -0,0 +1,130 @@
+// Package feedtemplate contains the templates used for feed URLs and header values.
+//
+// Templates use the Go text/template syntax and are rendered before every download with
+// a Data value. For example, the template
+//
+//	https://example.com/{{ strftime "%Y/%m/%d" .Time }}/latest.json?since={{ .LastSuccess.Unix }}
+//
+// renders to a URL containing the current UTC date and the Unix time of the last successful
+// download.
+package feedtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data is the data available when rendering a template.
+type Data struct {
+	// Time is the current time in UTC.
+	Time time.Time
+	// FeedID is the ID of the feed being downloaded.
+	FeedID string
+	// LastSuccess is the time of the last successful download of the feed, in UTC.
+	LastSuccess time.Time
+}
+
+// Unix returns the current time as a Unix time in seconds.
+func (d Data) Unix() int64 {
+	return d.Time.Unix()
+}
+
+// UnixMilli returns the current time as a Unix time in milliseconds.
+func (d Data) UnixMilli() int64 {
+	return d.Time.UnixMilli()
+}
+
+var funcs = template.FuncMap{
+	"strftime": Strftime,
+}
+
+// Template is a parsed feed template.
+type Template struct {
+	t *template.Template
+}
+
+// Parse parses a feed template.
+func Parse(s string) (*Template, error) {
+	t, err := template.New("").Funcs(funcs).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{t: t}, nil
+}
+
+// Render renders the template with the provided data.
+func (t *Template) Render(data Data) (string, error) {
+	var b bytes.Buffer
+	if err := t.t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Strftime formats the time using the C strftime conversion specifications. The supported
+// specifications are %Y, %y, %m, %d, %e, %j, %H, %I, %M, %S, %p, %b, %B, %a, %A, %s, %Z, %z,
+// %F, %T and %%.
+func Strftime(format string, t time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("strftime format %q ends with an incomplete conversion", format)
+		}
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unsupported strftime conversion %%%c", format[i])
+		}
+	}
+	return b.String(), nil
+}
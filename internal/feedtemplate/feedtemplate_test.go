@@ -0,0 +1,56 @@
+package feedtemplate
+
+import (
+	"testing"
+	"time"
+)
+
+var time1 = time.Date(2026, 10, 7, 14, 3, 9, 123_000_000, time.UTC)
+
+func TestRender(t *testing.T) {
+	data := Data{
+		Time:        time1,
+		FeedID:      "feed1",
+		LastSuccess: time1.Add(-5 * time.Second),
+	}
+	for _, testCase := range []struct {
+		template string
+		expected string
+	}{
+		{"https://example.com/latest.json", "https://example.com/latest.json"},
+		{`https://example.com/{{ strftime "%Y/%m/%d" .Time }}/latest.json`, "https://example.com/2026/10/07/latest.json"},
+		{`{{ strftime "%H:%M:%S %j %I%p %a %b %%" .Time }}`, "14:03:09 280 02PM Wed Oct %"},
+		{"?since={{ .LastSuccess.Unix }}", "?since=1791381784"},
+		{"{{ .Unix }} {{ .UnixMilli }}", "1791381789 1791381789123"},
+		{"{{ .FeedID }}", "feed1"},
+	} {
+		tmpl, err := Parse(testCase.template)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %s", testCase.template, err)
+		}
+		actual, err := tmpl.Render(data)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering %q: %s", testCase.template, err)
+		}
+		if actual != testCase.expected {
+			t.Errorf("Unexpected rendering of %q: %q != %q", testCase.template, actual, testCase.expected)
+		}
+	}
+}
+
+func TestRender_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"{{ .Time",
+		"{{ .NotAField }}",
+		`{{ strftime "%Q" .Time }}`,
+		`{{ strftime "%" .Time }}`,
+	} {
+		tmpl, err := Parse(s)
+		if err != nil {
+			continue
+		}
+		if _, err := tmpl.Render(Data{Time: time1}); err == nil {
+			t.Errorf("Expected error for template %q; received none", s)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package monitoring
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/jamespfennell/hoard/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -8,8 +11,23 @@ import (
 
 var downloadCount *prometheus.CounterVec
 var downloadFailedCount *prometheus.CounterVec
+var downloadRetryCount *prometheus.CounterVec
+var downloadNotModifiedCount *prometheus.CounterVec
+var downloadInvalidCount *prometheus.CounterVec
 var downloadSavedCount *prometheus.CounterVec
+var rateLimitDelayedCount *prometheus.CounterVec
+var rateLimitDelayedSeconds *prometheus.CounterVec
+var rateLimitDroppedCount *prometheus.CounterVec
+var downloadURLCount *prometheus.CounterVec
+var downloadURLFailedCount *prometheus.CounterVec
 var downloadSavedSize *prometheus.CounterVec
+var downloadPeriodicity *prometheus.GaugeVec
+var downloadCircuitBreakerOpen *prometheus.GaugeVec
+var downloadScheduled *prometheus.GaugeVec
+var auditMissingHours *prometheus.GaugeVec
+var downloadErrorsKeptCount *prometheus.CounterVec
+var downloadErrorsDroppedCount *prometheus.CounterVec
+var liveReplicasCount prometheus.Gauge
 var packCount *prometheus.CounterVec
 var packFailedCount *prometheus.CounterVec
 var packUnpackedSize *prometheus.CounterVec
@@ -17,7 +35,12 @@ var packPackedSize *prometheus.CounterVec
 var packFileErrors *prometheus.CounterVec
 var uploadCount *prometheus.CounterVec
 var uploadFailedCount *prometheus.CounterVec
+var partialUploadCount *prometheus.CounterVec
+var partialUploadFailedCount *prometheus.CounterVec
 var auditFailedCount *prometheus.CounterVec
+var auditMissingDictionaries *prometheus.GaugeVec
+var dictionaryTrainedCount *prometheus.CounterVec
+var dictionaryTrainingFailedCount *prometheus.CounterVec
 var localFilesCount *prometheus.GaugeVec
 var localFilesSize *prometheus.GaugeVec
 var remoteStorageDownloadCount *prometheus.CounterVec
@@ -33,17 +56,73 @@ func init() {
 	downloadCount = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "hoard_download_count",
-			Help: "Number of times an attempt has been made to download a feed",
+			Help: "Number of times an attempt has been made to download a feed. Retries of a failed attempt are not counted separately",
 		},
 		[]string{"feed_id"},
 	)
 	downloadFailedCount = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "hoard_download_failed_count",
-			Help: "Number of times a feed download attempt failed",
+			Help: "Number of times a feed download attempt failed, including all of its retries",
+		},
+		[]string{"feed_id"},
+	)
+	downloadRetryCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_retry_count",
+			Help: "Number of times a failed feed download attempt was retried within the same download cycle",
 		},
 		[]string{"feed_id"},
 	)
+	downloadNotModifiedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_not_modified_count",
+			Help: "Number of successful downloads of a feed for which the server reported the data had not changed",
+		},
+		[]string{"feed_id"},
+	)
+	downloadInvalidCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_invalid_count",
+			Help: "Number of downloads of a feed that were rejected because the response failed validation",
+		},
+		[]string{"feed_id"},
+	)
+	downloadURLCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_url_count",
+			Help: "Number of successful requests to each URL of a feed",
+		},
+		[]string{"feed_id", "url_index"},
+	)
+	downloadURLFailedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_url_failed_count",
+			Help: "Number of failed requests to each URL of a feed",
+		},
+		[]string{"feed_id", "url_index"},
+	)
+	rateLimitDelayedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_rate_limit_delayed_count",
+			Help: "Number of requests of a feed that were delayed by a rate limit",
+		},
+		[]string{"rate_limit", "feed_id"},
+	)
+	rateLimitDelayedSeconds = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_rate_limit_delayed_seconds",
+			Help: "Total time requests of a feed were delayed by a rate limit",
+		},
+		[]string{"rate_limit", "feed_id"},
+	)
+	rateLimitDroppedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_rate_limit_dropped_count",
+			Help: "Number of requests of a feed that were dropped because of a rate limit",
+		},
+		[]string{"rate_limit", "feed_id"},
+	)
 	downloadSavedCount = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "hoard_download_saved_count",
@@ -58,6 +137,54 @@ func init() {
 		},
 		[]string{"feed_id"},
 	)
+	downloadPeriodicity = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hoard_download_periodicity_seconds",
+			Help: "Current period with which a feed is being downloaded",
+		},
+		[]string{"feed_id"},
+	)
+	downloadCircuitBreakerOpen = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hoard_download_circuit_breaker_open",
+			Help: "Whether the circuit breaker for a feed is open (1) or closed (0)",
+		},
+		[]string{"feed_id"},
+	)
+	downloadScheduled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hoard_download_scheduled",
+			Help: "Whether a feed is currently within its schedule windows (1) or not (0)",
+		},
+		[]string{"feed_id"},
+	)
+	auditMissingHours = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hoard_audit_missing_hours",
+			Help: "Number of hours in the last audit within the feed's schedule for which there is no data",
+		},
+		[]string{"feed_id"},
+	)
+	downloadErrorsKeptCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_errors_kept_count",
+			Help: "Number of failed responses kept in the errors feed",
+		},
+		[]string{"feed_id"},
+	)
+	downloadErrorsDroppedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_download_errors_dropped_count",
+			Help: "Number of failed responses not kept because the hourly maximum was reached",
+		},
+		[]string{"feed_id"},
+	)
+	liveReplicasCount = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hoard_replicas_live_count",
+			Help: "Number of replicas with a recent heartbeat, including this replica",
+		},
+	)
 	packCount = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "hoard_pack_count",
@@ -107,6 +234,41 @@ func init() {
 		},
 		[]string{"feed_id"},
 	)
+	partialUploadCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_partial_upload_count",
+			Help: "Number of times a partial archive of the current bucket has been uploaded for each feed",
+		},
+		[]string{"feed_id"},
+	)
+	partialUploadFailedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_partial_upload_failed_count",
+			Help: "Number of failed uploads of partial archives for each feed",
+		},
+		[]string{"feed_id"},
+	)
+	dictionaryTrainedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_dictionary_trained_count",
+			Help: "Number of new versions of the compression dictionary trained for each feed",
+		},
+		[]string{"feed_id"},
+	)
+	dictionaryTrainingFailedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hoard_dictionary_training_failed_count",
+			Help: "Number of failed attempts to train a new version of the compression dictionary for each feed",
+		},
+		[]string{"feed_id"},
+	)
+	auditMissingDictionaries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hoard_audit_missing_dictionaries",
+			Help: "Number of archives in the last audit that cannot be decompressed because their dictionary is missing",
+		},
+		[]string{"feed_id"},
+	)
 	localFilesCount = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "hoard_local_files_count",
@@ -199,6 +361,70 @@ func RecordDownload(feed *config.Feed, err error) {
 	}
 }
 
+// RecordURLRequest records the result of a request to one of the URLs of a feed. The URL
+// is identified by its index in the feed configuration.
+func RecordURLRequest(feed *config.Feed, urlIndex int, succeeded bool) {
+	index := strconv.Itoa(urlIndex)
+	if succeeded {
+		downloadURLCount.WithLabelValues(feed.ID, index).Inc()
+	} else {
+		downloadURLFailedCount.WithLabelValues(feed.ID, index).Inc()
+	}
+}
+
+func RecordRateLimitDelayed(feed *config.Feed, rateLimit string, delay time.Duration) {
+	rateLimitDelayedCount.WithLabelValues(rateLimit, feed.ID).Inc()
+	rateLimitDelayedSeconds.WithLabelValues(rateLimit, feed.ID).Add(delay.Seconds())
+}
+
+func RecordRateLimitDropped(feed *config.Feed, rateLimit string) {
+	rateLimitDroppedCount.WithLabelValues(rateLimit, feed.ID).Inc()
+}
+
+func RecordDownloadRetry(feed *config.Feed) {
+	downloadRetryCount.WithLabelValues(feed.ID).Inc()
+}
+
+func RecordNotModifiedDownload(feed *config.Feed) {
+	downloadNotModifiedCount.WithLabelValues(feed.ID).Inc()
+}
+
+func RecordInvalidDownload(feed *config.Feed) {
+	downloadInvalidCount.WithLabelValues(feed.ID).Inc()
+}
+
+func RecordDownloadPeriodicity(feed *config.Feed, period time.Duration) {
+	downloadPeriodicity.WithLabelValues(feed.ID).Set(period.Seconds())
+}
+
+func RecordDownloadCircuitBreaker(feed *config.Feed, open bool) {
+	var v float64
+	if open {
+		v = 1
+	}
+	downloadCircuitBreakerOpen.WithLabelValues(feed.ID).Set(v)
+}
+
+func RecordDownloadScheduled(feed *config.Feed, scheduled bool) {
+	var v float64
+	if scheduled {
+		v = 1
+	}
+	downloadScheduled.WithLabelValues(feed.ID).Set(v)
+}
+
+func RecordKeptError(feed *config.Feed) {
+	downloadErrorsKeptCount.WithLabelValues(feed.ID).Inc()
+}
+
+func RecordDroppedError(feed *config.Feed) {
+	downloadErrorsDroppedCount.WithLabelValues(feed.ID).Inc()
+}
+
+func RecordLiveReplicas(count int) {
+	liveReplicasCount.Set(float64(count))
+}
+
 func RecordPack(feed *config.Feed, err error) {
 	if err != nil {
 		RecordPackFileErrors(feed, err)
@@ -225,12 +451,36 @@ func RecordUpload(feed *config.Feed, err error) {
 	}
 }
 
+func RecordPartialUpload(feed *config.Feed, err error) {
+	if err != nil {
+		partialUploadFailedCount.WithLabelValues(feed.ID).Inc()
+	} else {
+		partialUploadCount.WithLabelValues(feed.ID).Inc()
+	}
+}
+
+func RecordDictionaryTraining(feed *config.Feed, err error) {
+	if err != nil {
+		dictionaryTrainingFailedCount.WithLabelValues(feed.ID).Inc()
+	} else {
+		dictionaryTrainedCount.WithLabelValues(feed.ID).Inc()
+	}
+}
+
 func RecordAudit(feed *config.Feed, err error) {
 	if err != nil {
 		auditFailedCount.WithLabelValues(feed.ID).Inc()
 	}
 }
 
+func RecordAuditMissingHours(feed *config.Feed, n int) {
+	auditMissingHours.WithLabelValues(feed.ID).Set(float64(n))
+}
+
+func RecordAuditMissingDictionaries(feed *config.Feed, n int) {
+	auditMissingDictionaries.WithLabelValues(feed.ID).Set(float64(n))
+}
+
 func RecordDiskUsage(subDir string, feedID string, count int, size int64) {
 	localFilesCount.WithLabelValues(subDir, feedID).Set(float64(count))
 	localFilesSize.WithLabelValues(subDir, feedID).Set(float64(size))
@@ -0,0 +1,93 @@
+// Package ratelimit contains the rate limits on requests that are shared between feeds.
+//
+// Each feed is downloaded in its own goroutine, so a Registry is created once for the
+// collector and used by all of the feeds.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/monitoring"
+	"golang.org/x/time/rate"
+)
+
+// Registry contains the rate limits of a Hoard configuration. A nil Registry imposes no
+// rate limits.
+type Registry struct {
+	limiters []*limiter
+}
+
+type limiter struct {
+	rateLimit *config.RateLimit
+	hosts     map[string]bool
+	limiter   *rate.Limiter
+}
+
+// NewRegistry creates a Registry containing the rate limits.
+func NewRegistry(rateLimits []config.RateLimit) *Registry {
+	r := &Registry{}
+	for i := range rateLimits {
+		rateLimit := &rateLimits[i]
+		l := &limiter{
+			rateLimit: rateLimit,
+			hosts:     map[string]bool{},
+			limiter: rate.NewLimiter(
+				rate.Limit(float64(rateLimit.Requests)/rateLimit.PeriodActual().Seconds()),
+				rateLimit.BurstActual(),
+			),
+		}
+		for _, host := range rateLimit.Hosts {
+			l.hosts[strings.ToLower(host)] = true
+		}
+		r.limiters = append(r.limiters, l)
+	}
+	return r
+}
+
+// Wait waits until a request for the feed to the host is allowed by all of the rate limits
+// that apply to it. An error is returned if the request is dropped because it would wait
+// longer than the maximum delay of a rate limit, or if the context is cancelled.
+func (r *Registry) Wait(ctx context.Context, feed *config.Feed, host string) error {
+	if r == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	for _, l := range r.limiters {
+		if feed.RateLimit != l.rateLimit.Name && !l.hosts[host] {
+			continue
+		}
+		if err := l.wait(ctx, feed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *limiter) wait(ctx context.Context, feed *config.Feed) error {
+	name := l.rateLimit.Name
+	reservation := l.limiter.Reserve()
+	delay := reservation.Delay()
+	if l.rateLimit.MaxDelay > 0 && delay > l.rateLimit.MaxDelay {
+		reservation.Cancel()
+		monitoring.RecordRateLimitDropped(feed, name)
+		return fmt.Errorf("request dropped by rate limit %s: the required delay %s exceeds the maximum delay %s",
+			name, delay, l.rateLimit.MaxDelay)
+	}
+	if delay == 0 {
+		return nil
+	}
+	monitoring.RecordRateLimitDelayed(feed, name, delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+var feed1 = &config.Feed{ID: "feed1"}
+var feed2 = &config.Feed{ID: "feed2", RateLimit: "group"}
+
+func TestRegistry_Delay(t *testing.T) {
+	r := NewRegistry([]config.RateLimit{
+		{Name: "host", Hosts: []string{"api.example.com"}, Requests: 1, Period: 100 * time.Millisecond},
+	})
+
+	start := time.Now()
+	testutil.ErrorOrFail(t, r.Wait(context.Background(), feed1, "api.example.com"))
+	testutil.ErrorOrFail(t, r.Wait(context.Background(), feed2, "API.example.com"))
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Unexpected elapsed time %s; expected the second request to be delayed", elapsed)
+	}
+}
+
+func TestRegistry_Drop(t *testing.T) {
+	r := NewRegistry([]config.RateLimit{
+		{Name: "group", Requests: 1, Period: time.Hour, MaxDelay: time.Second},
+	})
+
+	testutil.ErrorOrFail(t, r.Wait(context.Background(), feed2, "api.example.com"))
+	err := r.Wait(context.Background(), feed2, "api.example.com")
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+}
+
+func TestRegistry_OnlyMatchingRequestsAreLimited(t *testing.T) {
+	r := NewRegistry([]config.RateLimit{
+		{Name: "group", Hosts: []string{"api.example.com"}, Requests: 1, Period: time.Hour, MaxDelay: time.Second},
+	})
+
+	for i := 0; i < 3; i++ {
+		testutil.ErrorOrFail(t, r.Wait(context.Background(), feed1, "other.example.com"))
+	}
+}
+
+func TestRegistry_ContextCancelled(t *testing.T) {
+	r := NewRegistry([]config.RateLimit{
+		{Name: "group", Requests: 1, Period: time.Hour},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testutil.ErrorOrFail(t, r.Wait(ctx, feed2, ""))
+	err := r.Wait(ctx, feed2, "")
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+}
+
+func TestRegistry_Nil(t *testing.T) {
+	var r *Registry
+
+	testutil.ErrorOrFail(t, r.Wait(context.Background(), feed1, "api.example.com"))
+}
@@ -0,0 +1,217 @@
+// Package replicas contains the coordination between Hoard replicas that collect the same
+// feeds.
+//
+// Each replica regularly writes a heartbeat object containing its ID to every configured
+// object storage. From the heartbeats, each replica determines the set of live replicas and
+// its own index within that set. The download times of replica i of n are then offset by
+// period * i / n, so that the merged data of all replicas covers each period evenly. If a
+// replica stops, its heartbeat expires and the remaining replicas spread out again.
+//
+// The offsets are relative to the wall clock, so the coordination relies on the clocks of
+// the replicas being reasonably synchronized.
+package replicas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
+)
+
+// heartbeatsRoot is the directory, relative to the object storage prefix, in which
+// heartbeat objects are stored.
+const heartbeatsRoot = "_replicas"
+
+// A heartbeat is considered expired after this many heartbeat periods have passed.
+const heartbeatExpiryPeriods = 3
+
+type heartbeat struct {
+	ReplicaID string
+	Time      time.Time
+}
+
+// Coordinator writes the heartbeat of this replica and tracks the heartbeats of the other
+// replicas. A nil Coordinator represents a replica that does not coordinate.
+type Coordinator struct {
+	id     string
+	period time.Duration
+	stores []persistence.PersistedStorage
+	log    *slog.Logger
+	now    func() time.Time
+
+	mu   sync.Mutex
+	live []string
+}
+
+// NewCoordinator creates the Coordinator for the configuration. It returns nil if phase
+// coordination is not enabled or if no object storage is configured.
+func NewCoordinator(c *config.Config, log *slog.Logger) (*Coordinator, error) {
+	if !c.Replicas.CoordinatePhase {
+		return nil, nil
+	}
+	if len(c.ObjectStorage) == 0 {
+		log.Warn("Phase coordination is enabled but no object storage is configured; downloads will not be coordinated")
+		return nil, nil
+	}
+	// The heartbeats are stored in their own directory alongside the feed directories.
+	root := &config.Feed{ID: heartbeatsRoot}
+	var stores []persistence.PersistedStorage
+	for i := range c.ObjectStorage {
+		// The background context is used so that the heartbeat can be deleted during shutdown.
+		store, err := persistence.NewObjectPersistedStorage(context.Background(), &c.ObjectStorage[i], root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize object storage for heartbeats: %w", err)
+		}
+		stores = append(stores, store)
+	}
+	return newCoordinator(c.Replicas.IDActual(), c.Replicas.HeartbeatPeriodActual(), stores, log, time.Now), nil
+}
+
+func newCoordinator(id string, period time.Duration, stores []persistence.PersistedStorage, log *slog.Logger, now func() time.Time) *Coordinator {
+	return &Coordinator{
+		id:     id,
+		period: period,
+		stores: stores,
+		log:    log.With("replica", id),
+		now:    now,
+		live:   []string{id},
+	}
+}
+
+// Run writes heartbeats periodically until the context is cancelled, after which the
+// heartbeat of this replica is deleted so that the other replicas adjust immediately.
+func (c *Coordinator) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.log.Info("Starting replica heartbeats")
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+	for {
+		if err := c.Beat(); err != nil {
+			c.log.Error(fmt.Sprintf("Error writing replica heartbeat: %s", err))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, store := range c.stores {
+				if err := store.Delete(c.key()); err != nil {
+					c.log.Error(fmt.Sprintf("Error deleting replica heartbeat: %s", err))
+				}
+			}
+			c.log.Info("Stopped replica heartbeats")
+			return
+		}
+	}
+}
+
+// Beat writes the heartbeat of this replica and refreshes the set of live replicas.
+func (c *Coordinator) Beat() error {
+	now := c.now()
+	b, err := json.Marshal(heartbeat{ReplicaID: c.id, Time: now})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, store := range c.stores {
+		if err := store.Put(c.key(), bytes.NewReader(b), now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	live := map[string]bool{c.id: true}
+	numRead := 0
+	for _, store := range c.stores {
+		ids, err := c.readLive(store, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		numRead++
+		for _, id := range ids {
+			live[id] = true
+		}
+	}
+	// If no heartbeats could be read the previous set of live replicas is kept.
+	if numRead > 0 {
+		var ids []string
+		for id := range live {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		c.mu.Lock()
+		c.live = ids
+		c.mu.Unlock()
+		monitoring.RecordLiveReplicas(len(ids))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors while reading and writing heartbeats; first error: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// readLive returns the IDs of the replicas with unexpired heartbeats in the store.
+func (c *Coordinator) readLive(store persistence.PersistedStorage, now time.Time) ([]string, error) {
+	results, err := store.Search(persistence.EmptyPrefix())
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, result := range results {
+		if len(result.Prefix) != 0 {
+			continue
+		}
+		for _, name := range result.Names {
+			hb, err := readHeartbeat(store, persistence.Key{Prefix: persistence.EmptyPrefix(), Name: name})
+			if err != nil {
+				c.log.Warn(fmt.Sprintf("Skipping unreadable heartbeat %s: %s", name, err))
+				continue
+			}
+			if now.Sub(hb.Time) > heartbeatExpiryPeriods*c.period {
+				continue
+			}
+			ids = append(ids, hb.ReplicaID)
+		}
+	}
+	return ids, nil
+}
+
+func readHeartbeat(store persistence.PersistedStorage, k persistence.Key) (heartbeat, error) {
+	var hb heartbeat
+	reader, err := store.Get(k)
+	if err != nil {
+		return hb, err
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return hb, err
+	}
+	err = json.Unmarshal(b, &hb)
+	return hb, err
+}
+
+func (c *Coordinator) key() persistence.Key {
+	return persistence.Key{Prefix: persistence.EmptyPrefix(), Name: c.id}
+}
+
+// Offset returns the offset of this replica's downloads within a period: period * i / n,
+// where n is the number of live replicas and i is the index of this replica when the
+// replicas are sorted by ID. A nil Coordinator always returns 0.
+func (c *Coordinator) Offset(period time.Duration) time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := sort.SearchStrings(c.live, c.id)
+	return time.Duration(int64(period) * int64(index) / int64(len(c.live)))
+}
@@ -0,0 +1,67 @@
+package replicas
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+const period = 30 * time.Second
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func TestCoordinator_Offsets(t *testing.T) {
+	store := persistence.NewInMemoryPersistedStorage()
+	c := &clock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	coordinators := []*Coordinator{
+		newCoordinator("c", period, []persistence.PersistedStorage{store}, slog.Default(), c.now),
+		newCoordinator("a", period, []persistence.PersistedStorage{store}, slog.Default(), c.now),
+		newCoordinator("b", period, []persistence.PersistedStorage{store}, slog.Default(), c.now),
+	}
+	for i := 0; i < 2; i++ {
+		for _, coordinator := range coordinators {
+			testutil.ErrorOrFail(t, coordinator.Beat())
+		}
+	}
+
+	for i, expected := range []time.Duration{4 * time.Second, 0, 2 * time.Second} {
+		if actual := coordinators[i].Offset(6 * time.Second); actual != expected {
+			t.Errorf("Unexpected offset %s for replica %s; expected %s", actual, coordinators[i].id, expected)
+		}
+	}
+}
+
+func TestCoordinator_ExpiredHeartbeat(t *testing.T) {
+	store := persistence.NewInMemoryPersistedStorage()
+	c := &clock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := newCoordinator("a", period, []persistence.PersistedStorage{store}, slog.Default(), c.now)
+	b := newCoordinator("b", period, []persistence.PersistedStorage{store}, slog.Default(), c.now)
+	testutil.ErrorOrFail(t, a.Beat())
+	testutil.ErrorOrFail(t, b.Beat())
+	if actual := b.Offset(time.Minute); actual != 30*time.Second {
+		t.Errorf("Unexpected offset %s; expected 30s", actual)
+	}
+
+	c.t = c.t.Add(heartbeatExpiryPeriods*period + time.Second)
+	testutil.ErrorOrFail(t, b.Beat())
+
+	if actual := b.Offset(time.Minute); actual != 0 {
+		t.Errorf("Unexpected offset %s after the other heartbeat expired; expected 0", actual)
+	}
+}
+
+func TestCoordinator_Nil(t *testing.T) {
+	var c *Coordinator
+	if actual := c.Offset(time.Minute); actual != 0 {
+		t.Errorf("Unexpected offset %s; expected 0", actual)
+	}
+}
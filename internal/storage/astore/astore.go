@@ -49,6 +49,14 @@ func (a PersistedAStore) Get(file storage.AFile) (io.ReadCloser, error) {
 	return r, err
 }
 
+func (a PersistedAStore) GetRange(file storage.AFile, offset int64, length int64) (io.ReadCloser, error) {
+	r, err := persistence.GetRange(a.b, aFileToPersistenceKey(file), offset, length)
+	if err != nil {
+		r, err = persistence.GetRange(a.b, aFileToLegacyPersistenceKey(file), offset, length)
+	}
+	return r, err
+}
+
 func (a PersistedAStore) Delete(file storage.AFile) error {
 	return util.NewMultipleError(
 		a.b.Delete(aFileToPersistenceKey(file)),
@@ -119,6 +127,9 @@ func generatePrefixesForSearch(startOpt *hour.Hour, end hour.Hour) []persistence
 		increment = 364 * 24
 		prefixLength = 1
 	}
+	// The prefixes are at most hour prefixes. Sub-hour buckets are stored one level below
+	// their hour, so they are found by the same searches as whole hours.
+	//
 	// We put the prefixes in a set (essentially) in order to guarantee there
 	// are no duplicates. This, along with the constraint that all prefixes
 	// returned have the same length, guarantees that there is no prefix
@@ -215,13 +226,24 @@ func (a *InMemoryAStore) String() string {
 
 // TODO: write tests for this
 type ReplicatedAStore struct {
-	aStores []storage.AStore
+	aStores      []storage.AStore
+	dictionaries storage.Dictionaries
 }
 
 func NewReplicatedAStore(aStores ...storage.AStore) ReplicatedAStore {
 	return ReplicatedAStore{aStores: aStores}
 }
 
+// WithDictionaries returns a copy of the AStore that provides the compression dictionaries.
+func (m ReplicatedAStore) WithDictionaries(d storage.Dictionaries) ReplicatedAStore {
+	m.dictionaries = d
+	return m
+}
+
+func (m ReplicatedAStore) Dictionaries() storage.Dictionaries {
+	return m.dictionaries
+}
+
 func (m ReplicatedAStore) Store(aFile storage.AFile, reader io.Reader) error {
 	// TODO: is there a better way here?
 	content, err := io.ReadAll(reader)
@@ -259,6 +281,19 @@ func (m ReplicatedAStore) Get(aFile storage.AFile) (io.ReadCloser, error) {
 		util.NewMultipleError(errs...))
 }
 
+func (m ReplicatedAStore) GetRange(aFile storage.AFile, offset int64, length int64) (io.ReadCloser, error) {
+	var errs []error
+	for _, aStore := range m.aStores {
+		b, err := storage.ReadAFileRange(aStore, aFile, offset, length)
+		if err == nil {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("failed to retrive from any AStore: %w",
+		util.NewMultipleError(errs...))
+}
+
 func (m ReplicatedAStore) Search(startOpt *hour.Hour, end hour.Hour) ([]storage.SearchResult, error) {
 	hourToSearchResult := map[hour.Hour]storage.SearchResult{}
 	var errs []error
@@ -310,3 +345,26 @@ func (m ReplicatedAStore) String() string {
 func (m ReplicatedAStore) Replicas() []storage.AStore {
 	return m.aStores
 }
+
+type dictionaryAStore struct {
+	storage.AStore
+	dictionaries storage.Dictionaries
+}
+
+// WithDictionaries returns an AStore that is the same as the provided AStore, but that also
+// provides the compression dictionaries.
+func WithDictionaries(aStore storage.AStore, d storage.Dictionaries) storage.AStore {
+	return dictionaryAStore{AStore: aStore, dictionaries: d}
+}
+
+func (a dictionaryAStore) Dictionaries() storage.Dictionaries {
+	return a.dictionaries
+}
+
+func (a dictionaryAStore) GetRange(aFile storage.AFile, offset int64, length int64) (io.ReadCloser, error) {
+	b, err := storage.ReadAFileRange(a.AStore, aFile, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
@@ -3,9 +3,11 @@ package dstore
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"time"
 
@@ -26,6 +28,10 @@ func (d FlatPersistedDStore) Store(file storage.DFile, content io.Reader) error
 	return d.b.Put(persistence.Key{Name: file.String()}, content, file.Time)
 }
 
+func (d FlatPersistedDStore) StoreMetadata(file storage.DFile, metadata storage.DFileMetadata) error {
+	return putMetadata(d.b, persistence.Key{Name: file.String() + storage.MetadataFileSuffix}, file, metadata)
+}
+
 type PersistedDStore struct {
 	b   persistence.PersistedStorage
 	log *slog.Logger
@@ -39,12 +45,51 @@ func (d PersistedDStore) Store(file storage.DFile, content io.Reader) error {
 	return d.b.Put(dFileToPersistenceKey(file), content, file.Time)
 }
 
+// StoreFile moves the file at the path into the DStore if the backing storage supports
+// this, and otherwise copies it.
+func (d PersistedDStore) StoreFile(file storage.DFile, path string) error {
+	return persistence.PutFile(d.b, dFileToPersistenceKey(file), path, file.Time)
+}
+
 func (d PersistedDStore) Get(file storage.DFile) (io.ReadCloser, error) {
 	return d.b.Get(dFileToPersistenceKey(file))
 }
 
+// Delete deletes the DFile and its metadata, if it has any.
 func (d PersistedDStore) Delete(file storage.DFile) error {
-	return d.b.Delete(dFileToPersistenceKey(file))
+	if err := d.b.Delete(dFileToPersistenceKey(file)); err != nil {
+		return err
+	}
+	return d.b.Delete(dFileToMetadataPersistenceKey(file))
+}
+
+// StoreMetadata stores the metadata in a file next to the DFile.
+func (d PersistedDStore) StoreMetadata(file storage.DFile, metadata storage.DFileMetadata) error {
+	return putMetadata(d.b, dFileToMetadataPersistenceKey(file), file, metadata)
+}
+
+func (d PersistedDStore) GetMetadata(file storage.DFile) (*storage.DFileMetadata, error) {
+	reader, err := d.b.Get(dFileToMetadataPersistenceKey(file))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var metadata storage.DFileMetadata
+	if err := json.NewDecoder(reader).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse the metadata of %s: %w", file.String(), err)
+	}
+	return &metadata, nil
+}
+
+func putMetadata(b persistence.PersistedStorage, k persistence.Key, file storage.DFile, metadata storage.DFileMetadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return b.Put(k, bytes.NewReader(content), file.Time)
 }
 
 func (d PersistedDStore) ListNonEmptyHours() ([]hour.Hour, error) {
@@ -73,6 +118,9 @@ func (d PersistedDStore) ListInHour(hour hour.Hour) ([]storage.DFile, error) {
 	var dFiles []storage.DFile
 	for _, searchResult := range searchResults {
 		for _, name := range searchResult.Names {
+			if storage.IsMetadataFileName(name) {
+				continue
+			}
 			dFile, ok := storage.NewDFileFromString(name)
 			if !ok {
 				d.log.Warn(fmt.Sprintf("Unrecognized file in persisted storage: %s %s", searchResult.Prefix, name))
@@ -91,14 +139,35 @@ func dFileToPersistenceKey(d storage.DFile) persistence.Key {
 	}
 }
 
+func dFileToMetadataPersistenceKey(d storage.DFile) persistence.Key {
+	k := dFileToPersistenceKey(d)
+	k.Name += storage.MetadataFileSuffix
+	return k
+}
+
 type InMemoryDStore struct {
-	dFileToContent map[storage.DFile][]byte
+	dFileToContent  map[storage.DFile][]byte
+	dFileToMetadata map[storage.DFile]storage.DFileMetadata
 }
 
 func NewInMemoryDStore() *InMemoryDStore {
 	return &InMemoryDStore{
-		dFileToContent: make(map[storage.DFile][]byte),
+		dFileToContent:  make(map[storage.DFile][]byte),
+		dFileToMetadata: make(map[storage.DFile]storage.DFileMetadata),
+	}
+}
+
+func (dstore *InMemoryDStore) StoreMetadata(dFile storage.DFile, metadata storage.DFileMetadata) error {
+	dstore.dFileToMetadata[dFile] = metadata
+	return nil
+}
+
+func (dstore *InMemoryDStore) GetMetadata(dFile storage.DFile) (*storage.DFileMetadata, error) {
+	metadata, ok := dstore.dFileToMetadata[dFile]
+	if !ok {
+		return nil, nil
 	}
+	return &metadata, nil
 }
 
 func (dstore *InMemoryDStore) Store(file storage.DFile, content io.Reader) error {
@@ -157,3 +157,46 @@ func TestByteStorageBackedDStore_ImplementationDetails(t *testing.T) {
 		t.Errorf("DFile content (%v) != key content (%v)", content, data)
 	}
 }
+
+func TestByteStorageBackedDStore_Metadata(t *testing.T) {
+	b := persistence.NewInMemoryPersistedStorage()
+	d := dstore.NewPersistedDStore(b, slog.Default())
+	dFile := storage.DFile{
+		Hash:    storage.ExampleHash(),
+		Time:    time.Date(2000, 1, 2, 3, 4, 5, int(time.Millisecond)*5, time.UTC),
+		Prefix:  "A",
+		Postfix: "B",
+	}
+	metadata := storage.DFileMetadata{
+		StatusCode: 200,
+		Headers:    map[string]string{"ETag": "tag"},
+		ServerIP:   "127.0.0.1",
+	}
+	testutil.ErrorOrFail(t, d.Store(dFile, bytes.NewReader(nil)))
+	metadataDStore := d.(storage.ReadableMetadataDStore)
+
+	actual, err := metadataDStore.GetMetadata(dFile)
+	testutil.ErrorOrFail(t, err)
+	if actual != nil {
+		t.Errorf("Unexpected metadata %v; expected none", actual)
+	}
+
+	testutil.ErrorOrFail(t, d.(storage.WritableMetadataDStore).StoreMetadata(dFile, metadata))
+	actual, err = metadataDStore.GetMetadata(dFile)
+	testutil.ErrorOrFail(t, err)
+	if actual == nil || !reflect.DeepEqual(*actual, metadata) {
+		t.Errorf("Unexpected metadata %v; expected %v", actual, metadata)
+	}
+	dFiles, err := d.ListInHour(hour.Date(2000, 1, 2, 3))
+	testutil.ErrorOrFail(t, err)
+	if len(dFiles) != 1 || dFiles[0] != dFile {
+		t.Errorf("Unexpected DFiles: %v != [%v]", dFiles, dFile)
+	}
+
+	testutil.ErrorOrFail(t, d.Delete(dFile))
+	actual, err = metadataDStore.GetMetadata(dFile)
+	testutil.ErrorOrFail(t, err)
+	if actual != nil {
+		t.Errorf("Unexpected metadata %v after deletion", actual)
+	}
+}
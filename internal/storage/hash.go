@@ -3,6 +3,7 @@ package storage
 import (
 	"crypto/sha256"
 	"encoding/base32"
+	"hash"
 )
 
 type Hash string
@@ -10,10 +11,30 @@ type Hash string
 const encodeStd = "abcdefghijklmnopqrstuvwxyz234567"
 
 func CalculateHash(b []byte) Hash {
-	h := sha256.New()
-	// hash.Hash#Write never returns an error
+	h := NewHasher()
+	// Hasher#Write never returns an error
 	_, _ = h.Write(b)
-	return Hash(base32.NewEncoding(encodeStd).EncodeToString(h.Sum(nil))[:12])
+	return h.Hash()
+}
+
+// Hasher calculates the Hash of data that is written to it incrementally. The result
+// is the same as calling CalculateHash on all of the data.
+type Hasher struct {
+	h hash.Hash
+}
+
+func NewHasher() *Hasher {
+	return &Hasher{h: sha256.New()}
+}
+
+// Write adds more data to the hash. It never returns an error.
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+// Hash returns the Hash of all data written so far.
+func (h *Hasher) Hash() Hash {
+	return Hash(base32.NewEncoding(encodeStd).EncodeToString(h.h.Sum(nil))[:12])
 }
 
 func ExampleHash() Hash {
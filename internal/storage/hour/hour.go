@@ -1,6 +1,8 @@
 package hour
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/jamespfennell/hoard/internal/storage/persistence"
 	"strconv"
@@ -8,42 +10,84 @@ import (
 	"time"
 )
 
+// Hour is the unit of time in which data is archived. It is usually a whole hour. Feeds can
+// also be configured to archive data in buckets that are a fraction of an hour wide; such a
+// bucket is represented by a sub-hour Hour, which includes the minute the bucket starts at.
+//
+// Sub-hour Hours are stored one level deeper in persisted storage than whole hours, so both
+// can be stored side by side. Where a range of hours is specified, like in searches, the
+// range contains all of the sub-hour buckets within its hours.
 type Hour struct {
-	t time.Time
+	t       time.Time
+	subHour bool
 }
 
 func (h Hour) String() string {
 	t := h.t
+	if h.subHour {
+		return fmt.Sprintf("%4d/%02d/%02d/%02d:%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute())
+	}
 	return fmt.Sprintf("%4d/%02d/%02d/%02d", t.Year(), t.Month(), t.Day(), t.Hour())
 }
 
 func (h Hour) PersistencePrefix() persistence.Prefix {
 	t := h.t
 	// TODO: use t.Format?
-	return []string{
+	p := []string{
 		formatInt(t.Year()),
 		formatInt(int(t.Month())),
 		formatInt(t.Day()),
 		formatInt(t.Hour()),
 	}
+	if h.subHour {
+		p = append(p, formatInt(t.Minute()))
+	}
+	return p
+}
+
+// jsonSubHour is the JSON representation of a sub-hour Hour. Whole hours are represented
+// by their start time, as they were before sub-hour buckets were introduced.
+type jsonSubHour struct {
+	Start   time.Time
+	SubHour bool
 }
 
 func (h Hour) MarshalJSON() ([]byte, error) {
+	if h.subHour {
+		return json.Marshal(jsonSubHour{Start: h.t, SubHour: true})
+	}
 	return h.t.MarshalJSON()
 }
 
 func (h *Hour) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		var j jsonSubHour
+		if err := json.Unmarshal(b, &j); err != nil {
+			return err
+		}
+		*h = Hour{t: j.Start, subHour: j.SubHour}
+		return nil
+	}
 	t := time.Time{}
 	if err := t.UnmarshalJSON(b); err != nil {
 		return err
 	}
-	*h = Hour{t}
+	*h = Hour{t: t}
 	return nil
 }
 
 func (h Hour) ISO8601() string {
 	t := h.t
 	// TODO: use t.Format
+	if h.subHour {
+		return fmt.Sprintf("%04d%02d%02dT%02d%02dZ",
+			t.Year(),
+			t.Month(),
+			t.Day(),
+			t.Hour(),
+			t.Minute(),
+		)
+	}
 	return fmt.Sprintf("%04d%02d%02dT%02dZ",
 		t.Year(),
 		t.Month(),
@@ -52,46 +96,89 @@ func (h Hour) ISO8601() string {
 	)
 }
 
+// IsSubHour returns true if the Hour is a bucket that is a fraction of an hour wide.
+func (h Hour) IsSubHour() bool {
+	return h.subHour
+}
+
+// ContainingHour returns the whole hour that contains the Hour.
+func (h Hour) ContainingHour() Hour {
+	return Hour{t: h.t.Truncate(time.Hour)}
+}
+
+// Time returns the start of the hour.
+func (h Hour) Time() time.Time {
+	return h.t
+}
+
 func (h Hour) Sub(h2 Hour) int {
 	return int(h.t.Sub(h2.t) / time.Hour)
 }
 
 func (h Hour) Add(i int) Hour {
-	return Hour{h.t.Add(time.Duration(i) * time.Hour)}
+	return Hour{t: h.t.Add(time.Duration(i) * time.Hour), subHour: h.subHour}
 }
 
 func (h Hour) Before(h2 Hour) bool {
 	return h.t.Before(h2.t)
 }
 
-// IsBetween is inclusive
+// IsBetween is inclusive. If the end is a whole hour, all sub-hour buckets within that
+// hour are before the end.
 func (h Hour) IsBetween(startOpt *Hour, end Hour) bool {
-	if end.Before(h) {
+	if end.subHour {
+		if end.t.Before(h.t) {
+			return false
+		}
+	} else if !h.t.Before(end.t.Add(time.Hour)) {
 		return false
 	}
 	if startOpt == nil {
 		return true
 	}
-	return !h.Before(*startOpt)
+	return !h.t.Before(startOpt.t)
 }
 
 func NewHourFromPersistencePrefix(p persistence.Prefix) (Hour, bool) {
-	if len(p) != 4 {
-		return Hour{}, false
+	switch len(p) {
+	case 4:
+		t, err := time.Parse("2006-01-02-15", strings.Join(p, "-"))
+		if err != nil {
+			return Hour{}, false
+		}
+		return Hour{t: t}, true
+	case 5:
+		t, err := time.Parse("2006-01-02-15-04", strings.Join(p, "-"))
+		if err != nil {
+			return Hour{}, false
+		}
+		return Hour{t: t, subHour: true}, true
 	}
-	t, err := time.Parse("2006-01-02-15", strings.Join(p, "-"))
-	if err != nil {
-		return Hour{}, false
-	}
-	return Hour{t}, true
+	return Hour{}, false
 }
 
 func Now() Hour {
-	return Hour{time.Now().UTC().Truncate(time.Hour)}
+	return Hour{t: time.Now().UTC().Truncate(time.Hour)}
 }
 
 func Date(year int, month time.Month, day, hour int) Hour {
-	return Hour{time.Date(year, month, day, hour, 0, 0, 0, time.UTC)}
+	return Hour{t: time.Date(year, month, day, hour, 0, 0, 0, time.UTC)}
+}
+
+// SubHourDate returns the sub-hour bucket that starts at the provided minute.
+func SubHourDate(year int, month time.Month, day, hour, minute int) Hour {
+	return Hour{t: time.Date(year, month, day, hour, minute, 0, 0, time.UTC), subHour: true}
+}
+
+// FromTimeWithWidth returns the bucket of the provided width that contains the time. If the
+// width is less than an hour, it must divide an hour and the bucket is a sub-hour Hour.
+// Otherwise, the bucket is the whole hour containing the time.
+func FromTimeWithWidth(t time.Time, width time.Duration) Hour {
+	if width <= 0 || width >= time.Hour {
+		return FromTime(t)
+	}
+	t = t.In(time.UTC)
+	return Hour{t: t.Truncate(time.Hour).Add(t.Sub(t.Truncate(time.Hour)).Truncate(width)), subHour: true}
 }
 
 func FromTime(t time.Time) Hour {
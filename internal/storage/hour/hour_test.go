@@ -1,6 +1,7 @@
 package hour
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 )
@@ -19,3 +20,55 @@ func TestFromTime(t *testing.T) {
 		t.Errorf("don't match: ny=%s, utc=%s", nyHour, utcHour)
 	}
 }
+
+func TestFromTimeWithWidth(t *testing.T) {
+	tm := time.Date(2022, time.January, 23, 2, 49, 30, 0, time.UTC)
+	for _, testCase := range []struct {
+		width    time.Duration
+		expected Hour
+	}{
+		{0, Date(2022, time.January, 23, 2)},
+		{time.Hour, Date(2022, time.January, 23, 2)},
+		{15 * time.Minute, SubHourDate(2022, time.January, 23, 2, 45)},
+		{5 * time.Minute, SubHourDate(2022, time.January, 23, 2, 45)},
+		{10 * time.Minute, SubHourDate(2022, time.January, 23, 2, 40)},
+	} {
+		if actual := FromTimeWithWidth(tm, testCase.width); actual != testCase.expected {
+			t.Errorf("Unexpected bucket %s for width %s; expected %s", actual, testCase.width, testCase.expected)
+		}
+	}
+}
+
+func TestIsBetween_SubHour(t *testing.T) {
+	start := Date(2022, time.January, 23, 2)
+	end := Date(2022, time.January, 23, 3)
+	for _, testCase := range []struct {
+		h        Hour
+		expected bool
+	}{
+		{SubHourDate(2022, time.January, 23, 1, 55), false},
+		{SubHourDate(2022, time.January, 23, 2, 0), true},
+		{SubHourDate(2022, time.January, 23, 3, 55), true},
+		{SubHourDate(2022, time.January, 23, 4, 0), false},
+	} {
+		if actual := testCase.h.IsBetween(&start, end); actual != testCase.expected {
+			t.Errorf("Unexpected IsBetween %t for %s; expected %t", actual, testCase.h, testCase.expected)
+		}
+	}
+}
+
+func TestHour_JSONRoundTrip(t *testing.T) {
+	for _, h := range []Hour{Date(2022, time.January, 23, 2), SubHourDate(2022, time.January, 23, 2, 0)} {
+		b, err := json.Marshal(h)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		var actual Hour
+		if err := json.Unmarshal(b, &actual); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if actual != h {
+			t.Errorf("Unexpected hour %s after round trip; expected %s", actual, h)
+		}
+	}
+}
@@ -41,8 +41,25 @@ func (b *DiskPersistedStorage) Put(k Key, r io.Reader, t time.Time) error {
 	}
 	if _, err = io.Copy(file, r); err != nil {
 		// TODO: in this case should we delete the on disk file?
+		_ = file.Close()
 		return err
 	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(fullPath, t, t)
+}
+
+// PutFile moves the file at the path into storage. If the file cannot be renamed, for
+// example because it is on a different filesystem, it is copied instead.
+func (b *DiskPersistedStorage) PutFile(k Key, filePath string, t time.Time) error {
+	fullPath := path.Join(b.root, k.id())
+	if err := os.MkdirAll(path.Dir(fullPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(filePath, fullPath); err != nil {
+		return putFileByCopying(b, k, filePath, t)
+	}
 	return os.Chtimes(fullPath, t, t)
 }
 
@@ -50,6 +67,25 @@ func (b *DiskPersistedStorage) Get(k Key) (io.ReadCloser, error) {
 	return os.Open(path.Join(b.root, k.id()))
 }
 
+func (b *DiskPersistedStorage) GetRange(k Key, offset int64, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(b.root, k.id()))
+	if err != nil {
+		return nil, err
+	}
+	whence := io.SeekStart
+	if offset < 0 {
+		whence, length = io.SeekEnd, -offset
+	}
+	if _, err := f.Seek(offset, whence); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
 func (b *DiskPersistedStorage) Delete(k Key) error {
 	fullPath := path.Join(b.root, k.id())
 	err := b.remove(fullPath)
@@ -2,12 +2,14 @@ package persistence
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const root = "/hoard/workspace/downloads"
@@ -218,3 +220,32 @@ func resultListToMap(results []SearchResult) map[string]SearchResult {
 	}
 	return m
 }
+
+func TestOnDiskByteStorage_PutFile(t *testing.T) {
+	storage := NewDiskPersistedStorage(t.TempDir())
+	filePath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, []byte("content"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	k := Key{Prefix: []string{"a", "b"}, Name: "c"}
+
+	if err := PutFile(storage, k, filePath, time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed from its original location")
+	}
+	reader, err := storage.Get(k)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(b) != "content" {
+		t.Errorf("Unexpected content %q", b)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"time"
 )
@@ -75,6 +76,82 @@ type PersistedStorage interface {
 	fmt.Stringer
 }
 
+// FilePutter is implemented by PersistedStorage that can take ownership of a file on local
+// disk, for example by renaming it. This avoids copying the data.
+type FilePutter interface {
+	// PutFile stores the file at the path under the key. After the method returns, whether
+	// successfully or not, the file at the path may no longer exist.
+	PutFile(k Key, path string, t time.Time) error
+}
+
+// PutFile stores the file on local disk at the path under the key, and then removes the
+// file. If the storage is a FilePutter the file is moved rather than copied.
+func PutFile(s PersistedStorage, k Key, path string, t time.Time) error {
+	if filePutter, ok := s.(FilePutter); ok {
+		return filePutter.PutFile(k, path, t)
+	}
+	return putFileByCopying(s, k, path, t)
+}
+
+func putFileByCopying(s PersistedStorage, k Key, path string, t time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(k, f, t); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// RangeGetter is implemented by PersistedStorage that can read part of the bytes stored under
+// a key without reading all of them, for example using HTTP range requests.
+type RangeGetter interface {
+	// GetRange returns length bytes starting at the offset. If the offset is negative, the
+	// last -offset bytes are returned and the length is ignored.
+	GetRange(k Key, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// GetRange returns part of the bytes stored under the key, with the same semantics as
+// RangeGetter. If the storage is not a RangeGetter all of the bytes are read and the range is
+// extracted from them.
+func GetRange(s PersistedStorage, k Key, offset int64, length int64) (io.ReadCloser, error) {
+	if rangeGetter, ok := s.(RangeGetter); ok {
+		return rangeGetter.GetRange(k, offset, length)
+	}
+	reader, err := s.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	b, err = SliceRange(b, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// SliceRange returns length bytes of b starting at the offset, with the same semantics as
+// RangeGetter. An error is returned if the range is out of bounds.
+func SliceRange(b []byte, offset int64, length int64) ([]byte, error) {
+	size := int64(len(b))
+	if offset < 0 {
+		offset, length = size+offset, -offset
+	}
+	if offset < 0 || length < 0 || offset+length > size {
+		return nil, fmt.Errorf("range [%d, %d) is out of bounds for %d bytes", offset, offset+length, size)
+	}
+	return b[offset : offset+length], nil
+}
+
 type verifyingStorage struct {
 	PersistedStorage
 }
@@ -119,6 +196,21 @@ func (s verifyingStorage) Put(k Key, reader io.Reader, t time.Time) error {
 	return nil
 }
 
+// PutFile moves the file into the underlying storage if possible. In this case the data
+// is not copied and so no verification is performed. Otherwise, the data is copied and
+// verified in the same way as Put.
+func (s verifyingStorage) PutFile(k Key, path string, t time.Time) error {
+	if filePutter, ok := s.PersistedStorage.(FilePutter); ok {
+		return filePutter.PutFile(k, path, t)
+	}
+	return putFileByCopying(s, k, path, t)
+}
+
+// GetRange reads the range from the underlying storage. No verification is performed.
+func (s verifyingStorage) GetRange(k Key, offset int64, length int64) (io.ReadCloser, error) {
+	return GetRange(s.PersistedStorage, k, offset, length)
+}
+
 func (s verifyingStorage) String() string {
 	return s.PersistedStorage.String() + " (with md5 verification)"
 }
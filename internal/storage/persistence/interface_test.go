@@ -103,3 +103,41 @@ func TestVerifyingStorage_FailureCase(t *testing.T) {
 		})
 	}
 }
+
+// noRangeStorage hides the GetRange method of the underlying storage
+type noRangeStorage struct {
+	persistence.PersistedStorage
+}
+
+func TestGetRange(t *testing.T) {
+	backing := persistence.NewInMemoryPersistedStorage()
+	testutil.ErrorOrFail(t, backing.Put(key, bytes.NewBufferString(data), time.Unix(0, 0)))
+	for _, s := range []persistence.PersistedStorage{
+		backing,
+		persistence.NewVerifyingStorage(backing),
+		noRangeStorage{backing},
+	} {
+		for _, testCase := range []struct {
+			offset   int64
+			length   int64
+			expected string
+		}{
+			{0, 4, "some"},
+			{5, 6, "sample"},
+			{-4, 0, "data"},
+			{5, 0, ""},
+		} {
+			reader, err := persistence.GetRange(s, key, testCase.offset, testCase.length)
+			testutil.ErrorOrFail(t, err)
+			b, err := io.ReadAll(reader)
+			testutil.ErrorOrFail(t, err)
+			if string(b) != testCase.expected {
+				t.Errorf("Unexpected range %q for (%d, %d) in %s; expected %q",
+					b, testCase.offset, testCase.length, s, testCase.expected)
+			}
+		}
+		if _, err := persistence.GetRange(s, key, 10, 100); err == nil {
+			t.Errorf("Expected error for out of bounds range in %s", s)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"time"
 )
 
@@ -36,7 +37,19 @@ func (b *InMemoryPersistedStorage) Put(k Key, r io.Reader, _ time.Time) error {
 func (b *InMemoryPersistedStorage) Get(k Key) (io.ReadCloser, error) {
 	content, ok := b.keyIDToValue[k.id()]
 	if !ok {
-		return nil, fmt.Errorf("no such key %v", k)
+		return nil, fmt.Errorf("no such key %v: %w", k, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (b *InMemoryPersistedStorage) GetRange(k Key, offset int64, length int64) (io.ReadCloser, error) {
+	content, ok := b.keyIDToValue[k.id()]
+	if !ok {
+		return nil, fmt.Errorf("no such key %v: %w", k, fs.ErrNotExist)
+	}
+	content, err := SliceRange(content, offset, length)
+	if err != nil {
+		return nil, err
 	}
 	return io.NopCloser(bytes.NewReader(content)), nil
 }
@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/jamespfennell/hoard/config"
@@ -99,6 +100,66 @@ func (s ObjectPersistedStorage) Get(k Key) (io.ReadCloser, error) {
 	return result, err
 }
 
+// GetRange reads the range using an HTTP range request, so that only the bytes in the range
+// are downloaded. An empty range cannot be expressed as a range request, so for an empty
+// range only the size of the object is checked.
+func (s ObjectPersistedStorage) GetRange(k Key, offset int64, length int64) (io.ReadCloser, error) {
+	if offset >= 0 && length == 0 {
+		return s.getEmptyRange(k, offset)
+	}
+	opts := minio.GetObjectOptions{}
+	var err error
+	if offset < 0 {
+		err = opts.SetRange(0, offset)
+	} else {
+		err = opts.SetRange(offset, offset+length-1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithDeadline(s.ctx, time.Now().UTC().Add(100*time.Second))
+	object, err := s.client.GetObject(
+		ctx,
+		s.config.BucketName,
+		path.Join(s.config.Prefix, s.feed.ID, k.id()),
+		opts,
+	)
+	if err != nil {
+		cancel()
+		monitoring.RecordRemoteStorageDownload(s.config, s.feed, err, 0)
+		return nil, err
+	}
+	// The object is read eagerly so that errors, like the key not existing, are returned here.
+	b, err := io.ReadAll(object)
+	_ = object.Close()
+	cancel()
+	monitoring.RecordRemoteStorageDownload(s.config, s.feed, err, len(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// getEmptyRange returns an empty reader if the object exists and the offset is within it, in
+// the same way as SliceRange.
+func (s ObjectPersistedStorage) getEmptyRange(k Key, offset int64) (io.ReadCloser, error) {
+	ctx, cancel := context.WithDeadline(s.ctx, time.Now().UTC().Add(10*time.Second))
+	defer cancel()
+	info, err := s.client.StatObject(
+		ctx,
+		s.config.BucketName,
+		path.Join(s.config.Prefix, s.feed.ID, k.id()),
+		minio.StatObjectOptions{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if offset > info.Size {
+		return nil, fmt.Errorf("range [%d, %d) is out of bounds for %d bytes", offset, offset, info.Size)
+	}
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
 func (s ObjectPersistedStorage) Delete(k Key) error {
 	ctx, cancel := context.WithDeadline(s.ctx, time.Now().UTC().Add(10*time.Second))
 	defer cancel()
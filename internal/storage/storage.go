@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/hoard/internal/storage/hour"
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
 	"io"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
@@ -16,9 +18,11 @@ const hashRegex = `(?P<hash>[a-z0-9]{12})`
 const iso8601RegexHour = `(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})T(?P<hour>[0-9]{2})`
 const iso8601RegexFull = iso8601RegexHour + `(?P<minute>\d{2})(?P<second>\d{2})\.(?P<millisecond>\d{3})`
 const optionalCompressionLevel = `(?P<level>_\d+)?`
+const partialMarker = `_partial`
+const dictionaryMarker = `_d`
 const aFileExtension = `(?P<format>` + config.ExtensionRegex + `)`
 const dFileStringRegex = `^(?P<prefix>.*?)` + iso8601RegexFull + `Z_` + hashRegex + `(?P<postfix>.*)$`
-const aFileStringRegex = `^(?P<prefix>.*?)` + iso8601RegexHour + `Z_` + hashRegex + optionalCompressionLevel + `.tar.` + aFileExtension
+const aFileStringRegex = `^(?P<prefix>.*?)` + iso8601RegexHour + `(?P<minute>\d{2})?Z_` + hashRegex + optionalCompressionLevel + `(?P<dictionary>` + dictionaryMarker + `[a-z0-9]{12})?(?P<partial>` + partialMarker + `)?.tar.` + aFileExtension
 
 var dFileStringMatcher = regexp.MustCompile(dFileStringRegex)
 var aFileStringMatcher = regexp.MustCompile(aFileStringRegex)
@@ -92,26 +96,41 @@ func NewAFileFromString(s string) (AFile, bool) {
 	}
 	var spec config.Compression
 	var legacyFileName bool
-	if match[7] == "" {
+	if match[8] == "" {
+		// Partial AFiles and dictionaries were introduced after compression levels were added
+		// to file names.
+		if match[9] != "" || match[10] != "" {
+			return AFile{}, false
+		}
 		legacyFileName = true
 		spec = config.NewSpecWithLevel(config.Gzip, 6)
 	} else {
-		format, ok := config.NewFormatFromExtension(match[8])
+		format, ok := config.NewFormatFromExtension(match[11])
 		if !ok {
 			return AFile{}, false
 		}
-		spec = config.NewSpecWithLevel(format, atoi(match[7][1:]))
+		if match[9] != "" {
+			if format != config.Zstd {
+				return AFile{}, false
+			}
+			format = config.ZstdDict
+		}
+		spec = config.NewSpecWithLevel(format, atoi(match[8][1:]))
+	}
+	hr := hour.Date(atoi(match[2]), time.Month(atoi(match[3])), atoi(match[4]), atoi(match[5]))
+	if match[6] != "" {
+		// Archives of sub-hour buckets include the minute the bucket starts at.
+		hr = hour.SubHourDate(atoi(match[2]), time.Month(atoi(match[3])), atoi(match[4]), atoi(match[5]), atoi(match[6]))
 	}
 	a := AFile{
-		Prefix: match[1],
-		Hour: hour.Date(
-			atoi(match[2]),
-			time.Month(atoi(match[3])),
-			atoi(match[4]),
-			atoi(match[5]),
-		),
-		Hash:        Hash(match[6]),
+		Prefix:      match[1],
+		Hour:        hr,
+		Hash:        Hash(match[7]),
 		Compression: spec,
+		Partial:     match[10] != "",
+	}
+	if match[9] != "" {
+		a.Dictionary = Hash(match[9][len(dictionaryMarker):])
 	}
 	// We validate the conversion by recomputing the key and ensuring it is the same.
 	// This covers errors like the month value being out of range and the hour implied
@@ -161,6 +180,14 @@ type AFile struct {
 	Hour        hour.Hour
 	Hash        Hash
 	Compression config.Compression
+	// Partial is true if the AFile contains data for an hour that was still in progress
+	// when the AFile was created. The data in a partial AFile is also contained in the
+	// final AFile of the hour, once that has been created.
+	Partial bool
+	// Dictionary is the hash of the version of the feed's compression dictionary that is
+	// needed to decompress the AFile. It is only used if the compression format uses
+	// dictionaries.
+	Dictionary Hash
 }
 
 // String returns a string representation of the AFile. In Hoard, this string
@@ -173,6 +200,13 @@ func (a AFile) String() string {
 	b.WriteString(string(a.Hash))
 	b.WriteString("_")
 	_, _ = fmt.Fprintf(&b, "%d", a.Compression.LevelActual())
+	if a.Compression.UsesDictionary() {
+		b.WriteString(dictionaryMarker)
+		b.WriteString(string(a.Dictionary))
+	}
+	if a.Partial {
+		b.WriteString(partialMarker)
+	}
 	b.WriteString(".tar.")
 	b.WriteString(a.Compression.Format.Extension())
 	return b.String()
@@ -194,7 +228,9 @@ func (a AFile) Equals(other AFile) bool {
 	return a.Prefix == other.Prefix &&
 		a.Hour == other.Hour &&
 		a.Hash == other.Hash &&
-		a.Compression.Equals(other.Compression)
+		a.Compression.Equals(other.Compression) &&
+		a.Partial == other.Partial &&
+		a.Dictionary == other.Dictionary
 }
 
 type SearchResult struct {
@@ -222,6 +258,68 @@ type ReadableAStore interface {
 	Search(startOpt *hour.Hour, end hour.Hour) ([]SearchResult, error)
 }
 
+// Dictionaries provides the versioned compression dictionaries of a feed.
+type Dictionaries interface {
+	// Latest returns the hash of the most recent version of the dictionary and its content.
+	// The hash is empty if there is no dictionary yet.
+	Latest() (Hash, []byte, error)
+
+	// Get returns the content of the version of the dictionary with the hash.
+	Get(hash Hash) ([]byte, error)
+}
+
+// DictionaryProvider is implemented by AStores that can provide the compression dictionaries
+// of the AFiles they contain.
+type DictionaryProvider interface {
+	Dictionaries() Dictionaries
+}
+
+// DictionariesOf returns the compression dictionaries of the AStore, or nil if it does not
+// provide dictionaries.
+func DictionariesOf(aStore interface{}) Dictionaries {
+	provider, ok := aStore.(DictionaryProvider)
+	if !ok {
+		return nil
+	}
+	return provider.Dictionaries()
+}
+
+// RangeReadableAStore is implemented by AStores that can read part of an AFile without reading
+// all of it.
+type RangeReadableAStore interface {
+	// GetRange returns length bytes of the AFile starting at the offset. If the offset is
+	// negative, the last -offset bytes of the AFile are returned and the length is ignored.
+	GetRange(aFile AFile, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// ReadAFileRange reads part of the AFile, with the same semantics as RangeReadableAStore. If
+// the AStore is not a RangeReadableAStore, the whole AFile is read and the range extracted.
+func ReadAFileRange(aStore ReadableAStore, aFile AFile, offset int64, length int64) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	if rangeAStore, ok := aStore.(RangeReadableAStore); ok {
+		reader, err = rangeAStore.GetRange(aFile, offset, length)
+	} else {
+		reader, err = aStore.Get(aFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := aStore.(RangeReadableAStore); ok {
+		return b, nil
+	}
+	b, err = persistence.SliceRange(b, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AFile %s: %w", aFile, err)
+	}
+	return b, nil
+}
+
 type AStore interface {
 	WritableAStore
 
@@ -237,15 +335,21 @@ func ListAFilesInHour(aStore AStore, hour hour.Hour) ([]AFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(searchResults) == 0 {
-		return nil, nil
-	}
-	if len(searchResults) > 1 {
-		return nil, fmt.Errorf("unexpected multiple search resutls for single hour: %v", searchResults)
-	}
-	aFiles := make([]AFile, 0, len(searchResults[0].AFiles))
-	for aFile := range searchResults[0].AFiles {
-		aFiles = append(aFiles, aFile)
+	// A whole hour and a sub-hour bucket starting at the same time can both be returned by
+	// the search, so the results are filtered.
+	var aFiles []AFile
+	found := false
+	for _, searchResult := range searchResults {
+		if searchResult.Hour != hour {
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("unexpected multiple search resutls for single hour: %v", searchResults)
+		}
+		found = true
+		for aFile := range searchResult.AFiles {
+			aFiles = append(aFiles, aFile)
+		}
 	}
 	return aFiles, nil
 }
@@ -260,6 +364,71 @@ type WritableDStore interface {
 	Store(dFile DFile, content io.Reader) error
 }
 
+// DFileMetadata contains information about the HTTP response from which a DFile was
+// downloaded.
+type DFileMetadata struct {
+	StatusCode int `json:",omitempty"`
+	// Headers contains selected headers of the response.
+	Headers map[string]string `json:",omitempty"`
+	// LatencyMilliseconds is the time between sending the request and finishing reading
+	// the response.
+	LatencyMilliseconds int64 `json:",omitempty"`
+	// ServerIP is the IP address of the server that sent the response.
+	ServerIP string `json:",omitempty"`
+	// URL is the URL the file was downloaded from, without the query string or user
+	// information as these may contain credentials.
+	URL string `json:",omitempty"`
+}
+
+// MetadataFileSuffix is the suffix added to a DFile's name to obtain the name of the file
+// in which the DFile's metadata is stored.
+const MetadataFileSuffix = ".hoard_metadata.json"
+
+// IsMetadataFileName returns true if the name is the name of a DFile metadata file.
+func IsMetadataFileName(name string) bool {
+	return strings.HasSuffix(name, MetadataFileSuffix)
+}
+
+// WritableMetadataDStore is implemented by DStores that can store metadata alongside DFiles.
+type WritableMetadataDStore interface {
+	StoreMetadata(dFile DFile, metadata DFileMetadata) error
+}
+
+// ReadableMetadataDStore is implemented by DStores that can retrieve the metadata stored
+// alongside DFiles.
+type ReadableMetadataDStore interface {
+	// GetMetadata returns the metadata of the DFile, or nil if the DFile has no metadata.
+	GetMetadata(dFile DFile) (*DFileMetadata, error)
+}
+
+// FileWritableDStore is implemented by DStores that can store a DFile by taking ownership
+// of a file on local disk, for example by renaming it. This avoids copying the data.
+type FileWritableDStore interface {
+	// StoreFile stores the file at the path as the DFile. After the method returns, whether
+	// successfully or not, the file at the path may no longer exist.
+	StoreFile(dFile DFile, path string) error
+}
+
+// StoreFile stores the file on local disk at the path in the DStore, and then removes the
+// file. If the DStore is a FileWritableDStore the file is moved rather than copied.
+func StoreFile(dStore WritableDStore, dFile DFile, path string) error {
+	if fileDStore, ok := dStore.(FileWritableDStore); ok {
+		return fileDStore.StoreFile(dFile, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dStore.Store(dFile, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
 type DStore interface {
 	ReadableDStore
 	WritableDStore
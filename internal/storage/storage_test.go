@@ -85,6 +85,33 @@ func TestAFile_StringRoundTrip(t *testing.T) {
 			Hash:        storage.ExampleHash(),
 			Compression: config.NewSpecWithLevel(config.Gzip, 2),
 		},
+		{
+			Prefix:      "a",
+			Hour:        hour.SubHourDate(2020, 1, 2, 3, 15),
+			Hash:        storage.ExampleHash(),
+			Compression: config.NewSpecWithLevel(config.Gzip, 6),
+		},
+		{
+			Prefix:      "a",
+			Hour:        hour.Date(2020, 1, 2, 3),
+			Hash:        storage.ExampleHash(),
+			Compression: config.NewSpecWithLevel(config.Gzip, 6),
+			Partial:     true,
+		},
+		{
+			Prefix:      "a_",
+			Hour:        hour.SubHourDate(2020, 1, 2, 3, 0),
+			Hash:        storage.ExampleHash(),
+			Compression: config.NewSpecWithLevel(config.Xz, 6),
+		},
+		{
+			Prefix:      "a",
+			Hour:        hour.Date(2020, 1, 2, 3),
+			Hash:        storage.ExampleHash(),
+			Compression: config.NewSpecWithLevel(config.ZstdDict, 3),
+			Dictionary:  storage.ExampleHash2(),
+			Partial:     true,
+		},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			d2, ok := storage.NewAFileFromString(d.String())
@@ -134,3 +161,30 @@ func TestPersistencePrefixToHour(t *testing.T) {
 		t.Error("Actual != expected; ", actual, expected)
 	}
 }
+
+func TestPersistencePrefixToHour_SubHour(t *testing.T) {
+	p := persistence.Prefix{"2021", "02", "06", "22", "30"}
+	expected := hour.SubHourDate(2021, 2, 6, 22, 30)
+
+	actual, ok := hour.NewHourFromPersistencePrefix(p)
+
+	if !ok {
+		t.Fatal("Unexpected failure to convert prefix to hour", p)
+	}
+	if actual != expected {
+		t.Error("Actual != expected; ", actual, expected)
+	}
+	if actual.PersistencePrefix().ID() != p.ID() {
+		t.Error("Unexpected persistence prefix; ", actual.PersistencePrefix(), p)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	h := storage.NewHasher()
+	_, _ = h.Write([]byte("hello "))
+	_, _ = h.Write([]byte("world"))
+
+	if h.Hash() != storage.CalculateHash([]byte("hello world")) {
+		t.Errorf("Incremental hash %s not equal to hash %s", h.Hash(), storage.CalculateHash([]byte("hello world")))
+	}
+}
@@ -5,6 +5,18 @@
 //   - Hours for which there a multiple archive files. These need to be merged.
 //   - Data stored in one remote replica but not another. This data needs to be copied
 //     to all replicas.
+//   - Hours that ended some time ago but for which there are only partial archive files,
+//     for example because the collector stopped before packing the hour. These archive files
+//     need to be marked as final.
+//
+// It also reports hours within the feed's schedule for which there is no data. These gaps
+// cannot be fixed. Hours outside of the schedule windows are expected to be empty and are
+// not reported, nor are hours that may still be being uploaded or that are before the
+// feed's first data.
+//
+// Finally, it reports archive files compressed using a version of the feed's compression
+// dictionary that no longer exists. These archive files cannot be decompressed, and this
+// cannot be fixed either.
 //
 // The task optionally fixes the problems it encounters.
 package audit
@@ -60,13 +72,41 @@ func RunOnce(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, enforce
 		return fmt.Errorf("cannot audit because no remote object storage is configured")
 	}
 	feed := session.Feed()
-	problems, err := findProblems(session, startOpt, end, enforceMerging, enforceCompression)
+	searchResults, err := session.RemoteAStore().Search(startOpt, end)
+	if err != nil {
+		return fmt.Errorf("failed to list hours for audit: %w", err)
+	}
+	if startOpt != nil && !feed.IsErrorsFeed() {
+		missingHours := findMissingHours(session, searchResults, end)
+		monitoring.RecordAuditMissingHours(feed, len(missingHours))
+		if len(missingHours) > 0 {
+			session.Log().Warn(fmt.Sprintf("No data for %d hour(s) within the feed's schedule:%s",
+				len(missingHours), prettyPrintHours(missingHours, 6)))
+		}
+	}
+	// A failure to check the dictionaries does not stop the audit, because the other problems
+	// can still be found and fixed.
+	missingDictionaries, dictionariesErr := findMissingDictionaries(session, searchResults)
+	if dictionariesErr != nil {
+		session.Log().Error(fmt.Sprintf("Failed to check the compression dictionaries: %s", dictionariesErr))
+	} else {
+		monitoring.RecordAuditMissingDictionaries(feed, len(missingDictionaries))
+	}
+	if len(missingDictionaries) > 0 {
+		var hours []hour.Hour
+		for _, aFile := range missingDictionaries {
+			hours = append(hours, aFile.Hour)
+		}
+		session.Log().Error(fmt.Sprintf("The compression dictionary of %d archive(s) is missing; the archives cannot be read:%s",
+			len(missingDictionaries), prettyPrintHours(hours, 6)))
+	}
+	problems, err := findProblems(session, searchResults, startOpt, end, enforceMerging, enforceCompression)
 	if err != nil {
 		return err
 	}
 	if len(problems) == 0 {
 		session.Log().Info("No problems found during audit")
-		return nil
+		return dictionariesErr
 	}
 	var b strings.Builder
 	_, _ = fmt.Fprintf(&b, "\nFound %d problem(s) for feed %s\n", len(problems), session.Feed().ID)
@@ -79,7 +119,7 @@ func RunOnce(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, enforce
 	}
 	fmt.Println(b.String())
 	if !fix {
-		return fmt.Errorf("%s: found %d problem(s)\n", feed.ID, len(problems))
+		return util.NewMultipleError(fmt.Errorf("%s: found %d problem(s)\n", feed.ID, len(problems)), dictionariesErr)
 	}
 	session.Log().Info(fmt.Sprintf("Fixing %d problem(s) found during audit", len(problems)))
 	var errs []error
@@ -92,15 +132,13 @@ func RunOnce(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, enforce
 		}
 		session.Log().Info(fmt.Sprintf("Fixed %d/%d problems\n", i+1-len(errs), len(problems)))
 	}
-	return util.NewMultipleError(errs...)
+	return util.NewMultipleError(append(errs, dictionariesErr)...)
 }
 
-func findProblems(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, enforceMerging, enforceCompression bool) ([]problem, error) {
+// findProblems returns the problems in remote storage that can be fixed. The search results
+// are the results of searching the remote AStore between the start and end hours.
+func findProblems(session *tasks.Session, searchResults []storage.SearchResult, startOpt *hour.Hour, end hour.Hour, enforceMerging, enforceCompression bool) ([]problem, error) {
 	remoteAStore := session.RemoteAStore()
-	searchResults, err := remoteAStore.Search(startOpt, end)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list hours for audit: %w", err)
-	}
 	var problems []problem
 
 	// First we look for unmerged hour problems.
@@ -140,6 +178,20 @@ func findProblems(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, en
 		}
 	}
 
+	// Then partial data problems. Hours that have only recently ended are skipped because
+	// their final archive may not have been uploaded yet.
+	for _, searchResult := range searchResults {
+		if len(searchResult.AFiles) != 1 || !isSettled(searchResult.Hour, end) {
+			continue
+		}
+		for aFile := range searchResult.AFiles {
+			if aFile.Partial {
+				problems = append(problems,
+					partialData{problemBase{session, searchResult.Hour}, aFile})
+			}
+		}
+	}
+
 	// Then incorrect compression problems
 	if enforceCompression {
 		for _, searchResult := range searchResults {
@@ -159,6 +211,81 @@ func findProblems(session *tasks.Session, startOpt *hour.Hour, end hour.Hour, en
 	return problems, nil
 }
 
+// isSettled returns true if all of the archives for the hour should have been uploaded by the
+// end hour of an audit. Hours that have only recently ended are not settled because they may
+// still be being packed and uploaded.
+func isSettled(hr hour.Hour, end hour.Hour) bool {
+	return hr.ContainingHour().Before(end.Add(-1))
+}
+
+// findMissingHours returns the settled hours before the end hour for which there is no data
+// in remote storage, but that are within the feed's schedule. The search results are the
+// results of searching the remote AStore up to the end hour.
+//
+// Hours before the first hour with data are not returned, as the feed may not have been
+// collected yet. In particular, no hours are returned if there is no data at all.
+func findMissingHours(session *tasks.Session, searchResults []storage.SearchResult, end hour.Hour) []hour.Hour {
+	hoursWithData := map[hour.Hour]bool{}
+	var first *hour.Hour
+	for _, searchResult := range searchResults {
+		hr := searchResult.Hour.ContainingHour()
+		hoursWithData[hr] = true
+		if first == nil || hr.Before(*first) {
+			first = &hr
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	var missingHours []hour.Hour
+	for hr := *first; hr.Before(end) && isSettled(hr, end); hr = hr.Add(1) {
+		if hoursWithData[hr] {
+			continue
+		}
+		if !session.Feed().IsScheduledBetween(hr.Time(), hr.Add(1).Time()) {
+			continue
+		}
+		missingHours = append(missingHours, hr)
+	}
+	return missingHours
+}
+
+// findMissingDictionaries returns the AFiles in remote storage that were compressed using a
+// version of the feed's compression dictionary that does not exist. The search results are
+// the results of searching the remote AStore.
+func findMissingDictionaries(session *tasks.Session, searchResults []storage.SearchResult) ([]storage.AFile, error) {
+	versionToAFiles := map[storage.Hash][]storage.AFile{}
+	for _, searchResult := range searchResults {
+		for aFile := range searchResult.AFiles {
+			if aFile.Compression.UsesDictionary() {
+				versionToAFiles[aFile.Dictionary] = append(versionToAFiles[aFile.Dictionary], aFile)
+			}
+		}
+	}
+	if len(versionToAFiles) == 0 {
+		return nil, nil
+	}
+	dictionaries := session.Dictionaries()
+	if dictionaries == nil {
+		return nil, fmt.Errorf("cannot check compression dictionaries because the dictionary storage is not available")
+	}
+	versions, err := dictionaries.Versions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check compression dictionaries: %w", err)
+	}
+	versionExists := map[storage.Hash]bool{}
+	for _, version := range versions {
+		versionExists[version.Hash] = true
+	}
+	var missing []storage.AFile
+	for version, aFiles := range versionToAFiles {
+		if !versionExists[version] {
+			missing = append(missing, aFiles...)
+		}
+	}
+	return missing, nil
+}
+
 type problem interface {
 	Fix() error
 	Feed() *config.Feed
@@ -232,6 +359,33 @@ func (p incorrectCompression) String() string {
 	return "incorrect compression"
 }
 
+// An hour for which the only archive is partial
+type partialData struct {
+	problemBase
+	aFile storage.AFile
+}
+
+func (p partialData) Fix() error {
+	final := p.aFile
+	final.Partial = false
+	reader, err := p.session.RemoteAStore().Get(p.aFile)
+	if err != nil {
+		return err
+	}
+	if err := p.session.RemoteAStore().Store(final, reader); err != nil {
+		_ = reader.Close()
+		return err
+	}
+	if err := reader.Close(); err != nil {
+		return err
+	}
+	return p.session.RemoteAStore().Delete(p.aFile)
+}
+
+func (p partialData) String() string {
+	return "only partial data"
+}
+
 func prettyPrintHours(hours []hour.Hour, numPerLine int) string {
 	var b strings.Builder
 	var cells []string
@@ -2,7 +2,9 @@ package audit
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/hoard/internal/storage"
@@ -31,6 +33,12 @@ var aFileWithXz = storage.AFile{
 	Compression: config.NewSpecWithLevel(config.Xz, 9),
 }
 
+func search(t *testing.T, session *tasks.Session, startOpt *hour.Hour, end hour.Hour) []storage.SearchResult {
+	searchResults, err := session.RemoteAStore().Search(startOpt, end)
+	testutil.ErrorOrFail(t, err)
+	return searchResults
+}
+
 func TestFindProblems_UnMergedHour(t *testing.T) {
 	session := tasks.NewInMemorySession(&feed)
 	aStore1 := session.RemoteAStore().Replicas()[0]
@@ -38,7 +46,7 @@ func TestFindProblems_UnMergedHour(t *testing.T) {
 	aStore2 := session.RemoteAStore().Replicas()[1]
 	testutil.ErrorOrFail(t, aStore2.Store(aFile2, bytes.NewReader(nil)))
 
-	problems, err := findProblems(session, &hr, hr, true, false)
+	problems, err := findProblems(session, search(t, session, &hr, hr), &hr, hr, true, false)
 	if err != nil {
 		t.Errorf("unexpected error in findProblems: %s", err)
 	}
@@ -62,7 +70,7 @@ func TestFindProblems_UnMergedHour_OutsideRange(t *testing.T) {
 	aStore2 := session.RemoteAStore().Replicas()[1]
 	testutil.ErrorOrFail(t, aStore2.Store(aFile2, bytes.NewReader(nil)))
 
-	problems, err := findProblems(session, &hr2, hr2, true, false)
+	problems, err := findProblems(session, search(t, session, &hr2, hr2), &hr2, hr2, true, false)
 	if err != nil {
 		t.Errorf("unexpected error in findProblems: %s", err)
 	}
@@ -76,7 +84,7 @@ func TestFindProblems_MissingData(t *testing.T) {
 	aStore1 := session.RemoteAStore().Replicas()[0]
 	testutil.ErrorOrFail(t, aStore1.Store(aFile1, bytes.NewReader(nil)))
 
-	problems, err := findProblems(session, &hr, hr, true, false)
+	problems, err := findProblems(session, search(t, session, &hr, hr), &hr, hr, true, false)
 	if err != nil {
 		t.Errorf("unexpected error in findProblems: %s", err)
 	}
@@ -98,7 +106,7 @@ func TestFindProblems_MissingData_OutsideRange(t *testing.T) {
 	aStore1 := session.RemoteAStore().Replicas()[0]
 	testutil.ErrorOrFail(t, aStore1.Store(aFile1, bytes.NewReader(nil)))
 
-	problems, err := findProblems(session, &hr2, hr2, true, false)
+	problems, err := findProblems(session, search(t, session, &hr2, hr2), &hr2, hr2, true, false)
 	if err != nil {
 		t.Errorf("unexpected error in findProblems: %s", err)
 	}
@@ -111,7 +119,7 @@ func TestFindProblems_IncorrectCompression(t *testing.T) {
 	session := tasks.NewInMemorySession(&feed)
 	testutil.ErrorOrFail(t, session.RemoteAStore().Store(aFileWithXz, bytes.NewReader(nil)))
 
-	problems, err := findProblems(session, &hr, hr, true, true)
+	problems, err := findProblems(session, search(t, session, &hr, hr), &hr, hr, true, true)
 	if err != nil {
 		t.Errorf("unexpected error in findProblems: %s", err)
 	}
@@ -127,3 +135,91 @@ func TestFindProblems_IncorrectCompression(t *testing.T) {
 		t.Fatalf("unexpected hour %s != %s", missingDataForHours.hour, hr)
 	}
 }
+
+func TestFindProblems_PartialData(t *testing.T) {
+	session := tasks.NewInMemorySession(&feed)
+	partialAFile := aFile1
+	partialAFile.Partial = true
+	testutil.ErrorOrFail(t, session.RemoteAStore().Store(partialAFile, bytes.NewReader(nil)))
+
+	// The hour after the partial data may still be uploading the final archive.
+	problems, err := findProblems(session, search(t, session, &hr, hr.Add(1)), &hr, hr.Add(1), true, false)
+	testutil.ErrorOrFail(t, err)
+	if len(problems) != 0 {
+		t.Fatalf("unexpected number %d of problems; expected 0", len(problems))
+	}
+
+	problems, err = findProblems(session, search(t, session, &hr, hr.Add(2)), &hr, hr.Add(2), true, false)
+	testutil.ErrorOrFail(t, err)
+	if len(problems) != 1 {
+		t.Fatalf("unexpected number %d of problems; expected 1", len(problems))
+	}
+	problem, ok := problems[0].(partialData)
+	if !ok {
+		t.Fatalf("expected partialData problem; got %v", problems[0])
+	}
+	testutil.ErrorOrFail(t, problem.Fix())
+
+	aFiles, err := storage.ListAFilesInHour(session.RemoteAStore(), hr)
+	testutil.ErrorOrFail(t, err)
+	if len(aFiles) != 1 || aFiles[0].Partial || aFiles[0].Hash != aFile1.Hash {
+		t.Errorf("unexpected AFiles %v after fixing partial data", aFiles)
+	}
+}
+
+func TestFindMissingHours(t *testing.T) {
+	// The feed is scheduled from 00:00 to 06:00 UTC and there is data for 01:00 and 03:00.
+	// The hour 00:00 is before the first data, and the hour 05:00 may still be uploading, so
+	// only 02:00 and 04:00 are missing.
+	scheduledFeed := config.Feed{
+		Schedule: config.Schedule{Windows: []config.ScheduleWindow{{Start: "00:00", End: "06:00"}}},
+	}
+	session := tasks.NewInMemorySession(&scheduledFeed)
+	aStore := session.RemoteAStore().Replicas()[0]
+	for _, h := range []int{1, 3} {
+		aFile := aFile1
+		aFile.Hour = hour.Date(2020, 2, 2, h)
+		testutil.ErrorOrFail(t, aStore.Store(aFile, bytes.NewReader(nil)))
+	}
+	start := hour.Date(2020, 2, 2, 0)
+	end := hour.Date(2020, 2, 2, 6)
+
+	missingHours := findMissingHours(session, search(t, session, &start, end), end)
+
+	expected := []hour.Hour{hour.Date(2020, 2, 2, 2), hour.Date(2020, 2, 2, 4)}
+	if !reflect.DeepEqual(missingHours, expected) {
+		t.Errorf("Unexpected missing hours %v; expected %v", missingHours, expected)
+	}
+}
+
+func TestFindMissingHours_NoData(t *testing.T) {
+	session := tasks.NewInMemorySession(&feed)
+	start := hour.Date(2020, 2, 2, 0)
+	end := hour.Date(2020, 2, 3, 0)
+
+	missingHours := findMissingHours(session, search(t, session, &start, end), end)
+
+	if len(missingHours) != 0 {
+		t.Errorf("Unexpected missing hours %v; expected none", missingHours)
+	}
+}
+
+func TestFindMissingDictionaries(t *testing.T) {
+	session := tasks.NewInMemorySession(&feed)
+	version, err := session.Dictionaries().Add([]byte("dictionary"), time.Now())
+	testutil.ErrorOrFail(t, err)
+	withDictionary := aFile1
+	withDictionary.Compression = config.NewSpecWithLevel(config.ZstdDict, 3)
+	withDictionary.Dictionary = version
+	withMissingDictionary := withDictionary
+	withMissingDictionary.Hour = hr2
+	withMissingDictionary.Dictionary = storage.CalculateHash([]byte("other dictionary"))
+	testutil.ErrorOrFail(t, session.RemoteAStore().Store(withDictionary, bytes.NewReader(nil)))
+	testutil.ErrorOrFail(t, session.RemoteAStore().Store(withMissingDictionary, bytes.NewReader(nil)))
+
+	missing, err := findMissingDictionaries(session, search(t, session, &hr, hr2))
+	testutil.ErrorOrFail(t, err)
+	if len(missing) != 1 || !missing[0].Equals(withMissingDictionary) {
+		t.Errorf("Unexpected AFiles with missing dictionaries %v; expected [%s]", missing, withMissingDictionary)
+	}
+}
@@ -0,0 +1,123 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+// maxRefreshMargin is the maximum amount of time before a token expires that the token
+// is refreshed. For tokens with short lifetimes, the margin is a fraction of the lifetime.
+const maxRefreshMargin = time.Minute
+
+// refreshMarginFraction is the fraction of a token's lifetime before it expires that the
+// token is refreshed.
+const refreshMarginFraction = 10
+
+// tokenSource obtains access tokens using the OAuth2 client credentials flow and caches
+// them until shortly before they expire.
+//
+// Token values are never included in errors returned by the token source, so that they
+// do not end up in logs.
+type tokenSource struct {
+	auth   *config.Auth
+	client httpClient
+	now    timeGetter
+
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+func newTokenSource(auth *config.Auth, client httpClient, now timeGetter) *tokenSource {
+	return &tokenSource{
+		auth:   auth,
+		client: client,
+		now:    now,
+	}
+}
+
+// Token returns a valid access token, fetching a new one if necessary. The request for a
+// new token is cancelled if the context is cancelled.
+func (s *tokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.now().Before(s.refresh) {
+		return s.token, nil
+	}
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	if expiresIn <= 0 {
+		// The server did not say when the token expires. We use it until it is rejected.
+		s.refresh = s.now().Add(100 * 365 * 24 * time.Hour)
+	} else {
+		s.refresh = s.now().Add(expiresIn - min(expiresIn/refreshMarginFraction, maxRefreshMargin))
+	}
+	return s.token, nil
+}
+
+// Invalidate discards the cached token so that the next call to Token fetches a new one.
+func (s *tokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *tokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.auth.Scopes, " "))
+	}
+	for key, value := range s.auth.Params {
+		form.Set(key, value)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.auth.ClientID), url.QueryEscape(s.auth.ClientSecret))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to reach the token URL: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The response body is deliberately not included as it may contain credentials.
+		return "", 0, fmt.Errorf("non-200 status recieved from the token URL: %d / %s", resp.StatusCode, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read the token response: %w", err)
+	}
+	var tr tokenResponse
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return "", 0, fmt.Errorf("failed to parse the token response as JSON")
+	}
+	if tr.AccessToken == "" {
+		return "", 0, fmt.Errorf("the token response does not contain an access token")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", tr.TokenType)
+	}
+	return tr.AccessToken, time.Duration(tr.ExpiresIn) * time.Second, nil
+}
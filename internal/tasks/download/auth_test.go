@@ -0,0 +1,142 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+const clientID = "client"
+const clientSecret = "secret"
+
+// fakeAuthServer is both an OAuth2 token endpoint and a feed endpoint that only accepts
+// the most recently issued token.
+type fakeAuthServer struct {
+	tokensIssued int
+	feedRequests int
+	// revoked is set to simulate the feed server rejecting a token before it expires.
+	revoked bool
+}
+
+func (s *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/token":
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != clientID || secret != clientSecret || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client", "client_secret": "` + secret + `"}`))
+			return
+		}
+		s.tokensIssued++
+		s.revoked = false
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "%s", "token_type": "Bearer", "expires_in": 3600}`, s.currentToken())
+	case "/feed":
+		s.feedRequests++
+		if s.revoked || r.Header.Get("Authorization") != "Bearer "+s.currentToken() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(content1)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *fakeAuthServer) currentToken() string {
+	return fmt.Sprintf("token-%d", s.tokensIssued)
+}
+
+func newFeedWithAuth(serverURL string, secret string) *config.Feed {
+	f := feed
+	f.URL = serverURL + "/feed"
+	f.Auth = &config.Auth{
+		Type:         config.AuthTypeOAuth2ClientCredentials,
+		TokenURL:     serverURL + "/token",
+		ClientID:     clientID,
+		ClientSecret: secret,
+	}
+	return &f
+}
+
+func TestDownloadOnce_Auth(t *testing.T) {
+	authServer := &fakeAuthServer{}
+	server := httptest.NewServer(authServer)
+	defer server.Close()
+	now := time1
+	downloader := newDownloaderWithClient(
+		newFeedWithAuth(server.URL, clientSecret), dstore.NewInMemoryDStore(), server.Client(),
+		func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		_, err := downloader.downloadOnce(context.Background())
+		testutil.ErrorOrFail(t, err)
+	}
+	if authServer.tokensIssued != 1 {
+		t.Errorf("Unexpected number of tokens issued %d; expected the token to be cached", authServer.tokensIssued)
+	}
+
+	// The token should be refreshed before it expires.
+	now = time1.Add(59*time.Minute + 30*time.Second)
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if authServer.tokensIssued != 2 {
+		t.Errorf("Unexpected number of tokens issued %d; expected the token to be refreshed", authServer.tokensIssued)
+	}
+
+	// If the token is rejected, a new token is fetched and the request is retried once.
+	authServer.revoked = true
+	authServer.feedRequests = 0
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if authServer.tokensIssued != 3 {
+		t.Errorf("Unexpected number of tokens issued %d; expected a new token after the 401", authServer.tokensIssued)
+	}
+	if authServer.feedRequests != 2 {
+		t.Errorf("Unexpected number of feed requests %d; expected 2", authServer.feedRequests)
+	}
+}
+
+func TestDownloadOnce_AuthFailureDoesNotLeakSecrets(t *testing.T) {
+	server := httptest.NewServer(&fakeAuthServer{})
+	defer server.Close()
+	wrongSecret := "wrong-secret-value"
+	downloader := newDownloaderWithClient(
+		newFeedWithAuth(server.URL, wrongSecret), dstore.NewInMemoryDStore(), server.Client(), returnTime1)
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Fatalf("Expected error; recieved none")
+	}
+	if strings.Contains(err.Error(), wrongSecret) {
+		t.Errorf("Error message contains the client secret: %s", err)
+	}
+}
+
+func TestDownloadOnce_AuthContextCancelled(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+	downloader := newDownloaderWithClient(
+		newFeedWithAuth(server.URL, clientSecret), dstore.NewInMemoryDStore(), server.Client(), returnTime1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := downloader.downloadOnce(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected error; recieved none")
+	}
+}
@@ -0,0 +1,54 @@
+package download
+
+import (
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+// circuitBreaker stops downloads of a feed that is consistently failing, and instead
+// periodically probes the feed to see if it has recovered.
+type circuitBreaker struct {
+	threshold           int
+	probePeriodicity    time.Duration
+	consecutiveFailures int
+	open                bool
+	lastAttempt         time.Time
+}
+
+func newCircuitBreaker(feed *config.Feed) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:        feed.CircuitBreaker.FailureThreshold,
+		probePeriodicity: feed.CircuitBreaker.ProbePeriodicityActual(),
+	}
+}
+
+// Allow returns true if a download should be attempted at the provided time.
+func (b *circuitBreaker) Allow(t time.Time) bool {
+	if !b.open {
+		return true
+	}
+	return t.Sub(b.lastAttempt) >= b.probePeriodicity
+}
+
+// Record records the result of a download attempted at the provided time. It returns
+// true if the circuit breaker opened or closed as a result.
+func (b *circuitBreaker) Record(t time.Time, err error) bool {
+	b.lastAttempt = t
+	wasOpen := b.open
+	if err == nil {
+		b.consecutiveFailures = 0
+		b.open = false
+	} else {
+		b.consecutiveFailures++
+		if b.threshold > 0 && b.consecutiveFailures >= b.threshold {
+			b.open = true
+		}
+	}
+	return wasOpen != b.open
+}
+
+// IsOpen returns true if the circuit breaker is open.
+func (b *circuitBreaker) IsOpen() bool {
+	return b.open
+}
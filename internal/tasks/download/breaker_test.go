@@ -0,0 +1,57 @@
+package download
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+var errForTesting = errors.New("simulated error")
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(&config.Feed{
+		CircuitBreaker: config.CircuitBreaker{
+			FailureThreshold: 2,
+			ProbePeriodicity: time.Minute,
+		},
+	})
+
+	if b.Record(time0, errForTesting) {
+		t.Errorf("Circuit breaker unexpectedly changed state after 1 failure")
+	}
+	if !b.Record(time0.Add(5*time.Second), errForTesting) || !b.IsOpen() {
+		t.Errorf("Circuit breaker did not open after 2 failures")
+	}
+	if b.Allow(time0.Add(30 * time.Second)) {
+		t.Errorf("Open circuit breaker unexpectedly allowed a download before the probe time")
+	}
+	if !b.Allow(time0.Add(65 * time.Second)) {
+		t.Errorf("Open circuit breaker unexpectedly disallowed a probe")
+	}
+	if b.Record(time0.Add(65*time.Second), errForTesting) {
+		t.Errorf("Circuit breaker unexpectedly changed state after a failed probe")
+	}
+	if b.Allow(time0.Add(90 * time.Second)) {
+		t.Errorf("Open circuit breaker unexpectedly allowed a download before the next probe time")
+	}
+	if !b.Record(time0.Add(125*time.Second), nil) || b.IsOpen() {
+		t.Errorf("Circuit breaker did not close after a successful probe")
+	}
+	if !b.Allow(time0.Add(130 * time.Second)) {
+		t.Errorf("Closed circuit breaker unexpectedly disallowed a download")
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	b := newCircuitBreaker(&config.Feed{})
+
+	for i := range 100 {
+		b.Record(time0.Add(time.Duration(i)*time.Second), errForTesting)
+	}
+
+	if b.IsOpen() {
+		t.Errorf("Disabled circuit breaker unexpectedly opened")
+	}
+}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/jamespfennell/hoard/config"
+)
+
+// newHTTPClient builds the HTTP client used to download the feed, based on the feed's
+// HTTP client configuration.
+func newHTTPClient(feed *config.Feed) (*http.Client, error) {
+	c := feed.HTTPClient
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in the CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   c.TimeoutActual(),
+	}
+	if feed.IsStreaming() {
+		// The response body of a streaming feed is read for as long as the connection stays
+		// open, so the timeout only applies to receiving the response headers.
+		client.Timeout = 0
+		transport.ResponseHeaderTimeout = c.TimeoutActual()
+	}
+	if c.MaxRedirects != nil {
+		maxRedirects := *c.MaxRedirects
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+	}
+	return client, nil
+}
+
+// newWebSocketDialer builds the dialer used to open WebSocket connections to the feed. The
+// dialer uses the same TLS and proxy settings as the HTTP client.
+func newWebSocketDialer(feed *config.Feed, client *http.Client) *websocket.Dialer {
+	transport := client.Transport.(*http.Transport)
+	return &websocket.Dialer{
+		Proxy:            transport.Proxy,
+		TLSClientConfig:  transport.TLSClientConfig,
+		HandshakeTimeout: feed.HTTPClient.TimeoutActual(),
+	}
+}
@@ -0,0 +1,103 @@
+package download
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func TestNewHTTPClient_CAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	testutil.ErrorOrFail(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0600))
+
+	for _, testCase := range []struct {
+		name        string
+		httpClient  config.HTTPClient
+		expectError bool
+	}{
+		{"system CAs", config.HTTPClient{}, true},
+		{"custom CA file", config.HTTPClient{CAFile: caFile}, false},
+		{"insecure", config.HTTPClient{InsecureSkipVerify: true}, false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, err := newHTTPClient(&config.Feed{HTTPClient: testCase.httpClient})
+			testutil.ErrorOrFail(t, err)
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+
+			if testCase.expectError && err == nil {
+				t.Errorf("Expected error; recieved none")
+			}
+			if !testCase.expectError && err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_MaxRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, testCase := range []struct {
+		maxRedirects   *int
+		expectedStatus int
+	}{
+		{nil, http.StatusOK},
+		{ptr(0), http.StatusFound},
+		{ptr(1), http.StatusOK},
+	} {
+		client, err := newHTTPClient(&config.Feed{HTTPClient: config.HTTPClient{MaxRedirects: testCase.maxRedirects}})
+		testutil.ErrorOrFail(t, err)
+
+		resp, err := client.Get(server.URL + "/redirect")
+		testutil.ErrorOrFail(t, err)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != testCase.expectedStatus {
+			t.Errorf("Unexpected status %d; expected %d", resp.StatusCode, testCase.expectedStatus)
+		}
+	}
+}
+
+func TestNewHTTPClient_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	client, err := newHTTPClient(&config.Feed{HTTPClient: config.HTTPClient{Timeout: 10 * time.Millisecond}})
+	testutil.ErrorOrFail(t, err)
+
+	resp, err := client.Get(server.URL)
+
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Errorf("Expected timeout error; recieved none")
+	}
+}
+
+func ptr(i int) *int {
+	return &i
+}
@@ -5,49 +5,147 @@ package download
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/feedtemplate"
 	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/ratelimit"
 	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/hour"
 	"github.com/jamespfennell/hoard/internal/tasks"
 	"github.com/jamespfennell/hoard/internal/util"
 )
 
 // RunPeriodically runs the download task periodically, with the period specified
 // in the feed configuration.
+//
+// If dynamic periodicity is enabled for the feed, the period is increased when the feed
+// keeps returning the same data, up to the feed's maximum periodicity.
+//
+// If the feed has a schedule, it is only downloaded within the schedule windows, with a
+// period of at least the periodicity of the current window.
+//
+// Streaming feeds are not downloaded periodically; instead a connection to the feed is
+// kept open and messages are stored as they are received.
 func RunPeriodically(session *tasks.Session) {
 	feed := session.Feed()
+	d, err := newDownloader(session)
+	if err != nil {
+		session.Log().Error(fmt.Sprintf("Failed to initialize the downloader, periodic downloader will not run: %s", err))
+		return
+	}
+	if feed.IsStreaming() {
+		session.Log().Info("Starting stream downloader")
+		d.stream(session.Ctx(), session.Log())
+		session.Log().Info("Stopped stream downloader")
+		return
+	}
 	session.Log().Info("Starting periodic downloader")
-	ticker := util.NewTicker(feed.Periodicity, 0)
+	var ticker util.Ticker
+	if coordinator := session.Replicas(); coordinator != nil {
+		// The downloads of this replica are offset relative to the other replicas.
+		ticker = util.NewAlignedTicker(feed.Periodicity, coordinator.Offset)
+	} else {
+		ticker = util.NewTicker(feed.Periodicity, 0)
+	}
 	defer ticker.Stop()
-	client := &http.Client{}
-	var lastHash storage.Hash
+	periodicity := newDynamicPeriodicity(feed)
+	monitoring.RecordDownloadPeriodicity(feed, feed.Periodicity)
+	breaker := newCircuitBreaker(feed)
+	monitoring.RecordDownloadCircuitBreaker(feed, false)
+	period := feed.Periodicity
+	// The ticker is only reset when the period changes, because resetting an aligned ticker
+	// restarts its timer.
+	tickerPeriod := feed.Periodicity
+	setTickerPeriod := func(p time.Duration) {
+		if p == tickerPeriod {
+			return
+		}
+		tickerPeriod = p
+		ticker.Reset(p)
+		monitoring.RecordDownloadPeriodicity(feed, p)
+	}
+	// Outside of the schedule windows the ticks of the ticker are ignored, and the feed is
+	// next downloaded when the next window starts. A separate timer is used for this so that
+	// the ticker keeps its period and, for aligned tickers, its phase.
+	var windowStart <-chan time.Time
 	for {
 		select {
 		case <-ticker.C:
-			dFile, err := downloadOnce(feed, session.LocalDStore(), lastHash, client, defaultTimeGetter)
-			monitoring.RecordDownload(feed, err)
-			if err != nil {
-				session.Log().Error(fmt.Sprintf("Error downloading file: %s", err))
+			if windowStart != nil {
 				continue
 			}
-			lastHash = dFile.Hash
+		case <-windowStart:
+			windowStart = nil
 		case <-session.Ctx().Done():
 			session.Log().Info("Stopped periodic downloader")
 			return
 		}
+		t := defaultTimeGetter()
+		windowPeriodicity, scheduled := feed.PeriodicityAt(t)
+		monitoring.RecordDownloadScheduled(feed, scheduled)
+		if !scheduled {
+			windowStart = time.After(feed.NextScheduledTime(t).Sub(t))
+			continue
+		}
+		// The window's periodicity is a lower bound for the period, in addition to the
+		// feed's periodicity.
+		setTickerPeriod(max(period, windowPeriodicity))
+		if !breaker.Allow(t) {
+			continue
+		}
+		lastHash := d.state.LastHash
+		dFile, err := d.downloadWithRetries(session.Ctx())
+		if breaker.Record(t, err) {
+			if breaker.IsOpen() {
+				session.Log().Warn("Opened the circuit breaker after too many consecutive failures")
+			} else {
+				session.Log().Info("Closed the circuit breaker after a successful download")
+			}
+			monitoring.RecordDownloadCircuitBreaker(feed, breaker.IsOpen())
+		}
+		if err != nil {
+			session.Log().Error(fmt.Sprintf("Error downloading file: %s", err))
+			continue
+		}
+		if err := d.saveState(); err != nil {
+			session.Log().Error(fmt.Sprintf("Error saving the downloader state: %s", err))
+		}
+		period = periodicity.Record(dFile.Time, dFile.Hash != lastHash)
+		setTickerPeriod(max(period, windowPeriodicity))
 	}
 }
 
-// RunOnce runs the download task once.
+// RunOnce runs the download task once. For streaming feeds, this waits until the first
+// batch of messages has been received and stored.
 func RunOnce(session *tasks.Session) error {
-	client := &http.Client{}
-	_, err := downloadOnce(session.Feed(), session.LocalDStore(), "", client, defaultTimeGetter)
-	return err
+	d, err := newDownloader(session)
+	if err != nil {
+		return err
+	}
+	if session.Feed().IsStreaming() {
+		if err := d.receiveOnce(session.Ctx(), session.Log()); err != nil {
+			return err
+		}
+		return d.saveState()
+	}
+	if _, err = d.downloadWithRetries(session.Ctx()); err != nil {
+		return err
+	}
+	return d.saveState()
 }
 
 type timeGetter func() time.Time
@@ -60,45 +158,622 @@ type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func downloadOnce(feed *config.Feed, dstore storage.DStore, lastHash storage.Hash, client httpClient, now timeGetter) (*storage.DFile, error) {
-	req, err := http.NewRequest("GET", feed.URL, nil)
+// state contains information about previous downloads of a feed that is used when
+// performing the next download. The state is persisted in the workspace so that it
+// survives restarts.
+type state struct {
+	// LastHash is the hash of the most recently downloaded data.
+	LastHash storage.Hash
+	// LastNormalizedHash is the hash of the most recently downloaded data after
+	// normalization. It is only set if the feed is normalized.
+	LastNormalizedHash storage.Hash `json:",omitempty"`
+	// Validators are the cache validators in the most recent successful response from each
+	// URL, keyed by the URL in the feed config.
+	Validators map[string]validators `json:",omitempty"`
+	// LastSuccess is the time of the most recent successful download.
+	LastSuccess time.Time
+	// LastFileModTime and LastFileName identify the most recent file read from the spool
+	// directory of a file source.
+	LastFileModTime time.Time `json:",omitempty"`
+	LastFileName    string    `json:",omitempty"`
+}
+
+// validators are the values of the ETag and Last-Modified headers in a response.
+type validators struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	// Hash is the hash of the data in the response.
+	Hash storage.Hash
+}
+
+// downloader downloads a single feed and stores the results in a DStore.
+type downloader struct {
+	feed   *config.Feed
+	dstore storage.DStore
+	client httpClient
+	now    timeGetter
+	// tokens is nil if the feed does not use OAuth2 authentication.
+	tokens *tokenSource
+	// templates are the parsed templates of the feed's URLs and header values, and
+	// templatesErr the error parsing them. The templates are validated when the config is
+	// loaded, so templatesErr is only set for feeds that were not loaded from a config.
+	templates    *requestTemplates
+	templatesErr error
+	// quarantine is the DStore in which responses that fail validation are kept. It is nil
+	// if quarantining is disabled for the feed.
+	quarantine storage.DStore
+	// errorsDStore is the DStore in which failed responses are kept. It is nil if the feed
+	// does not keep failed responses.
+	errorsDStore storage.DStore
+	// keptErrors is the number of failed responses kept in keptErrorsHour. It is seeded
+	// from the errors DStore the first time a response is kept, and so is not affected by
+	// DFiles later being removed from the DStore when it is packed.
+	keptErrors       int
+	keptErrorsHour   hour.Hour
+	keptErrorsSeeded bool
+	// dialer is used to open WebSocket connections. If nil, the default dialer is used.
+	dialer *websocket.Dialer
+	// rateLimits is nil if requests are not rate limited.
+	rateLimits *ratelimit.Registry
+	// statePath is the path of the file in which the state is persisted. If empty, the
+	// state is not persisted.
+	statePath string
+	// tmpDir is the directory in which responses are written while they are downloaded. If
+	// empty, the default directory for temporary files is used.
+	tmpDir string
+	// nextURL is the index of the URL the next download starts with when the feed uses the
+	// round-robin URL strategy.
+	nextURL int
+	state   state
+}
+
+func newDownloader(session *tasks.Session) (*downloader, error) {
+	client, err := newHTTPClient(session.Feed())
 	if err != nil {
+		return nil, fmt.Errorf("failed to create the HTTP client: %w", err)
+	}
+	d := newDownloaderWithClient(session.Feed(), session.LocalDStore(), client, defaultTimeGetter)
+	if d.templatesErr != nil {
+		return nil, d.templatesErr
+	}
+	d.rateLimits = session.RateLimits()
+	if session.Feed().SourceActual() == config.SourceWebSocket {
+		d.dialer = newWebSocketDialer(session.Feed(), client)
+	}
+	if d.tmpDir, err = session.TmpDir(); err != nil {
 		return nil, err
 	}
-	for key, value := range feed.Headers {
-		req.Header.Set(key, value)
+	stateDir, err := session.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	if stateDir != "" {
+		d.statePath = filepath.Join(stateDir, stateFileName)
+	}
+	if err := d.loadState(); err != nil {
+		session.Log().Warn(fmt.Sprintf("Failed to restore the downloader state: %s", err))
+	}
+	if session.Feed().Validation.Quarantine {
+		d.quarantine = session.QuarantineDStore()
+	}
+	d.errorsDStore = session.ErrorsDStore()
+	return d, nil
+}
+
+func newDownloaderWithClient(feed *config.Feed, dstore storage.DStore, client httpClient, now timeGetter) *downloader {
+	d := &downloader{
+		feed:   feed,
+		dstore: dstore,
+		client: client,
+		now:    now,
+	}
+	if feed.Auth != nil {
+		d.tokens = newTokenSource(feed.Auth, client, now)
+	}
+	d.templates, d.templatesErr = parseRequestTemplates(feed)
+	return d
+}
+
+// downloadWithRetries downloads the feed, retrying failed downloads as specified in the
+// feed configuration. The wait between consecutive attempts grows exponentially and
+// includes random jitter. Retrying stops early if the context is cancelled. The result is
+// recorded once for the whole cycle, and each retry is recorded separately.
+func (d *downloader) downloadWithRetries(ctx context.Context) (*storage.DFile, error) {
+	backoff := d.feed.Retries.InitialBackoffActual()
+	for attempt := 0; ; attempt++ {
+		dFile, err := d.downloadOnce(ctx)
+		if err == nil || attempt >= d.feed.Retries.Attempts {
+			monitoring.RecordDownload(d.feed, err)
+			return dFile, err
+		}
+		// We wait for a random duration between half the backoff and the full backoff.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			monitoring.RecordDownload(d.feed, err)
+			return nil, err
+		}
+		monitoring.RecordDownloadRetry(d.feed)
+		backoff = min(2*backoff, d.feed.Retries.MaxBackoffActual())
+	}
+}
+
+// downloadOnce downloads the feed from its source and stores the result in the DStore,
+// unless the data is the same as the last download. The state is updated if the download
+// is successful. HTTP requests and commands are cancelled if the context is cancelled.
+func (d *downloader) downloadOnce(ctx context.Context) (*storage.DFile, error) {
+	switch d.feed.SourceActual() {
+	case config.SourceFile:
+		return d.readSpoolDir()
+	case config.SourceExec:
+		return d.runCommand(ctx)
+	default:
+		return d.downloadHTTP(ctx)
 	}
-	resp, err := client.Do(req)
+}
+
+// downloadHTTP downloads the feed using an HTTP request.
+//
+// Responses that fail the feed's validation rules are not stored and an error is returned.
+// If quarantining is enabled, these responses are stored in the quarantine DStore instead.
+// Responses that fail validation or have a non-200 status are also kept in the errors
+// DStore if the feed keeps failed responses.
+//
+// If the state contains an ETag or Last-Modified value from the URL the request is sent
+// to, and that URL returned the data of the last download, the request is made
+// conditional on the data having changed. A 304 Not Modified response to such a request
+// is treated as a successful download of the same data as the last download.
+func (d *downloader) downloadHTTP(ctx context.Context) (*storage.DFile, error) {
+	start := time.Now()
+	resp, err := d.send(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := d.validators(resp.urlIndex); ok && resp.StatusCode == http.StatusNotModified {
+		if err := resp.Body.Close(); err != nil {
+			return nil, err
+		}
+		return d.notModified(), nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("non-200 status recieved: %d / %s", resp.StatusCode, resp.Status)
+		err := fmt.Errorf("non-200 status recieved: %d / %s", resp.StatusCode, resp.Status)
+		if kErr := d.keepError(resp.Body, func() storage.DFileMetadata {
+			return resp.metadata(time.Since(start))
+		}); kErr != nil {
+			err = util.NewMultipleError(err, fmt.Errorf("failed to keep the failed response: %w", kErr))
+		}
+		_ = resp.Body.Close()
+		return nil, err
 	}
-	// We read the whole content into memory so that we can calculate the hash
-	content, err := io.ReadAll(resp.Body)
+	// We stream the body to a temporary file, calculating the hash along the way. Once the
+	// hash is known, the file is moved into the DStore.
+	body, err := d.bufferBody(resp.Body)
 	if err != nil {
 		_ = resp.Body.Close()
 		return nil, err
 	}
+	defer body.remove()
 	if err = resp.Body.Close(); err != nil {
 		return nil, err
 	}
+	dFile, err := d.store(body, resp.Header.Get("Content-Type"), func() storage.DFileMetadata {
+		return resp.metadata(time.Since(start))
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.setValidators(resp.urlIndex, validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Hash:         d.state.LastHash,
+	})
+	return dFile, nil
+}
+
+// validators returns the cache validators of the URL with the given index. They are only
+// returned if that URL returned the data of the last download, as otherwise a 304 Not
+// Modified response would refer to different data.
+func (d *downloader) validators(urlIndex int) (validators, bool) {
+	v, ok := d.state.Validators[d.feed.URLsActual()[urlIndex]]
+	if !ok || d.state.LastHash == "" || v.Hash != d.state.LastHash {
+		return validators{}, false
+	}
+	return v, true
+}
+
+func (d *downloader) setValidators(urlIndex int, v validators) {
+	u := d.feed.URLsActual()[urlIndex]
+	if v.ETag == "" && v.LastModified == "" {
+		delete(d.state.Validators, u)
+		return
+	}
+	if d.state.Validators == nil {
+		d.state.Validators = map[string]validators{}
+	}
+	d.state.Validators[u] = v
+}
+
+// notModified records a successful download in which the data has not changed since the
+// last download.
+func (d *downloader) notModified() *storage.DFile {
+	monitoring.RecordNotModifiedDownload(d.feed)
+	dFile := storage.DFile{
+		Prefix:  d.feed.Prefix(),
+		Postfix: d.feed.Postfix,
+		Time:    d.now(),
+		Hash:    d.state.LastHash,
+	}
+	d.state.LastSuccess = dFile.Time
+	return &dFile
+}
 
-	hash := storage.CalculateHash(content)
+// store validates the buffered body and stores it in the DStore, unless the data is the same
+// as the last download. If metadata is non-nil, it is called to obtain the metadata stored
+// with the DFile. The hash and time of the last success in the state are updated if the body
+// is valid.
+func (d *downloader) store(body *bufferedBody, contentType string, metadata func() storage.DFileMetadata) (*storage.DFile, error) {
+	feed := d.feed
 	dFile := storage.DFile{
 		Prefix:  feed.Prefix(),
 		Postfix: feed.Postfix,
-		Time:    now(),
-		Hash:    hash,
+		Time:    d.now(),
+		Hash:    body.hash,
 	}
-	if hash == lastHash {
-		return &dFile, nil
+	if err := validateResponse(&feed.Validation, contentType, body.file, body.size); err != nil {
+		monitoring.RecordInvalidDownload(feed)
+		if _, kErr := body.file.Seek(0, io.SeekStart); kErr != nil {
+			return nil, util.NewMultipleError(err, kErr)
+		}
+		if kErr := d.keepError(body.file, metadata); kErr != nil {
+			return nil, util.NewMultipleError(err, fmt.Errorf("failed to keep the failed response: %w", kErr))
+		}
+		if d.quarantine != nil {
+			if qErr := body.storeIn(d.quarantine, dFile); qErr != nil {
+				return nil, util.NewMultipleError(err, fmt.Errorf("failed to quarantine the response: %w", qErr))
+			}
+		}
+		return nil, err
 	}
-	err = dstore.Store(dFile, bytes.NewReader(content))
+	if !feed.Normalization.IsEmpty() {
+		// The raw body is stored under its own hash, but whether the data has changed is
+		// determined using the normalized hash. If it has not changed, the download is
+		// treated as a download of the last stored data.
+		normalized, err := normalizedHash(&feed.Normalization, body.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to normalize the response: %w", err)
+		}
+		if normalized == d.state.LastNormalizedHash && d.state.LastHash != "" {
+			dFile.Hash = d.state.LastHash
+		}
+		d.state.LastNormalizedHash = normalized
+	}
+	if dFile.Hash != d.state.LastHash {
+		if metadataDStore, ok := d.dstore.(storage.WritableMetadataDStore); ok && metadata != nil {
+			if err := metadataDStore.StoreMetadata(dFile, metadata()); err != nil {
+				return nil, fmt.Errorf("failed to store the response metadata: %w", err)
+			}
+		}
+		if err := body.storeIn(d.dstore, dFile); err != nil {
+			return nil, err
+		}
+		monitoring.RecordSavedDownload(feed, int(body.size))
+	}
+	d.state.LastHash = dFile.Hash
+	d.state.LastSuccess = dFile.Time
+	return &dFile, nil
+}
+
+// bufferedBody is a response body that has been written to a temporary file.
+type bufferedBody struct {
+	file *os.File
+	size int64
+	hash storage.Hash
+}
+
+// bufferBody streams the body to a temporary file while calculating its hash. If the feed
+// has a maximum size and the body exceeds it, the download is aborted.
+func (d *downloader) bufferBody(r io.Reader) (*bufferedBody, error) {
+	f, err := os.CreateTemp(d.tmpDir, "download-")
 	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary file for the download: %w", err)
+	}
+	body := &bufferedBody{file: f}
+	if d.feed.MaxSize > 0 {
+		// We read one extra byte to detect if the body exceeds the maximum size.
+		r = io.LimitReader(r, d.feed.MaxSize+1)
+	}
+	hasher := storage.NewHasher()
+	body.size, err = io.Copy(io.MultiWriter(f, hasher), r)
+	if err != nil {
+		body.remove()
 		return nil, err
 	}
-	monitoring.RecordSavedDownload(feed, len(content))
-	return &dFile, nil
+	if d.feed.MaxSize > 0 && body.size > d.feed.MaxSize {
+		body.remove()
+		return nil, fmt.Errorf("response exceeds the maximum size of %d bytes", d.feed.MaxSize)
+	}
+	body.hash = hasher.Hash()
+	return body, nil
+}
+
+// storeIn stores the body as the DFile in the DStore. After this the body can no longer be read.
+func (body *bufferedBody) storeIn(dstore storage.WritableDStore, dFile storage.DFile) error {
+	if err := body.file.Close(); err != nil {
+		return err
+	}
+	return storage.StoreFile(dstore, dFile, body.file.Name())
+}
+
+// remove removes the temporary file, if it still exists.
+func (body *bufferedBody) remove() {
+	_ = body.file.Close()
+	_ = os.Remove(body.file.Name())
+}
+
+// response is an HTTP response along with information about the connection it was
+// received on.
+type response struct {
+	*http.Response
+	// serverIP is empty if the connection information is not available.
+	serverIP string
+	// urlIndex is the index of the feed URL the response was received from.
+	urlIndex int
+	// url is the URL the request was sent to.
+	url *url.URL
+}
+
+// cancelOnClose is a response body that cancels the context of the request when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+// metadataHeaders are the response headers recorded in the metadata of DFiles.
+var metadataHeaders = []string{"Content-Type", "Date", "ETag", "Last-Modified"}
+
+func (resp *response) metadata(latency time.Duration) storage.DFileMetadata {
+	metadata := storage.DFileMetadata{
+		StatusCode:          resp.StatusCode,
+		LatencyMilliseconds: latency.Milliseconds(),
+		ServerIP:            resp.serverIP,
+	}
+	if resp.url != nil {
+		u := *resp.url
+		u.User = nil
+		u.RawQuery = ""
+		u.ForceQuery = false
+		metadata.URL = u.String()
+	}
+	for _, key := range metadataHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			if metadata.Headers == nil {
+				metadata.Headers = map[string]string{}
+			}
+			metadata.Headers[key] = value
+		}
+	}
+	return metadata
+}
+
+// send sends the request for the feed. If the feed has multiple URLs, they are used
+// according to the URL strategy of the feed. If no URL succeeds, the last response or error
+// received is returned. The requests are cancelled if the context is cancelled.
+func (d *downloader) send(ctx context.Context) (*response, error) {
+	numURLs := len(d.feed.URLsActual())
+	switch d.feed.URLStrategyActual() {
+	case config.URLStrategyRace:
+		return d.race(ctx, numURLs)
+	case config.URLStrategyRoundRobin:
+		start := d.nextURL % numURLs
+		d.nextURL = (start + 1) % numURLs
+		return d.failover(ctx, numURLs, start)
+	default:
+		return d.failover(ctx, numURLs, 0)
+	}
+}
+
+// succeeded returns true if the request to a URL succeeded. Responses other than 200 OK
+// and 304 Not Modified are failures, and cause the next URL to be tried.
+func succeeded(resp *response, err error) bool {
+	return err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified)
+}
+
+// failover sends the request to each URL in turn, beginning with the URL at index start,
+// until one succeeds.
+func (d *downloader) failover(ctx context.Context, numURLs int, start int) (*response, error) {
+	var resp *response
+	var err error
+	for i := 0; i < numURLs; i++ {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		resp, err = d.sendToURL(ctx, (start+i)%numURLs)
+		if succeeded(resp, err) {
+			break
+		}
+	}
+	return resp, err
+}
+
+// race sends the request to all of the URLs at the same time. The first successful response
+// is returned and the other requests are cancelled. The requests to all of the URLs are
+// also cancelled if the context is cancelled.
+func (d *downloader) race(ctx context.Context, numURLs int) (*response, error) {
+	type result struct {
+		resp *response
+		err  error
+	}
+	results := make(chan result, numURLs)
+	cancels := make([]context.CancelFunc, numURLs)
+	for i := 0; i < numURLs; i++ {
+		urlCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func() {
+			resp, err := d.sendToURL(urlCtx, i)
+			if err != nil {
+				cancel()
+			} else {
+				resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			}
+			results <- result{resp: resp, err: err}
+		}()
+	}
+	var last result
+	for received := 1; received <= numURLs; received++ {
+		if last.resp != nil {
+			_ = last.resp.Body.Close()
+		}
+		last = <-results
+		if !succeeded(last.resp, last.err) {
+			continue
+		}
+		for i, cancel := range cancels {
+			if i != last.resp.urlIndex {
+				cancel()
+			}
+		}
+		go func(remaining int) {
+			for ; remaining > 0; remaining-- {
+				if r := <-results; r.resp != nil {
+					_ = r.resp.Body.Close()
+				}
+			}
+		}(numURLs - received)
+		break
+	}
+	return last.resp, last.err
+}
+
+// sendToURL sends the request to the URL at the index. If the feed uses OAuth2
+// authentication and the server rejects the access token, a new token is obtained and the
+// request is sent again.
+func (d *downloader) sendToURL(ctx context.Context, urlIndex int) (*response, error) {
+	resp, err := d.sendOnce(ctx, urlIndex)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && d.tokens != nil {
+		_ = resp.Body.Close()
+		d.tokens.Invalidate()
+		resp, err = d.sendOnce(ctx, urlIndex)
+	}
+	// Requests cancelled because another URL won the race are not recorded.
+	if ctx.Err() == nil {
+		monitoring.RecordURLRequest(d.feed, urlIndex, succeeded(resp, err))
+	}
+	return resp, err
+}
+
+func (d *downloader) sendOnce(ctx context.Context, urlIndex int) (*response, error) {
+	req, err := d.newRequest(urlIndex)
+	if err != nil {
+		return nil, err
+	}
+	resp := &response{urlIndex: urlIndex, url: req.URL}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				resp.serverIP = addr.IP.String()
+			}
+		},
+	}))
+	if v, ok := d.validators(urlIndex); ok {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+	if err := d.rateLimits.Wait(ctx, d.feed, req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	if d.tokens != nil {
+		token, err := d.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain an access token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp.Response, err = d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// requestTemplates are the parsed templates of a feed's URLs and header values.
+type requestTemplates struct {
+	urls    []*feedtemplate.Template
+	headers map[string]*feedtemplate.Template
+}
+
+func parseRequestTemplates(feed *config.Feed) (*requestTemplates, error) {
+	templates := &requestTemplates{headers: map[string]*feedtemplate.Template{}}
+	for _, u := range feed.URLsActual() {
+		t, err := feedtemplate.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the URL template: %w", err)
+		}
+		templates.urls = append(templates.urls, t)
+	}
+	for key, value := range feed.Headers {
+		t, err := feedtemplate.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the template for header %s: %w", key, err)
+		}
+		templates.headers[key] = t
+	}
+	return templates, nil
+}
+
+// newRequest builds the HTTP request used to download the feed from the URL with the index.
+// The URL and header values of the feed are templates that are rendered with the current
+// time. Secret references in the header values are resolved after rendering.
+func (d *downloader) newRequest(urlIndex int) (*http.Request, error) {
+	if d.templatesErr != nil {
+		return nil, d.templatesErr
+	}
+	feed := d.feed
+	data := feedtemplate.Data{
+		Time:        d.now(),
+		FeedID:      feed.ID,
+		LastSuccess: d.state.LastSuccess,
+	}
+	if data.LastSuccess.IsZero() {
+		data.LastSuccess = data.Time.Add(-feed.Periodicity)
+	}
+	var body io.Reader
+	switch {
+	case feed.BodyFile != "":
+		b, err := os.ReadFile(feed.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the body file: %w", err)
+		}
+		body = bytes.NewReader(b)
+	case feed.Body != "":
+		body = strings.NewReader(feed.Body)
+	}
+	u, err := d.templates.urls[urlIndex].Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the URL template: %w", err)
+	}
+	req, err := http.NewRequest(feed.MethodActual(), u, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, t := range d.templates.headers {
+		value, err := t.Render(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the template for header %s: %w", key, err)
+		}
+		req.Header.Set(key, feed.ResolveHeaderSecrets(value))
+	}
+	return req, nil
 }
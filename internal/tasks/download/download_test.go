@@ -2,13 +2,22 @@ package download
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/ratelimit"
 	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/tasks"
 	"github.com/jamespfennell/hoard/internal/util/testutil"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,26 +46,31 @@ func returnTime1() time.Time {
 }
 
 type httpClientForTesting struct {
-	body   []byte
-	status int
+	body    []byte
+	status  int
+	header  http.Header
+	request *http.Request
 }
 
-func (client httpClientForTesting) Do(*http.Request) (*http.Response, error) {
+func (client *httpClientForTesting) Do(req *http.Request) (*http.Response, error) {
+	client.request = req
 	if client.body == nil && client.status == 0 {
 		return nil, errors.New("simulated error")
 	}
-	if client.status == 0 {
-		client.status = http.StatusOK
+	status := client.status
+	if status == 0 {
+		status = http.StatusOK
 	}
 	return &http.Response{
 		Body:       io.NopCloser(bytes.NewReader(client.body)),
-		StatusCode: client.status,
+		StatusCode: status,
+		Header:     client.header,
 	}, nil
 }
 
 func TestDownloadOnce(t *testing.T) {
 	d := dstore.NewInMemoryDStore()
-	client := httpClientForTesting{
+	client := &httpClientForTesting{
 		body: content1,
 	}
 	expectedDFile := storage.DFile{
@@ -66,7 +80,7 @@ func TestDownloadOnce(t *testing.T) {
 		Time:    time1,
 	}
 
-	actualDFile, err := downloadOnce(&feed, d, "", client, returnTime1)
+	actualDFile, err := newDownloaderWithClient(&feed, d, client, returnTime1).downloadOnce(context.Background())
 
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
@@ -82,11 +96,39 @@ func TestDownloadOnce(t *testing.T) {
 	}
 }
 
+func TestDownloadOnce_Metadata(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{
+		body: content1,
+		header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Etag":         []string{`"tag1"`},
+			"X-Other":      []string{"value"},
+		},
+	}
+
+	dFile, err := newDownloaderWithClient(&feed, d, client, returnTime1).downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	metadata, err := d.GetMetadata(*dFile)
+	testutil.ErrorOrFail(t, err)
+	if metadata == nil {
+		t.Fatalf("Expected metadata for the DFile; found none")
+	}
+	if metadata.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected status code %d", metadata.StatusCode)
+	}
+	expectedHeaders := map[string]string{"Content-Type": "application/json", "ETag": `"tag1"`}
+	if !reflect.DeepEqual(metadata.Headers, expectedHeaders) {
+		t.Errorf("Unexpected headers %v; expected %v", metadata.Headers, expectedHeaders)
+	}
+}
+
 func TestDownloadOnce_ErrorInExecuting(t *testing.T) {
 	d := dstore.NewInMemoryDStore()
-	client := httpClientForTesting{}
+	client := &httpClientForTesting{}
 
-	_, err := downloadOnce(&feed, d, "", client, returnTime1)
+	_, err := newDownloaderWithClient(&feed, d, client, returnTime1).downloadOnce(context.Background())
 
 	if err == nil {
 		t.Errorf("Expected error; recieved none")
@@ -95,11 +137,11 @@ func TestDownloadOnce_ErrorInExecuting(t *testing.T) {
 
 func TestDownloadOnce_BadResponseCode(t *testing.T) {
 	d := dstore.NewInMemoryDStore()
-	client := httpClientForTesting{
+	client := &httpClientForTesting{
 		status: http.StatusBadGateway,
 	}
 
-	_, err := downloadOnce(&feed, d, "", client, returnTime1)
+	_, err := newDownloaderWithClient(&feed, d, client, returnTime1).downloadOnce(context.Background())
 
 	if err == nil {
 		t.Errorf("Expected HTTP bad gateway error; recieved none")
@@ -108,11 +150,14 @@ func TestDownloadOnce_BadResponseCode(t *testing.T) {
 
 func TestDownloadOnce_SkipRepeatedHash(t *testing.T) {
 	d := dstore.NewInMemoryDStore()
-	client := httpClientForTesting{
+	client := &httpClientForTesting{
 		body: content1,
 	}
 
-	_, err := downloadOnce(&feed, d, hash1, client, returnTime1)
+	downloader := newDownloaderWithClient(&feed, d, client, returnTime1)
+	downloader.state = state{LastHash: hash1}
+
+	_, err := downloader.downloadOnce(context.Background())
 
 	if err != nil {
 		t.Errorf("Unexpected error")
@@ -121,3 +166,471 @@ func TestDownloadOnce_SkipRepeatedHash(t *testing.T) {
 		t.Errorf("Unexpected DFile written to the DStore")
 	}
 }
+
+func TestDownloadOnce_ConditionalRequest(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{
+		body: content1,
+		header: http.Header{
+			"Etag":          []string{`"tag1"`},
+			"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+	}
+	downloader := newDownloaderWithClient(&feed, d, client, returnTime1)
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if client.request.Header.Get("If-None-Match") != "" {
+		t.Errorf("Unexpected If-None-Match header in the first request")
+	}
+
+	client.body = content2
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if actual := client.request.Header.Get("If-None-Match"); actual != `"tag1"` {
+		t.Errorf("Unexpected If-None-Match header %q", actual)
+	}
+	if actual := client.request.Header.Get("If-Modified-Since"); actual != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Unexpected If-Modified-Since header %q", actual)
+	}
+	if downloader.state.LastHash != hash2 {
+		t.Errorf("Unexpected last hash %s; expected %s", downloader.state.LastHash, hash2)
+	}
+}
+
+func TestDownloadOnce_NotModified(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{
+		status: http.StatusNotModified,
+	}
+
+	downloader := newDownloaderWithClient(&feed, d, client, returnTime1)
+	downloader.state = state{LastHash: hash1, Validators: map[string]validators{url1: {ETag: `"tag1"`, Hash: hash1}}}
+
+	dFile, err := downloader.downloadOnce(context.Background())
+
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if dFile.Hash != hash1 {
+		t.Errorf("Unexpected hash %s; expected %s", dFile.Hash, hash1)
+	}
+	if d.Count() != 0 {
+		t.Errorf("Unexpected DFile written to the DStore")
+	}
+}
+
+func TestDownloadOnce_NotModifiedFromOtherURL(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{
+		status: http.StatusNotModified,
+	}
+	f := feed
+	f.URLs = []string{url1, "https://mirror.example.com/feed"}
+
+	downloader := newDownloaderWithClient(&f, d, client, returnTime1)
+	downloader.state = state{LastHash: hash1, Validators: map[string]validators{f.URLs[1]: {ETag: `"tag1"`, Hash: hash1}}}
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error for 304 response to an unconditional request; recieved none")
+	}
+	if header := client.request.Header.Get("If-None-Match"); header != "" {
+		t.Errorf("Unexpected If-None-Match header %q sent to the first URL", header)
+	}
+}
+
+func TestDownloadOnce_StaleValidatorsAreNotSent(t *testing.T) {
+	client := &httpClientForTesting{body: content2}
+	downloader := newDownloaderWithClient(&feed, dstore.NewInMemoryDStore(), client, returnTime1)
+	downloader.state = state{LastHash: hash2, Validators: map[string]validators{url1: {ETag: `"tag1"`, Hash: hash1}}}
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	if header := client.request.Header.Get("If-None-Match"); header != "" {
+		t.Errorf("Unexpected If-None-Match header %q for validators of older data", header)
+	}
+}
+
+// flakyHttpClient fails a fixed number of times before delegating to the wrapped client
+type flakyHttpClient struct {
+	httpClient
+	failuresLeft int
+}
+
+func (client *flakyHttpClient) Do(req *http.Request) (*http.Response, error) {
+	if client.failuresLeft > 0 {
+		client.failuresLeft--
+		return nil, errors.New("simulated error")
+	}
+	return client.httpClient.Do(req)
+}
+
+func TestDownloadWithRetries(t *testing.T) {
+	for _, testCase := range []struct {
+		attempts    int
+		failures    int
+		expectError bool
+	}{
+		{attempts: 0, failures: 0, expectError: false},
+		{attempts: 0, failures: 1, expectError: true},
+		{attempts: 2, failures: 2, expectError: false},
+		{attempts: 2, failures: 3, expectError: true},
+	} {
+		t.Run(fmt.Sprintf("%d attempts %d failures", testCase.attempts, testCase.failures), func(t *testing.T) {
+			d := dstore.NewInMemoryDStore()
+			client := &flakyHttpClient{
+				httpClient:   &httpClientForTesting{body: content1},
+				failuresLeft: testCase.failures,
+			}
+			f := feed
+			f.Retries = config.Retries{
+				Attempts:       testCase.attempts,
+				InitialBackoff: time.Millisecond,
+			}
+
+			_, err := newDownloaderWithClient(&f, d, client, returnTime1).downloadWithRetries(context.Background())
+
+			if testCase.expectError && err == nil {
+				t.Errorf("Expected error; recieved none")
+			}
+			if !testCase.expectError && err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestRunOnce_RetriesInMemorySession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	f := feed
+	f.URL = server.URL
+	f.Retries = config.Retries{
+		Attempts:       1,
+		InitialBackoff: time.Millisecond,
+	}
+
+	err := RunOnce(tasks.NewInMemorySession(&f))
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+}
+
+func TestDownloadOnce_MethodAndBody(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "body.json")
+	testutil.ErrorOrFail(t, os.WriteFile(bodyFile, []byte(`{"file": true}`), 0600))
+	for _, testCase := range []struct {
+		name           string
+		method         string
+		body           string
+		bodyFile       string
+		expectedMethod string
+		expectedBody   string
+	}{
+		{"default", "", "", "", "GET", ""},
+		{"inline body", "post", `{"query": 1}`, "", "POST", `{"query": 1}`},
+		{"body file", "POST", "", bodyFile, "POST", `{"file": true}`},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			d := dstore.NewInMemoryDStore()
+			client := &httpClientForTesting{body: content1}
+			f := feed
+			f.Method = testCase.method
+			f.Body = testCase.body
+			f.BodyFile = testCase.bodyFile
+
+			_, err := newDownloaderWithClient(&f, d, client, returnTime1).downloadOnce(context.Background())
+			testutil.ErrorOrFail(t, err)
+
+			if client.request.Method != testCase.expectedMethod {
+				t.Errorf("Unexpected method %s; expected %s", client.request.Method, testCase.expectedMethod)
+			}
+			var body []byte
+			if client.request.Body != nil {
+				body, err = io.ReadAll(client.request.Body)
+				testutil.ErrorOrFail(t, err)
+			}
+			if string(body) != testCase.expectedBody {
+				t.Errorf("Unexpected body %q; expected %q", body, testCase.expectedBody)
+			}
+		})
+	}
+}
+
+func TestDownloadOnce_Templates(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{body: content1}
+	f := feed
+	f.Periodicity = 5 * time.Second
+	f.URL = `http://www.example.com/{{ strftime "%Y/%m/%d" .Time }}/{{ .FeedID }}.json?since={{ .LastSuccess.Unix }}`
+	f.Headers = map[string]string{"X-Time": "{{ .UnixMilli }}"}
+	now := time1
+	downloader := newDownloaderWithClient(&f, d, client, func() time.Time { return now })
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	expectedURL := fmt.Sprintf("http://www.example.com/2020/01/02/feed.json?since=%d", time1.Add(-5*time.Second).Unix())
+	if actual := client.request.URL.String(); actual != expectedURL {
+		t.Errorf("Unexpected URL %s; expected %s", actual, expectedURL)
+	}
+	if actual := client.request.Header.Get("X-Time"); actual != fmt.Sprintf("%d", time1.UnixMilli()) {
+		t.Errorf("Unexpected header value %s", actual)
+	}
+
+	now = time1.Add(time.Minute)
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	expectedURL = fmt.Sprintf("http://www.example.com/2020/01/02/feed.json?since=%d", time1.Unix())
+	if actual := client.request.URL.String(); actual != expectedURL {
+		t.Errorf("Unexpected URL %s; expected %s", actual, expectedURL)
+	}
+}
+
+func TestDownloadOnce_HeaderSecretsAreNotTemplates(t *testing.T) {
+	t.Setenv("HOARD_TEST_TOKEN", "{{ .FeedID }}")
+	c, err := config.NewConfig([]byte(`feeds:
+  - id: feed
+    url: http://www.example.com
+    headers:
+      Authorization: "Bearer ${HOARD_TEST_TOKEN} {{ .FeedID }}"
+`))
+	testutil.ErrorOrFail(t, err)
+	client := &httpClientForTesting{body: content1}
+	downloader := newDownloaderWithClient(&c.Feeds[0], dstore.NewInMemoryDStore(), client, returnTime1)
+
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if actual := client.request.Header.Get("Authorization"); actual != "Bearer {{ .FeedID }} feed" {
+		t.Errorf("Unexpected header value %q", actual)
+	}
+}
+
+func TestDownloadOnce_InvalidResponseIsQuarantined(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	quarantine := dstore.NewInMemoryDStore()
+	client := &httpClientForTesting{body: content1}
+	f := feed
+	f.Validation = config.Validation{MinSize: 10, Quarantine: true}
+	downloader := newDownloaderWithClient(&f, d, client, returnTime1)
+	downloader.quarantine = quarantine
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected validation error; recieved none")
+	}
+	if d.Count() != 0 {
+		t.Errorf("Unexpected DFile written to the DStore")
+	}
+	expectedDFile := storage.DFile{
+		Prefix:  prefix1,
+		Postfix: postfix1,
+		Hash:    hash1,
+		Time:    time1,
+	}
+	if err := testutil.DStoreHasDFile(quarantine, expectedDFile, content1); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDownloadOnce_MaxSize(t *testing.T) {
+	for _, testCase := range []struct {
+		maxSize     int64
+		expectError bool
+	}{
+		{maxSize: 0, expectError: false},
+		{maxSize: 3, expectError: false},
+		{maxSize: 2, expectError: true},
+	} {
+		t.Run(fmt.Sprintf("max size %d", testCase.maxSize), func(t *testing.T) {
+			d := dstore.NewInMemoryDStore()
+			client := &httpClientForTesting{body: content1}
+			f := feed
+			f.MaxSize = testCase.maxSize
+			downloader := newDownloaderWithClient(&f, d, client, returnTime1)
+			downloader.tmpDir = t.TempDir()
+
+			_, err := downloader.downloadOnce(context.Background())
+
+			if testCase.expectError && err == nil {
+				t.Errorf("Expected error; recieved none")
+			}
+			if !testCase.expectError && err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			entries, err := os.ReadDir(downloader.tmpDir)
+			testutil.ErrorOrFail(t, err)
+			if len(entries) != 0 {
+				t.Errorf("Unexpected files left in the temporary directory: %v", entries)
+			}
+		})
+	}
+}
+
+// urlServer is a feed server that responds with a fixed status and body, and counts requests.
+type urlServer struct {
+	status   int
+	body     []byte
+	requests int
+	// block causes the server to wait until the request is cancelled.
+	block bool
+}
+
+func (s *urlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	if s.block {
+		<-r.Context().Done()
+		return
+	}
+	w.WriteHeader(s.status)
+	_, _ = w.Write(s.body)
+}
+
+func newURLServers(t *testing.T, handlers ...*urlServer) []string {
+	var urls []string
+	for _, handler := range handlers {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		urls = append(urls, server.URL+"/feed?key=secret")
+	}
+	return urls
+}
+
+func TestDownloadOnce_URLStrategies(t *testing.T) {
+	for _, testCase := range []struct {
+		name            string
+		strategy        config.URLStrategy
+		servers         []*urlServer
+		expectedContent []byte
+		expectedURL     int
+	}{
+		{
+			"failover",
+			config.URLStrategyFailover,
+			[]*urlServer{{status: http.StatusInternalServerError}, {status: http.StatusOK, body: content2}},
+			content2,
+			1,
+		},
+		{
+			"failover, primary healthy",
+			config.URLStrategyFailover,
+			[]*urlServer{{status: http.StatusOK, body: content1}, {status: http.StatusOK, body: content2}},
+			content1,
+			0,
+		},
+		{
+			"race",
+			config.URLStrategyRace,
+			[]*urlServer{{block: true}, {status: http.StatusOK, body: content2}},
+			content2,
+			1,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			d := dstore.NewInMemoryDStore()
+			f := feed
+			f.URLs = newURLServers(t, testCase.servers...)
+			f.URLStrategy = testCase.strategy
+
+			dFile, err := newDownloaderWithClient(&f, d, &http.Client{}, returnTime1).downloadOnce(context.Background())
+			testutil.ErrorOrFail(t, err)
+
+			if err := testutil.DStoreHasDFile(d, *dFile, testCase.expectedContent); err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+			metadata, err := d.GetMetadata(*dFile)
+			testutil.ErrorOrFail(t, err)
+			expectedURL := strings.TrimSuffix(f.URLs[testCase.expectedURL], "?key=secret")
+			if metadata == nil || metadata.URL != expectedURL {
+				t.Errorf("Unexpected metadata %v; expected URL %s", metadata, expectedURL)
+			}
+		})
+	}
+}
+
+func TestDownloadOnce_URLStrategyRoundRobin(t *testing.T) {
+	servers := []*urlServer{{status: http.StatusOK, body: content1}, {status: http.StatusOK, body: content2}}
+	f := feed
+	f.URLs = newURLServers(t, servers...)
+	f.URLStrategy = config.URLStrategyRoundRobin
+	downloader := newDownloaderWithClient(&f, dstore.NewInMemoryDStore(), &http.Client{}, returnTime1)
+
+	for i := 0; i < 4; i++ {
+		_, err := downloader.downloadOnce(context.Background())
+		testutil.ErrorOrFail(t, err)
+	}
+
+	for i, server := range servers {
+		if server.requests != 2 {
+			t.Errorf("Unexpected number of requests to URL %d: %d != 2", i, server.requests)
+		}
+	}
+}
+
+func TestDownloadOnce_AllURLsFail(t *testing.T) {
+	for _, strategy := range []config.URLStrategy{config.URLStrategyFailover, config.URLStrategyRace} {
+		t.Run(string(strategy), func(t *testing.T) {
+			servers := []*urlServer{{status: http.StatusInternalServerError}, {status: http.StatusNotFound}}
+			f := feed
+			f.URLs = newURLServers(t, servers...)
+			f.URLStrategy = strategy
+
+			_, err := newDownloaderWithClient(&f, dstore.NewInMemoryDStore(), &http.Client{}, returnTime1).downloadOnce(context.Background())
+
+			if err == nil {
+				t.Errorf("Expected error; recieved none")
+			}
+			for i, server := range servers {
+				if server.requests != 1 {
+					t.Errorf("Unexpected number of requests to URL %d: %d != 1", i, server.requests)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadOnce_ContextCancelled(t *testing.T) {
+	for _, strategy := range []config.URLStrategy{config.URLStrategyFailover, config.URLStrategyRace} {
+		t.Run(string(strategy), func(t *testing.T) {
+			f := feed
+			f.URLs = newURLServers(t, &urlServer{block: true}, &urlServer{block: true})
+			f.URLStrategy = strategy
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := newDownloaderWithClient(&f, dstore.NewInMemoryDStore(), &http.Client{}, returnTime1).downloadOnce(ctx)
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Unexpected error %v; expected %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestDownloadOnce_RateLimitDropsRequest(t *testing.T) {
+	client := &httpClientForTesting{body: content1}
+	f := feed
+	f.RateLimit = "group"
+	downloader := newDownloaderWithClient(&f, dstore.NewInMemoryDStore(), client, returnTime1)
+	downloader.rateLimits = ratelimit.NewRegistry([]config.RateLimit{
+		{Name: "group", Requests: 1, Period: time.Hour, MaxDelay: time.Second},
+	})
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	client.request = nil
+	_, err = downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+	if client.request != nil {
+		t.Errorf("Unexpected request sent despite the rate limit")
+	}
+}
@@ -0,0 +1,61 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/hour"
+)
+
+// keepError stores a failed response in the errors DStore, if the feed keeps failed
+// responses. At most the maximum size of the response is kept, and the response is
+// dropped if the maximum number of failed responses for the current hour has been
+// reached. If metadata is non-nil, it is called to obtain the metadata stored with the
+// response.
+func (d *downloader) keepError(r io.Reader, metadata func() storage.DFileMetadata) error {
+	if d.errorsDStore == nil {
+		return nil
+	}
+	t := d.now()
+	hr := hour.FromTime(t)
+	if !d.keptErrorsSeeded {
+		dFiles, err := d.errorsDStore.ListInHour(hr)
+		if err != nil {
+			return err
+		}
+		d.keptErrors = len(dFiles)
+		d.keptErrorsHour = hr
+		d.keptErrorsSeeded = true
+	} else if hr != d.keptErrorsHour {
+		d.keptErrors = 0
+		d.keptErrorsHour = hr
+	}
+	if d.keptErrors >= d.feed.Errors.MaxCountActual() {
+		monitoring.RecordDroppedError(d.feed)
+		return nil
+	}
+	b, err := io.ReadAll(io.LimitReader(r, d.feed.Errors.MaxSizeActual()))
+	if err != nil {
+		return err
+	}
+	dFile := storage.DFile{
+		Prefix:  d.feed.ErrorsFeed().Prefix(),
+		Postfix: d.feed.Postfix,
+		Time:    t,
+		Hash:    storage.CalculateHash(b),
+	}
+	if metadataDStore, ok := d.errorsDStore.(storage.WritableMetadataDStore); ok && metadata != nil {
+		if err := metadataDStore.StoreMetadata(dFile, metadata()); err != nil {
+			return fmt.Errorf("failed to store the response metadata: %w", err)
+		}
+	}
+	if err := d.errorsDStore.Store(dFile, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	d.keptErrors++
+	monitoring.RecordKeptError(d.feed)
+	return nil
+}
@@ -0,0 +1,127 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func newDownloaderKeepingErrors(f *config.Feed, client httpClient, now timeGetter) (*downloader, *dstore.InMemoryDStore, *dstore.InMemoryDStore) {
+	d := dstore.NewInMemoryDStore()
+	errorsDStore := dstore.NewInMemoryDStore()
+	f.Errors.Keep = true
+	downloader := newDownloaderWithClient(f, d, client, now)
+	downloader.errorsDStore = errorsDStore
+	return downloader, d, errorsDStore
+}
+
+func TestDownloadOnce_NonOKResponseIsKept(t *testing.T) {
+	f := feed
+	client := &httpClientForTesting{body: content1, status: http.StatusServiceUnavailable}
+	downloader, d, errorsDStore := newDownloaderKeepingErrors(&f, client, returnTime1)
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error for non-200 status; recieved none")
+	}
+	if d.Count() != 0 {
+		t.Errorf("Unexpected DFile written to the DStore")
+	}
+	expectedDFile := storage.DFile{
+		Prefix:  f.ErrorsFeed().Prefix(),
+		Postfix: postfix1,
+		Hash:    hash1,
+		Time:    time1,
+	}
+	if err := testutil.DStoreHasDFile(errorsDStore, expectedDFile, content1); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	metadata, err := errorsDStore.GetMetadata(expectedDFile)
+	testutil.ErrorOrFail(t, err)
+	if metadata == nil || metadata.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected metadata %+v; expected status code %d", metadata, http.StatusServiceUnavailable)
+	}
+}
+
+func TestDownloadOnce_InvalidResponseIsKept(t *testing.T) {
+	f := feed
+	f.Validation = config.Validation{MinSize: 10}
+	client := &httpClientForTesting{body: content1}
+	downloader, _, errorsDStore := newDownloaderKeepingErrors(&f, client, returnTime1)
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected validation error; recieved none")
+	}
+	expectedDFile := storage.DFile{
+		Prefix:  f.ErrorsFeed().Prefix(),
+		Postfix: postfix1,
+		Hash:    hash1,
+		Time:    time1,
+	}
+	if err := testutil.DStoreHasDFile(errorsDStore, expectedDFile, content1); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDownloadOnce_KeptErrorsAreCapped(t *testing.T) {
+	f := feed
+	f.Errors = config.Errors{MaxSize: 2, MaxCount: 2}
+	client := &httpClientForTesting{body: content1, status: http.StatusInternalServerError}
+	now := time1
+	downloader, _, errorsDStore := newDownloaderKeepingErrors(&f, client, func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		_, _ = downloader.downloadOnce(context.Background())
+		now = now.Add(time.Second)
+	}
+
+	if errorsDStore.Count() != 2 {
+		t.Errorf("Unexpected number of kept errors %d; expected 2", errorsDStore.Count())
+	}
+	expectedDFile := storage.DFile{
+		Prefix:  f.ErrorsFeed().Prefix(),
+		Postfix: postfix1,
+		Hash:    storage.CalculateHash(content1[:2]),
+		Time:    time1,
+	}
+	if err := testutil.DStoreHasDFile(errorsDStore, expectedDFile, content1[:2]); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDownloadOnce_KeptErrorsCapSurvivesPacking(t *testing.T) {
+	f := feed
+	f.Errors = config.Errors{MaxCount: 2}
+	client := &httpClientForTesting{body: content1, status: http.StatusInternalServerError}
+	now := time1
+	downloader, _, _ := newDownloaderKeepingErrors(&f, client, func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		_, _ = downloader.downloadOnce(context.Background())
+		now = now.Add(time.Second)
+	}
+	// Packing removes the kept errors from the DStore.
+	errorsDStore := dstore.NewInMemoryDStore()
+	downloader.errorsDStore = errorsDStore
+	_, _ = downloader.downloadOnce(context.Background())
+
+	if errorsDStore.Count() != 0 {
+		t.Errorf("Unexpected number of kept errors %d; expected 0", errorsDStore.Count())
+	}
+
+	now = now.Add(time.Hour)
+	_, _ = downloader.downloadOnce(context.Background())
+
+	if errorsDStore.Count() != 1 {
+		t.Errorf("Unexpected number of kept errors %d; expected 1", errorsDStore.Count())
+	}
+}
@@ -0,0 +1,66 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// maxStderrSize is the maximum amount of the command's stderr included in error messages.
+const maxStderrSize = 1024
+
+// waitDelay is how long to wait for the output of the command to be closed after the
+// command has been killed.
+const waitDelay = time.Second
+
+// runCommand runs the command of an exec source and stores its stdout. The command fails
+// if it exits with a non-zero status or does not finish before the timeout. The command is
+// also killed if the context is cancelled.
+func (d *downloader) runCommand(ctx context.Context) (*storage.DFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.feed.Exec.TimeoutActual())
+	defer cancel()
+	command := d.feed.Exec.Command
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// The output is read through an in-memory pipe that is closed when the command exits.
+	// Unlike reading the OS pipe directly, this does not block forever if the command
+	// started a child process that keeps stdout open.
+	stdout, stdoutWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	cmd.WaitDelay = waitDelay
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the command: %w", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		_ = stdoutWriter.Close()
+		waitErr <- err
+	}()
+	body, err := d.bufferBody(stdout)
+	if err != nil {
+		cancel()
+		_ = stdout.Close()
+		<-waitErr
+		return nil, err
+	}
+	defer body.remove()
+	if err := <-waitErr; err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderrSize {
+			msg = msg[len(msg)-maxStderrSize:]
+		}
+		if msg == "" {
+			return nil, fmt.Errorf("the command failed: %w", err)
+		}
+		return nil, fmt.Errorf("the command failed: %w: %s", err, msg)
+	}
+	return d.store(body, "", nil)
+}
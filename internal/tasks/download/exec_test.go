@@ -0,0 +1,97 @@
+package download
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func newExecFeed(command ...string) *config.Feed {
+	f := feed
+	f.Source = config.SourceExec
+	f.Exec.Command = command
+	return &f
+}
+
+func TestRunCommand(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	downloader := newDownloaderWithClient(newExecFeed("echo", "snapshot"), d, nil, time.Now)
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	expected := []string{"snapshot\n"}
+	if actual := storedContents(t, d); !equalStrings(actual, expected) {
+		t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+	}
+}
+
+func TestRunCommand_Failure(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	downloader := newDownloaderWithClient(
+		newExecFeed("sh", "-c", "echo partial; echo something went wrong >&2; exit 3"), d, nil, time.Now)
+
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil || !strings.Contains(err.Error(), "something went wrong") {
+		t.Errorf("Unexpected error %v; expected the error to contain stderr", err)
+	}
+	if d.Count() != 0 {
+		t.Errorf("Unexpected DFile written to the DStore")
+	}
+}
+
+func TestRunCommand_Timeout(t *testing.T) {
+	f := newExecFeed("sleep", "10")
+	f.Exec.Timeout = 50 * time.Millisecond
+	downloader := newDownloaderWithClient(f, dstore.NewInMemoryDStore(), nil, time.Now)
+
+	start := time.Now()
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Unexpected elapsed time %s; expected the command to be killed", elapsed)
+	}
+}
+
+func TestRunCommand_TimeoutWithChildProcess(t *testing.T) {
+	// The child process inherits stdout and keeps it open after the shell is killed.
+	f := newExecFeed("sh", "-c", "sleep 10; echo done")
+	f.Exec.Timeout = 50 * time.Millisecond
+	downloader := newDownloaderWithClient(f, dstore.NewInMemoryDStore(), nil, time.Now)
+
+	start := time.Now()
+	_, err := downloader.downloadOnce(context.Background())
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Unexpected elapsed time %s; expected the command to be killed", elapsed)
+	}
+}
+
+func TestRunCommand_ContextCancelled(t *testing.T) {
+	f := newExecFeed("sleep", "10")
+	downloader := newDownloaderWithClient(f, dstore.NewInMemoryDStore(), nil, time.Now)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := downloader.downloadOnce(ctx)
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Unexpected elapsed time %s; expected the command to be killed", elapsed)
+	}
+}
@@ -0,0 +1,100 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/util"
+)
+
+// spoolFile is a file in the spool directory of a file source.
+type spoolFile struct {
+	name    string
+	modTime time.Time
+}
+
+// after returns true if the file comes after the file with the modification time and name,
+// in the order in which files are read.
+func (f spoolFile) after(modTime time.Time, name string) bool {
+	if !f.modTime.Equal(modTime) {
+		return f.modTime.After(modTime)
+	}
+	return f.name > name
+}
+
+// readSpoolDir stores the files in the spool directory that have been added or modified
+// since the last download, ordered by modification time. Files are never removed from the
+// spool directory. Hidden files, whose names begin with a dot, are skipped so that writers
+// can create files under a hidden name and rename them once they are complete.
+//
+// Files that fail validation are skipped and an error is returned after the remaining files
+// have been stored. If no files have changed, the download is treated like an HTTP 304 Not
+// Modified response.
+func (d *downloader) readSpoolDir() (*storage.DFile, error) {
+	dir := d.feed.SpoolDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the spool directory: %w", err)
+	}
+	var files []spoolFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The file was removed after the directory was listed.
+			continue
+		}
+		file := spoolFile{name: entry.Name(), modTime: info.ModTime()}
+		if file.after(d.state.LastFileModTime, d.state.LastFileName) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[j].after(files[i].modTime, files[i].name)
+	})
+	if len(files) == 0 {
+		return d.notModified(), nil
+	}
+	var lastDFile *storage.DFile
+	var errs []error
+	for _, file := range files {
+		dFile, err := d.readSpoolFile(filepath.Join(dir, file.name))
+		if err != nil {
+			if _, ok := err.(validationError); !ok {
+				// The file could not be read, so we try again in the next download.
+				errs = append(errs, err)
+				break
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", file.name, err))
+		} else {
+			lastDFile = dFile
+		}
+		d.state.LastFileModTime = file.modTime
+		d.state.LastFileName = file.name
+	}
+	if err := util.NewMultipleError(errs...); err != nil {
+		return nil, err
+	}
+	return lastDFile, nil
+}
+
+func (d *downloader) readSpoolFile(path string) (*storage.DFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	body, err := d.bufferBody(f)
+	if err != nil {
+		return nil, err
+	}
+	defer body.remove()
+	return d.store(body, "", nil)
+}
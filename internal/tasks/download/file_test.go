@@ -0,0 +1,82 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func writeSpoolFile(t *testing.T, dir string, name string, content string, modTime time.Time) {
+	path := filepath.Join(dir, name)
+	testutil.ErrorOrFail(t, os.WriteFile(path, []byte(content), 0644))
+	testutil.ErrorOrFail(t, os.Chtimes(path, modTime, modTime))
+}
+
+func newFileFeed(dir string) *config.Feed {
+	f := feed
+	f.URL = "file://" + dir
+	return &f
+}
+
+func TestReadSpoolDir(t *testing.T) {
+	dir := t.TempDir()
+	writeSpoolFile(t, dir, "b", "content b", time1.Add(-time.Minute))
+	writeSpoolFile(t, dir, "a", "content a", time1)
+	writeSpoolFile(t, dir, ".partial", "content partial", time1)
+	testutil.ErrorOrFail(t, os.Mkdir(filepath.Join(dir, "subdir"), 0755))
+	d := dstore.NewInMemoryDStore()
+	downloader := newDownloaderWithClient(newFileFeed(dir), d, nil, time.Now)
+
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	expected := []string{"content a", "content b"}
+	if actual := storedContents(t, d); !equalStrings(actual, expected) {
+		t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+	}
+	// The most recently modified file is stored last.
+	if downloader.state.LastHash != storage.CalculateHash([]byte("content a")) {
+		t.Errorf("Unexpected last hash %s", downloader.state.LastHash)
+	}
+
+	// Only new files are read in the next download.
+	writeSpoolFile(t, dir, "c", "content c", time1.Add(time.Minute))
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	expected = []string{"content a", "content b", "content c"}
+	if actual := storedContents(t, d); !equalStrings(actual, expected) {
+		t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+	}
+}
+
+func TestReadSpoolDir_InvalidFileIsSkipped(t *testing.T) {
+	dir := t.TempDir()
+	writeSpoolFile(t, dir, "a", "invalid", time1)
+	writeSpoolFile(t, dir, "b", "valid", time1.Add(time.Minute))
+	f := newFileFeed(dir)
+	f.Validation.NotMatch = "invalid"
+	d := dstore.NewInMemoryDStore()
+	downloader := newDownloaderWithClient(f, d, nil, time.Now)
+
+	_, err := downloader.downloadOnce(context.Background())
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+	_, err = downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	expected := []string{"valid"}
+	if actual := storedContents(t, d); !equalStrings(actual, expected) {
+		t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+	}
+}
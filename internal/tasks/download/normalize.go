@@ -0,0 +1,148 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// normalizedHash returns the hash of the body after the normalizations of the feed have
+// been applied. Unlike validation, normalization reads the whole body into memory.
+//
+// If the body cannot be parsed as JSON or as a protocol buffer message, the corresponding
+// normalization is skipped rather than failing the download.
+func normalizedHash(n *config.Normalization, body io.ReadSeeker) (storage.Hash, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	b, err = normalize(n, b)
+	if err != nil {
+		return "", err
+	}
+	return storage.CalculateHash(b), nil
+}
+
+func normalize(n *config.Normalization, b []byte) ([]byte, error) {
+	if len(n.IgnoreJSONPaths) > 0 {
+		b = removeJSONPaths(b, n.IgnoreJSONPaths)
+	}
+	for _, path := range n.IgnoreProtobufFields {
+		fieldNumbers, err := config.ParseProtobufFieldPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if stripped, ok := removeProtobufField(b, fieldNumbers); ok {
+			b = stripped
+		}
+	}
+	for _, r := range n.StripRegexpsParsed() {
+		b = r.ReplaceAll(b, nil)
+	}
+	return b, nil
+}
+
+// removeJSONPaths removes the paths from the JSON document and returns the result in a
+// canonical form, with object keys sorted and insignificant whitespace removed. If the
+// document is not valid JSON it is returned unchanged.
+func removeJSONPaths(b []byte, paths []string) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return b
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return b
+	}
+	for _, path := range paths {
+		v = removeJSONPath(v, strings.Split(path, "."))
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return normalized
+}
+
+func removeJSONPath(v any, keys []string) any {
+	key := keys[0]
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if key != "*" && key != k {
+				continue
+			}
+			if len(keys) == 1 {
+				delete(v, k)
+			} else {
+				v[k] = removeJSONPath(child, keys[1:])
+			}
+		}
+		return v
+	case []any:
+		var result []any
+		for i, child := range v {
+			if key != "*" && key != strconv.Itoa(i) {
+				result = append(result, child)
+				continue
+			}
+			if len(keys) == 1 {
+				continue
+			}
+			result = append(result, removeJSONPath(child, keys[1:]))
+		}
+		return result
+	}
+	return v
+}
+
+// removeProtobufField removes all occurrences of the field at the path from the message in
+// the wire format. Because the schema is not known, intermediate fields in the path are
+// assumed to be nested messages; if one of them cannot be parsed, it is left unchanged.
+// The second return value is false if the message itself cannot be parsed.
+func removeProtobufField(b []byte, fieldNumbers []int32) ([]byte, bool) {
+	target := protowire.Number(fieldNumbers[0])
+	var result []byte
+	for len(b) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return nil, false
+		}
+		valueLen := protowire.ConsumeFieldValue(num, typ, b[tagLen:])
+		if valueLen < 0 {
+			return nil, false
+		}
+		field := b[:tagLen+valueLen]
+		b = b[tagLen+valueLen:]
+		if num != target {
+			result = append(result, field...)
+			continue
+		}
+		if len(fieldNumbers) == 1 {
+			continue
+		}
+		if typ != protowire.BytesType {
+			result = append(result, field...)
+			continue
+		}
+		value, _ := protowire.ConsumeBytes(field[tagLen:])
+		stripped, ok := removeProtobufField(value, fieldNumbers[1:])
+		if !ok {
+			result = append(result, field...)
+			continue
+		}
+		result = protowire.AppendTag(result, num, typ)
+		result = protowire.AppendBytes(result, stripped)
+	}
+	return result, true
+}
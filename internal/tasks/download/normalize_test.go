@@ -0,0 +1,166 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// gtfsRealtimeMessage builds a message shaped like a GTFS realtime FeedMessage: a header
+// (field 1) with a version (field 1) and timestamp (field 3), and an entity (field 2).
+func gtfsRealtimeMessage(timestamp uint64, entityID string) []byte {
+	var header []byte
+	header = protowire.AppendTag(header, 1, protowire.BytesType)
+	header = protowire.AppendString(header, "2.0")
+	header = protowire.AppendTag(header, 3, protowire.VarintType)
+	header = protowire.AppendVarint(header, timestamp)
+	var entity []byte
+	entity = protowire.AppendTag(entity, 1, protowire.BytesType)
+	entity = protowire.AppendString(entity, entityID)
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.BytesType)
+	message = protowire.AppendBytes(message, header)
+	message = protowire.AppendTag(message, 2, protowire.BytesType)
+	message = protowire.AppendBytes(message, entity)
+	return message
+}
+
+func TestNormalize(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		normalization config.Normalization
+		content1      []byte
+		content2      []byte
+		expectSame    bool
+	}{
+		{
+			"JSON path",
+			config.Normalization{IgnoreJSONPaths: []string{"header.timestamp"}},
+			[]byte(`{"header": {"timestamp": 1, "version": 2}, "data": [1]}`),
+			[]byte(`{"data":[1],"header":{"version":2,"timestamp":2}}`),
+			true,
+		},
+		{
+			"JSON path, other data changed",
+			config.Normalization{IgnoreJSONPaths: []string{"header.timestamp"}},
+			[]byte(`{"header": {"timestamp": 1}, "data": [1]}`),
+			[]byte(`{"header": {"timestamp": 2}, "data": [2]}`),
+			false,
+		},
+		{
+			"JSON path with wildcard",
+			config.Normalization{IgnoreJSONPaths: []string{"entity.*.updated"}},
+			[]byte(`{"entity": [{"id": 1, "updated": 5}, {"id": 2, "updated": 6}]}`),
+			[]byte(`{"entity": [{"id": 1, "updated": 7}, {"id": 2}]}`),
+			true,
+		},
+		{
+			"JSON path with array index",
+			config.Normalization{IgnoreJSONPaths: []string{"entity.0"}},
+			[]byte(`{"entity": [1, 2]}`),
+			[]byte(`{"entity": [3, 2]}`),
+			true,
+		},
+		{
+			"invalid JSON is not normalized",
+			config.Normalization{IgnoreJSONPaths: []string{"a"}},
+			[]byte(`{"a": 1`),
+			[]byte(`{"a": 2`),
+			false,
+		},
+		{
+			"protobuf field",
+			config.Normalization{IgnoreProtobufFields: []string{"1.3"}},
+			gtfsRealtimeMessage(100, "A"),
+			gtfsRealtimeMessage(200, "A"),
+			true,
+		},
+		{
+			"protobuf field, other data changed",
+			config.Normalization{IgnoreProtobufFields: []string{"1.3"}},
+			gtfsRealtimeMessage(100, "A"),
+			gtfsRealtimeMessage(200, "B"),
+			false,
+		},
+		{
+			"top level protobuf field",
+			config.Normalization{IgnoreProtobufFields: []string{"2"}},
+			gtfsRealtimeMessage(100, "A"),
+			gtfsRealtimeMessage(100, "B"),
+			true,
+		},
+		{
+			"regexp",
+			config.Normalization{StripRegexps: []string{`generated at \d+`}},
+			[]byte("<p>generated at 100</p>"),
+			[]byte("<p>generated at 200</p>"),
+			true,
+		},
+		{
+			"regexp, other data changed",
+			config.Normalization{StripRegexps: []string{`generated at \d+`}},
+			[]byte("<p>generated at 100</p>"),
+			[]byte("<div>generated at 200</div>"),
+			false,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			hash1, err := normalizedHash(&testCase.normalization, bytes.NewReader(testCase.content1))
+			testutil.ErrorOrFail(t, err)
+			hash2, err := normalizedHash(&testCase.normalization, bytes.NewReader(testCase.content2))
+			testutil.ErrorOrFail(t, err)
+
+			if (hash1 == hash2) != testCase.expectSame {
+				t.Errorf("Unexpected hash comparison: %s and %s; expected same=%t", hash1, hash2, testCase.expectSame)
+			}
+		})
+	}
+}
+
+func TestDownloadOnce_Normalization(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	f := feed
+	f.Normalization = config.Normalization{IgnoreProtobufFields: []string{"1.3"}}
+	client := &httpClientForTesting{body: gtfsRealtimeMessage(100, "A")}
+	downloader := newDownloaderWithClient(&f, d, client, returnTime1)
+
+	dFile, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	client.body = gtfsRealtimeMessage(200, "A")
+	dFile2, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+
+	if d.Count() != 1 {
+		t.Errorf("Unexpected number of DFiles in the DStore: %d != 1", d.Count())
+	}
+	if expected := storage.CalculateHash(gtfsRealtimeMessage(100, "A")); dFile.Hash != expected {
+		t.Errorf("Unexpected hash %s; expected the hash of the raw response %s", dFile.Hash, expected)
+	}
+	if dFile2.Hash != dFile.Hash {
+		t.Errorf("Unexpected hash %s of the unchanged download; expected %s", dFile2.Hash, dFile.Hash)
+	}
+	reader, err := d.Get(*dFile)
+	testutil.ErrorOrFail(t, err)
+	content, err := io.ReadAll(reader)
+	testutil.ErrorOrFail(t, err)
+	if !bytes.Equal(content, gtfsRealtimeMessage(100, "A")) {
+		t.Errorf("Unexpected content stored; expected the raw response")
+	}
+
+	client.body = gtfsRealtimeMessage(300, "B")
+	dFile3, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	if d.Count() != 2 {
+		t.Errorf("Unexpected number of DFiles in the DStore: %d != 2", d.Count())
+	}
+	if expected := storage.CalculateHash(gtfsRealtimeMessage(300, "B")); dFile3.Hash != expected {
+		t.Errorf("Unexpected hash %s; expected the hash of the raw response %s", dFile3.Hash, expected)
+	}
+}
@@ -0,0 +1,70 @@
+package download
+
+import (
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+// changeIntervalWeight is the weight given to the most recent sample when updating the
+// moving average of the time between content changes.
+const changeIntervalWeight = 0.3
+
+// dynamicPeriodicity determines the period with which to download a feed based on how
+// often the content of the feed changes.
+//
+// The type keeps an exponentially weighted moving average of the time between content
+// changes. The period is half of this interval, so that on average the feed is downloaded
+// twice for every change, clamped between the feed's minimum and maximum periodicity.
+// While the content is not changing, the time since the last change is used as a lower
+// bound for the interval. This means the period grows steadily while duplicate data is
+// being returned, and shrinks again once the content starts changing.
+type dynamicPeriodicity struct {
+	min        time.Duration
+	max        time.Duration
+	lastChange time.Time
+	interval   time.Duration
+}
+
+func newDynamicPeriodicity(feed *config.Feed) *dynamicPeriodicity {
+	return &dynamicPeriodicity{
+		min: feed.Periodicity,
+		max: feed.MaxPeriodicityActual(),
+	}
+}
+
+// Record records the result of a successful download at the provided time and returns
+// the period to use for subsequent downloads.
+func (p *dynamicPeriodicity) Record(t time.Time, changed bool) time.Duration {
+	if changed {
+		if !p.lastChange.IsZero() {
+			sample := t.Sub(p.lastChange)
+			if p.interval == 0 {
+				p.interval = sample
+			} else {
+				p.interval = time.Duration(
+					changeIntervalWeight*float64(sample) + (1-changeIntervalWeight)*float64(p.interval))
+			}
+		}
+		p.lastChange = t
+	}
+	return p.Period(t)
+}
+
+// Period returns the period to use for downloads at the provided time.
+func (p *dynamicPeriodicity) Period(t time.Time) time.Duration {
+	interval := p.interval
+	if !p.lastChange.IsZero() {
+		if sinceLastChange := t.Sub(p.lastChange); sinceLastChange > interval {
+			interval = sinceLastChange
+		}
+	}
+	period := interval / 2
+	if period < p.min {
+		return p.min
+	}
+	if period > p.max {
+		return p.max
+	}
+	return period
+}
@@ -0,0 +1,65 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+var time0 = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestDynamicPeriodicity_BacksOffWhenUnchanged(t *testing.T) {
+	p := newDynamicPeriodicity(&config.Feed{
+		Periodicity:        5 * time.Second,
+		DynamicPeriodicity: true,
+		MaxPeriodicity:     30 * time.Second,
+	})
+
+	if period := p.Record(time0, true); period != 5*time.Second {
+		t.Errorf("Unexpected initial period %s; expected 5s", period)
+	}
+	if period := p.Record(time0.Add(20*time.Second), false); period != 10*time.Second {
+		t.Errorf("Unexpected period %s; expected 10s", period)
+	}
+	if period := p.Record(time0.Add(10*time.Minute), false); period != 30*time.Second {
+		t.Errorf("Unexpected period %s; expected the maximum 30s", period)
+	}
+}
+
+func TestDynamicPeriodicity_SpeedsUpWhenChanging(t *testing.T) {
+	p := newDynamicPeriodicity(&config.Feed{
+		Periodicity:        5 * time.Second,
+		DynamicPeriodicity: true,
+		MaxPeriodicity:     time.Minute,
+	})
+	p.Record(time0, true)
+	period := p.Record(time0.Add(2*time.Minute), false)
+	if period != time.Minute {
+		t.Errorf("Unexpected period %s; expected the maximum 1m", period)
+	}
+
+	now := time0.Add(2 * time.Minute)
+	for range 20 {
+		now = now.Add(8 * time.Second)
+		newPeriod := p.Record(now, true)
+		if newPeriod > period {
+			t.Errorf("Period increased from %s to %s while content is changing", period, newPeriod)
+		}
+		period = newPeriod
+	}
+	if period != 5*time.Second {
+		t.Errorf("Unexpected period %s; expected 5s", period)
+	}
+}
+
+func TestDynamicPeriodicity_DisabledByDefault(t *testing.T) {
+	p := newDynamicPeriodicity(&config.Feed{
+		Periodicity: 5 * time.Second,
+	})
+	p.Record(time0, true)
+
+	if period := p.Record(time0.Add(time.Hour), false); period != 5*time.Second {
+		t.Errorf("Unexpected period %s; expected 5s", period)
+	}
+}
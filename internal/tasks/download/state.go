@@ -0,0 +1,88 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// stateFileName is the name of the file in the session's state directory in which the
+// state of the downloader is persisted.
+const stateFileName = "download.json"
+
+// loadState restores the state persisted by a previous run of the downloader. If there is
+// no state file, or the state is not persisted, the state is recovered from the newest
+// DFile in the DStore; in this case only the hash and time of the last download are known.
+// The returned error is nil if there is no state to restore.
+func (d *downloader) loadState() error {
+	if d.statePath == "" {
+		return d.loadStateFromDStore()
+	}
+	b, err := os.ReadFile(d.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return d.loadStateFromDStore()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the state file: %w", err)
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("failed to parse the state file %s: %w", d.statePath, err)
+	}
+	d.state = s
+	return nil
+}
+
+func (d *downloader) loadStateFromDStore() error {
+	hours, err := d.dstore.ListNonEmptyHours()
+	if err != nil {
+		return fmt.Errorf("failed to list the DStore: %w", err)
+	}
+	if len(hours) == 0 {
+		return nil
+	}
+	lastHour := hours[0]
+	for _, hr := range hours[1:] {
+		if lastHour.Before(hr) {
+			lastHour = hr
+		}
+	}
+	dFiles, err := d.dstore.ListInHour(lastHour)
+	if err != nil {
+		return fmt.Errorf("failed to list the DStore: %w", err)
+	}
+	var newest *storage.DFile
+	for i := range dFiles {
+		if newest == nil || newest.Time.Before(dFiles[i].Time) {
+			newest = &dFiles[i]
+		}
+	}
+	if newest != nil {
+		d.state = state{LastHash: newest.Hash, LastSuccess: newest.Time}
+	}
+	return nil
+}
+
+// saveState persists the state so that it can be restored after a restart. The state is
+// written to a temporary file first so that a crash never leaves a partial state file.
+func (d *downloader) saveState() error {
+	if d.statePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	tmpPath := d.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return fmt.Errorf("failed to write the state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, d.statePath); err != nil {
+		return fmt.Errorf("failed to write the state file: %w", err)
+	}
+	return nil
+}
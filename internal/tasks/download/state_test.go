@@ -0,0 +1,77 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func TestState_SaveAndLoad(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), stateFileName)
+	client := &httpClientForTesting{
+		body:   content1,
+		header: map[string][]string{"Etag": {`"tag1"`}},
+	}
+	d := dstore.NewInMemoryDStore()
+	downloader := newDownloaderWithClient(&feed, d, client, returnTime1)
+	downloader.statePath = statePath
+	_, err := downloader.downloadOnce(context.Background())
+	testutil.ErrorOrFail(t, err)
+	testutil.ErrorOrFail(t, downloader.saveState())
+
+	// Simulate a restart with the same state file.
+	restarted := newDownloaderWithClient(&feed, d, client, returnTime1)
+	restarted.statePath = statePath
+	testutil.ErrorOrFail(t, restarted.loadState())
+
+	expected := state{
+		LastHash:    hash1,
+		Validators:  map[string]validators{url1: {ETag: `"tag1"`, Hash: hash1}},
+		LastSuccess: time1,
+	}
+	if !reflect.DeepEqual(restarted.state, expected) {
+		t.Errorf("Unexpected state %+v; expected %+v", restarted.state, expected)
+	}
+}
+
+func TestState_LoadFromDStore(t *testing.T) {
+	d := dstore.NewInMemoryDStore()
+	newest := storage.DFile{Prefix: prefix1, Postfix: postfix1, Time: time1, Hash: hash2}
+	for _, dFile := range []storage.DFile{
+		{Prefix: prefix1, Postfix: postfix1, Time: time1.Add(-2 * time.Hour), Hash: hash1},
+		{Prefix: prefix1, Postfix: postfix1, Time: time1.Add(-time.Second), Hash: hash1},
+		newest,
+	} {
+		testutil.ErrorOrFail(t, d.Store(dFile, strings.NewReader("content")))
+	}
+	downloader := newDownloaderWithClient(&feed, d, &httpClientForTesting{}, returnTime1)
+	downloader.statePath = filepath.Join(t.TempDir(), stateFileName)
+
+	testutil.ErrorOrFail(t, downloader.loadState())
+
+	expected := state{LastHash: hash2, LastSuccess: time1}
+	if !reflect.DeepEqual(downloader.state, expected) {
+		t.Errorf("Unexpected state %+v; expected %+v", downloader.state, expected)
+	}
+}
+
+func TestState_CorruptStateFile(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), stateFileName)
+	testutil.ErrorOrFail(t, os.WriteFile(statePath, []byte("{"), 0644))
+	downloader := newDownloaderWithClient(&feed, dstore.NewInMemoryDStore(), &httpClientForTesting{}, returnTime1)
+	downloader.statePath = statePath
+
+	err := downloader.loadState()
+
+	if err == nil {
+		t.Errorf("Expected error; recieved none")
+	}
+}
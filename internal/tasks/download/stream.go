@@ -0,0 +1,266 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/storage"
+)
+
+// messageReader reads messages from the open connection to a streaming feed.
+type messageReader interface {
+	ReadMessage() ([]byte, error)
+	Close() error
+}
+
+// stream keeps a connection to a streaming feed open and stores the messages received,
+// until the context is cancelled. When the connection fails it is re-established, waiting
+// between attempts with exponential backoff. If the connection failed without any messages
+// being received, the next URL of the feed is used.
+func (d *downloader) stream(ctx context.Context, log *slog.Logger) {
+	numURLs := len(d.feed.URLsActual())
+	backoff := d.feed.Stream.InitialBackoffActual()
+	urlIndex := 0
+	for {
+		received, err := d.receive(ctx, urlIndex, false, log)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error(fmt.Sprintf("Stream connection failed: %s", err))
+		if received {
+			backoff = d.feed.Stream.InitialBackoffActual()
+		} else {
+			urlIndex = (urlIndex + 1) % numURLs
+		}
+		// As with retries, we wait for a random duration between half the backoff and the
+		// full backoff.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff = min(2*backoff, d.feed.Stream.MaxBackoffActual())
+	}
+}
+
+// receiveOnce connects to the streaming feed and returns once the first batch of messages
+// has been stored. Each URL of the feed is tried once.
+func (d *downloader) receiveOnce(ctx context.Context, log *slog.Logger) error {
+	var err error
+	for urlIndex := range d.feed.URLsActual() {
+		if _, err = d.receive(ctx, urlIndex, true, log); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// receive connects to the URL and stores the messages received until the connection fails
+// or the context is cancelled. The boolean return value is true if any messages were
+// received. If once is true, receive returns after the first batch of messages is stored.
+func (d *downloader) receive(ctx context.Context, urlIndex int, once bool, log *slog.Logger) (bool, error) {
+	reader, err := d.connect(ctx, urlIndex)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		_ = reader.Close()
+	}()
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			message, err := reader.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var batch [][]byte
+	var flush <-chan time.Time
+	received := false
+	storeBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := d.storeMessages(batch)
+		monitoring.RecordDownload(d.feed, err)
+		batch = nil
+		flush = nil
+		if err != nil {
+			return err
+		}
+		return d.saveState()
+	}
+	for {
+		select {
+		case message := <-messages:
+			received = true
+			batch = append(batch, message)
+			if d.feed.Stream.BatchWindow > 0 {
+				if flush == nil {
+					flush = time.After(d.feed.Stream.BatchWindow)
+				}
+				continue
+			}
+		case <-flush:
+		case err := <-readErr:
+			if storeErr := storeBatch(); storeErr != nil {
+				log.Error(fmt.Sprintf("Error storing messages: %s", storeErr))
+			}
+			return received, err
+		case <-ctx.Done():
+			if storeErr := storeBatch(); storeErr != nil {
+				log.Error(fmt.Sprintf("Error storing messages: %s", storeErr))
+			}
+			return received, ctx.Err()
+		}
+		err := storeBatch()
+		if once {
+			return received, err
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("Error storing messages: %s", err))
+		}
+	}
+}
+
+// storeMessages stores a batch of messages as a single DFile. The messages are separated
+// by newlines.
+func (d *downloader) storeMessages(messages [][]byte) (*storage.DFile, error) {
+	body, err := d.bufferBody(bytes.NewReader(bytes.Join(messages, []byte("\n"))))
+	if err != nil {
+		return nil, err
+	}
+	defer body.remove()
+	return d.store(body, "", nil)
+}
+
+// connect opens a connection to the URL at the index.
+func (d *downloader) connect(ctx context.Context, urlIndex int) (messageReader, error) {
+	if d.feed.SourceActual() == config.SourceWebSocket {
+		conn, err := d.dialWebSocket(ctx, urlIndex)
+		monitoring.RecordURLRequest(d.feed, urlIndex, err == nil)
+		if err != nil {
+			return nil, err
+		}
+		return &webSocketReader{conn: conn}, nil
+	}
+	resp, err := d.sendToURL(ctx, urlIndex)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("non-200 status recieved: %d / %s", resp.StatusCode, resp.Status)
+	}
+	return &sseReader{body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+func (d *downloader) dialWebSocket(ctx context.Context, urlIndex int) (*websocket.Conn, error) {
+	req, err := d.newRequest(urlIndex)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.rateLimits.Wait(ctx, d.feed, req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	if d.tokens != nil {
+		token, err := d.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain an access token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	dialer := d.dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	conn, resp, err := dialer.DialContext(ctx, req.URL.String(), req.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to open the WebSocket connection: %w (status %s)", err, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to open the WebSocket connection: %w", err)
+	}
+	return conn, nil
+}
+
+// webSocketReader reads text and binary messages from a WebSocket connection.
+type webSocketReader struct {
+	conn *websocket.Conn
+}
+
+func (w *webSocketReader) ReadMessage() ([]byte, error) {
+	_, message, err := w.conn.ReadMessage()
+	return message, err
+}
+
+func (w *webSocketReader) Close() error {
+	return w.conn.Close()
+}
+
+// sseReader reads the data of Server-Sent Events from a text/event-stream response body.
+// Event types and IDs are ignored.
+type sseReader struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+func (s *sseReader) ReadMessage() ([]byte, error) {
+	var data []byte
+	hasData := false
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			// A blank line dispatches the event. Events without data are skipped.
+			if hasData {
+				return data, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		if field != "data" {
+			continue
+		}
+		if hasData {
+			data = append(data, '\n')
+		}
+		data = append(data, strings.TrimPrefix(value, " ")...)
+		hasData = true
+	}
+}
+
+func (s *sseReader) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,203 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage/dstore"
+	"github.com/jamespfennell/hoard/internal/util/testutil"
+)
+
+func TestSSEReader(t *testing.T) {
+	stream := ": comment\n" +
+		"data: first\n\n" +
+		"event: update\r\nid: 2\r\ndata: line 1\r\ndata:line 2\r\n\r\n" +
+		"event: ping\n\n" +
+		"data: last\n\n"
+	reader := &sseReader{body: io.NopCloser(nil), r: bufio.NewReader(strings.NewReader(stream))}
+
+	var messages []string
+	for {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			break
+		}
+		messages = append(messages, string(message))
+	}
+
+	expected := []string{"first", "line 1\nline 2", "last"}
+	if !equalStrings(messages, expected) {
+		t.Errorf("Unexpected messages %q; expected %q", messages, expected)
+	}
+}
+
+// streamServer is an SSE or WebSocket server that sends a fixed list of messages on each
+// connection and then either closes the connection or keeps it open.
+type streamServer struct {
+	messages  []string
+	keepOpen  bool
+	mu        sync.Mutex
+	numConns  int
+	websocket bool
+}
+
+func (s *streamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.numConns++
+	s.mu.Unlock()
+	if s.websocket {
+		s.serveWebSocket(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, message := range s.messages {
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", message)
+	}
+	w.(http.Flusher).Flush()
+	if s.keepOpen {
+		<-r.Context().Done()
+	}
+}
+
+func (s *streamServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for _, message := range s.messages {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			return
+		}
+	}
+	if s.keepOpen {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+func (s *streamServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numConns
+}
+
+func newStreamingFeed(t *testing.T, server *streamServer) *config.Feed {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	f := feed
+	f.Source = config.SourceSSE
+	f.URL = httpServer.URL
+	if server.websocket {
+		f.Source = config.SourceWebSocket
+		f.URL = "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	}
+	return &f
+}
+
+func storedContents(t *testing.T, d *dstore.InMemoryDStore) []string {
+	var contents []string
+	hours, err := d.ListNonEmptyHours()
+	testutil.ErrorOrFail(t, err)
+	for _, hr := range hours {
+		dFiles, err := d.ListInHour(hr)
+		testutil.ErrorOrFail(t, err)
+		for _, dFile := range dFiles {
+			reader, err := d.Get(dFile)
+			testutil.ErrorOrFail(t, err)
+			b, err := io.ReadAll(reader)
+			testutil.ErrorOrFail(t, err)
+			contents = append(contents, string(b))
+		}
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func equalStrings(a, b []string) bool {
+	return strings.Join(a, "|") == strings.Join(b, "|")
+}
+
+func TestReceive(t *testing.T) {
+	for _, useWebSocket := range []bool{false, true} {
+		t.Run(fmt.Sprintf("websocket=%t", useWebSocket), func(t *testing.T) {
+			server := &streamServer{messages: []string{"a", "b", "b", "c"}, websocket: useWebSocket}
+			d := dstore.NewInMemoryDStore()
+			downloader := newDownloaderWithClient(newStreamingFeed(t, server), d, &http.Client{}, time.Now)
+
+			received, err := downloader.receive(context.Background(), 0, false, slog.Default())
+
+			if err == nil {
+				t.Errorf("Expected error when the connection closed; recieved none")
+			}
+			if !received {
+				t.Errorf("Expected messages to be received")
+			}
+			// The repeated message is de-duplicated.
+			expected := []string{"a", "b", "c"}
+			if actual := storedContents(t, d); !equalStrings(actual, expected) {
+				t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+			}
+		})
+	}
+}
+
+func TestReceive_BatchWindow(t *testing.T) {
+	for _, useWebSocket := range []bool{false, true} {
+		t.Run(fmt.Sprintf("websocket=%t", useWebSocket), func(t *testing.T) {
+			server := &streamServer{messages: []string{"a", "b"}, keepOpen: true, websocket: useWebSocket}
+			f := newStreamingFeed(t, server)
+			f.Stream.BatchWindow = 100 * time.Millisecond
+			d := dstore.NewInMemoryDStore()
+			downloader := newDownloaderWithClient(f, d, &http.Client{}, time.Now)
+
+			err := downloader.receiveOnce(context.Background(), slog.Default())
+			testutil.ErrorOrFail(t, err)
+
+			expected := []string{"a\nb"}
+			if actual := storedContents(t, d); !equalStrings(actual, expected) {
+				t.Errorf("Unexpected stored contents %q; expected %q", actual, expected)
+			}
+		})
+	}
+}
+
+func TestStream_Reconnects(t *testing.T) {
+	server := &streamServer{messages: []string{"a"}}
+	f := newStreamingFeed(t, server)
+	f.Stream.InitialBackoff = time.Millisecond
+	downloader := newDownloaderWithClient(f, dstore.NewInMemoryDStore(), &http.Client{}, time.Now)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		downloader.stream(ctx, slog.Default())
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.connections() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if server.connections() < 3 {
+		t.Errorf("Unexpected number of connections %d; expected the stream to reconnect", server.connections())
+	}
+}
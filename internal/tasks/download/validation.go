@@ -0,0 +1,187 @@
+package download
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/jamespfennell/hoard/config"
+)
+
+// validationError is returned when a response fails the validation rules of the feed.
+type validationError struct {
+	reason string
+}
+
+func (err validationError) Error() string {
+	return fmt.Sprintf("response failed validation: %s", err.reason)
+}
+
+func invalid(format string, a ...any) error {
+	return validationError{reason: fmt.Sprintf(format, a...)}
+}
+
+// validateResponse checks the response against the validation rules of the feed. A nil
+// error is returned if the response is valid.
+//
+// The body is read from the ReadSeeker, possibly multiple times, without loading all of
+// it into memory.
+func validateResponse(v *config.Validation, contentType string, body io.ReadSeeker, size int64) error {
+	if len(v.ContentTypes) > 0 && !contentTypeAllowed(v.ContentTypes, contentType) {
+		return invalid("content type %q is not allowed", contentType)
+	}
+	if size < v.MinSize {
+		return invalid("size %d is less than the minimum size %d", size, v.MinSize)
+	}
+	if v.MaxSize > 0 && size > v.MaxSize {
+		return invalid("size %d is greater than the maximum size %d", size, v.MaxSize)
+	}
+	if v.JSON {
+		ok, err := isValidJSON(body)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return invalid("body is not valid JSON")
+		}
+	}
+	if v.Protobuf {
+		ok, err := isValidProtobuf(body)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return invalid("body is not a valid protocol buffer message")
+		}
+	}
+	if r := v.MatchParsed(); r != nil {
+		matched, err := matches(r, body)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return invalid("body does not match %q", v.Match)
+		}
+	}
+	if r := v.NotMatchParsed(); r != nil {
+		matched, err := matches(r, body)
+		if err != nil {
+			return err
+		}
+		if matched {
+			return invalid("body matches %q", v.NotMatch)
+		}
+	}
+	return nil
+}
+
+func contentTypeAllowed(allowed []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(r *regexp.Regexp, body io.ReadSeeker) (bool, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return r.MatchReader(bufio.NewReader(body)), nil
+}
+
+// isValidJSON returns true if the body consists of exactly one JSON value.
+func isValidJSON(body io.ReadSeeker) (bool, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	decoder := json.NewDecoder(body)
+	depth := 0
+	numValues := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return numValues == 1 && depth == 0, nil
+		}
+		if err != nil {
+			return false, nil
+		}
+		if depth == 0 {
+			numValues++
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// isValidProtobuf returns true if the body can be parsed as a protocol buffer message in the
+// wire format. Because the schema is not known, this only checks the structure of the message.
+// Nested messages are not checked as they cannot be distinguished from strings and bytes.
+func isValidProtobuf(body io.ReadSeeker) (bool, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(body)
+	var openGroups []uint64
+	for {
+		tag, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return len(openGroups) == 0, nil
+		}
+		if err != nil {
+			return false, nil
+		}
+		num, typ := tag>>3, tag&7
+		if num < 1 || num > 1<<29-1 {
+			return false, nil
+		}
+		switch typ {
+		case 0: // varint
+			_, err = binary.ReadUvarint(r)
+		case 1: // 64-bit
+			_, err = r.Discard(8)
+		case 2: // length-delimited
+			var length uint64
+			length, err = binary.ReadUvarint(r)
+			if length > math.MaxInt64 {
+				return false, nil
+			}
+			if err == nil {
+				_, err = io.CopyN(io.Discard, r, int64(length))
+			}
+		case 3: // start group
+			openGroups = append(openGroups, num)
+		case 4: // end group
+			if len(openGroups) == 0 || openGroups[len(openGroups)-1] != num {
+				return false, nil
+			}
+			openGroups = openGroups[:len(openGroups)-1]
+		case 5: // 32-bit
+			_, err = r.Discard(4)
+		default:
+			return false, nil
+		}
+		if err != nil {
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package download
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jamespfennell/hoard/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestValidateResponse(t *testing.T) {
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.VarintType)
+	message = protowire.AppendVarint(message, 150)
+	message = protowire.AppendTag(message, 2, protowire.BytesType)
+	message = protowire.AppendString(message, "hello")
+	var protobufWithGroup []byte
+	protobufWithGroup = protowire.AppendTag(protobufWithGroup, 3, protowire.StartGroupType)
+	protobufWithGroup = protowire.AppendTag(protobufWithGroup, 1, protowire.Fixed32Type)
+	protobufWithGroup = protowire.AppendFixed32(protobufWithGroup, 7)
+	protobufWithGroup = protowire.AppendTag(protobufWithGroup, 3, protowire.EndGroupType)
+
+	for _, testCase := range []struct {
+		name        string
+		validation  config.Validation
+		contentType string
+		content     []byte
+		expectValid bool
+	}{
+		{"no rules", config.Validation{}, "", []byte("anything"), true},
+		{"allowed content type", config.Validation{ContentTypes: []string{"application/json"}}, "application/json; charset=utf-8", []byte("{}"), true},
+		{"wildcard content type", config.Validation{ContentTypes: []string{"application/*"}}, "application/x-protobuf", []byte("{}"), true},
+		{"disallowed content type", config.Validation{ContentTypes: []string{"application/json"}}, "text/html", []byte("{}"), false},
+		{"missing content type", config.Validation{ContentTypes: []string{"application/json"}}, "", []byte("{}"), false},
+		{"too small", config.Validation{MinSize: 1}, "", []byte{}, false},
+		{"too big", config.Validation{MaxSize: 2}, "", []byte("abc"), false},
+		{"valid JSON", config.Validation{JSON: true}, "", []byte(`{"a": [1, 2]}`), true},
+		{"truncated JSON", config.Validation{JSON: true}, "", []byte(`{"a": [1, 2`), false},
+		{"malformed JSON", config.Validation{JSON: true}, "", []byte(`{"a" 1}`), false},
+		{"multiple JSON values", config.Validation{JSON: true}, "", []byte(`{} {}`), false},
+		{"empty JSON", config.Validation{JSON: true}, "", []byte(``), false},
+		{"valid protobuf", config.Validation{Protobuf: true}, "", message, true},
+		{"protobuf with group", config.Validation{Protobuf: true}, "", protobufWithGroup, true},
+		{"protobuf with unclosed group", config.Validation{Protobuf: true}, "", protobufWithGroup[:2], false},
+		{"truncated protobuf", config.Validation{Protobuf: true}, "", message[:len(message)-2], false},
+		{"HTML as protobuf", config.Validation{Protobuf: true}, "", []byte("<html>Error</html>"), false},
+		{"match", config.Validation{Match: `"vehicles"`}, "", []byte(`{"vehicles": []}`), true},
+		{"no match", config.Validation{Match: `"vehicles"`}, "", []byte(`{"error": "down"}`), false},
+		{"not match", config.Validation{NotMatch: `(?i)<html`}, "", []byte(`{"vehicles": []}`), true},
+		{"matches not match", config.Validation{NotMatch: `(?i)<html`}, "", []byte(`<HTML>Error</HTML>`), false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := validateResponse(&testCase.validation, testCase.contentType,
+				bytes.NewReader(testCase.content), int64(len(testCase.content)))
+
+			if testCase.expectValid && err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if !testCase.expectValid && err == nil {
+				t.Errorf("Expected validation error; recieved none")
+			}
+		})
+	}
+}
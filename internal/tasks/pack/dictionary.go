@@ -0,0 +1,116 @@
+package pack
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/jamespfennell/hoard/internal/dictionary"
+	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/hour"
+	"github.com/jamespfennell/hoard/internal/tasks"
+)
+
+// trainDictionaryIfNeeded trains a new version of the feed's compression dictionary if the
+// feed uses a dictionary and either there is no dictionary yet or the latest version is
+// older than the retrain period. The dictionary is trained on the most recent DFiles in the
+// provided hours of the local DStore, which are about to be packed.
+//
+// The boolean return value is true if a new version was trained.
+func trainDictionaryIfNeeded(session *tasks.Session, hours []hour.Hour, now time.Time) (bool, error) {
+	feed := session.Feed()
+	if !feed.Compression.UsesDictionary() {
+		return false, nil
+	}
+	dictionaries := session.Dictionaries()
+	if dictionaries == nil {
+		return false, fmt.Errorf("the dictionary storage is not available")
+	}
+	versions, err := dictionaries.Versions()
+	if err != nil {
+		return false, err
+	}
+	if len(versions) > 0 && now.Sub(versions[len(versions)-1].Created) < feed.Dictionary.RetrainPeriodActual() {
+		return false, nil
+	}
+	samples, err := readSamples(session.LocalDStore(), hours, feed.Dictionary.SamplesActual(),
+		samplesSizeFactor*feed.Dictionary.MaxSizeActual())
+	if err != nil {
+		return false, err
+	}
+	if len(samples) < dictionary.MinSamples {
+		session.Log().Debug(fmt.Sprintf("Not training a compression dictionary because there are only %d downloaded files", len(samples)))
+		return false, nil
+	}
+	content, err := dictionary.Train(samples, feed.Dictionary.MaxSizeActual())
+	if err != nil {
+		return false, fmt.Errorf("failed to train the compression dictionary: %w", err)
+	}
+	version, err := dictionaries.Add(content, now)
+	if err != nil {
+		return false, err
+	}
+	session.Log().Info(fmt.Sprintf("Trained version %s of the compression dictionary on %d downloaded files", version, len(samples)))
+	return true, nil
+}
+
+// maxSampleSize is the maximum number of bytes of a DFile that are used as a sample. Larger
+// DFiles are truncated.
+const maxSampleSize = 1 << 20
+
+// samplesSizeFactor is the maximum total size of the samples, as a multiple of the maximum
+// size of the dictionary. The zstd documentation recommends training on around 100 times
+// as much data as the size of the dictionary.
+const samplesSizeFactor = 100
+
+// readSamples reads the content of the most recent DFiles in the hours, up to the maximum
+// number of samples and the maximum total size of the samples in bytes. Each sample is at
+// most maxSampleSize bytes, so the memory used is bounded even if the DFiles are large.
+func readSamples(dStore storage.DStore, hours []hour.Hour, maxSamples int, maxTotalSize int) ([][]byte, error) {
+	hours = append([]hour.Hour(nil), hours...)
+	sort.Slice(hours, func(i, j int) bool {
+		return hours[j].Before(hours[i])
+	})
+	var samples [][]byte
+	totalSize := 0
+	for _, hr := range hours {
+		dFiles, err := dStore.ListInHour(hr)
+		if err != nil {
+			return nil, err
+		}
+		storage.Sort(dFiles)
+		for i := len(dFiles) - 1; i >= 0; i-- {
+			if len(samples) >= maxSamples || totalSize >= maxTotalSize {
+				return samples, nil
+			}
+			sample, err := readDFile(dStore, dFiles[i], min(maxSampleSize, maxTotalSize-totalSize))
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, sample)
+			totalSize += len(sample)
+		}
+	}
+	return samples, nil
+}
+
+// readDFile reads at most maxSize bytes of the DFile.
+func readDFile(dStore storage.ReadableDStore, dFile storage.DFile, maxSize int) ([]byte, error) {
+	reader, err := dStore.Get(dFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, int64(maxSize)))
+}
+
+func recordDictionaryTraining(session *tasks.Session, trained bool, err error) {
+	if err != nil {
+		session.Log().Error(fmt.Sprintf("Error while training the compression dictionary: %s", err))
+	}
+	if trained || err != nil {
+		monitoring.RecordDictionaryTraining(session.Feed(), err)
+	}
+}
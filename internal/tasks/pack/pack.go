@@ -2,6 +2,13 @@
 //
 // This task searches for raw downloaded files in local disk, and collects them
 // into compressed archive files.
+//
+// If the feed uses a compression dictionary, the task also periodically trains a new version
+// of the dictionary on the downloaded files before packing them.
+//
+// The task can also create partial archive files for the bucket that is currently in
+// progress. In this case the downloaded files are not deleted, as they are packed again into
+// the final archive file once the bucket has ended.
 package pack
 
 import (
@@ -10,17 +17,23 @@ import (
 
 	"github.com/jamespfennell/hoard/internal/archive"
 	"github.com/jamespfennell/hoard/internal/monitoring"
+	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/storage/hour"
 	"github.com/jamespfennell/hoard/internal/tasks"
 	"github.com/jamespfennell/hoard/internal/util"
 )
 
-// RunPeriodically runs the pack task periodically, with the period specified
-// in the second input argument.
+// RunPeriodically runs the pack task periodically: shortly after every hour or, if the
+// feed uses sub-hour buckets, shortly after every bucket.
 func RunPeriodically(session *tasks.Session) {
 	feed := session.Feed()
 	session.Log().Info("Starting periodic packer")
-	ticker := util.NewPerHourTicker(time.Minute * 2)
+	var ticker util.Ticker
+	if width := feed.BucketWidthActual(); width < time.Hour {
+		ticker = util.NewAlignedTicker(width, func(time.Duration) time.Duration { return width / 10 })
+	} else {
+		ticker = util.NewPerHourTicker(time.Minute * 2)
+	}
 	defer ticker.Stop()
 	for {
 		select {
@@ -39,42 +52,95 @@ func RunPeriodically(session *tasks.Session) {
 
 // RunOnce runs the pack task once.
 //
-// If skipCurrentHour is true, any DFiles created in the current hour will be ignored.
-func RunOnce(session *tasks.Session, skipCurrentHour bool) error {
+// If skipCurrentBucket is true, any DFiles created in the current bucket (usually the
+// current hour) will be ignored.
+func RunOnce(session *tasks.Session, skipCurrentBucket bool) error {
 	dStore := session.LocalDStore()
 	hours, err := dStore.ListNonEmptyHours()
 	if err != nil {
 		return err
 	}
-	currentHour := hour.Now()
+	// Training errors are not returned, because the DFiles can still be packed using an
+	// existing dictionary or, if there is none, without a dictionary.
+	trained, err := trainDictionaryIfNeeded(session, hours, time.Now())
+	recordDictionaryTraining(session, trained, err)
+	width := session.Feed().BucketWidthActual()
+	currentBucket := hour.FromTimeWithWidth(time.Now(), width)
 	var errs []error
 	for _, hr := range hours {
-		if skipCurrentHour && hr == currentHour {
+		if skipCurrentBucket && hr == currentBucket {
 			session.LogWithHour(hr).Debug("Skipping packing the current hour")
 			continue
 		}
 		session.LogWithHour(hr).Debug("Packing hour")
-		errs = append(errs, packHour(session, hr))
+		var skipBucket *hour.Hour
+		if skipCurrentBucket && hr == currentBucket.ContainingHour() {
+			skipBucket = &currentBucket
+		}
+		errs = append(errs, packHour(session, hr, skipBucket))
 	}
 	return util.NewMultipleError(errs...)
 }
 
-func packHour(session *tasks.Session, hour hour.Hour) error {
+// packHour packs the DFiles of the hour into one AFile per bucket of the feed. DFiles in the
+// skipped bucket, if non-nil, are not packed.
+func packHour(session *tasks.Session, hr hour.Hour, skipBucket *hour.Hour) error {
+	width := session.Feed().BucketWidthActual()
 	dStore := session.LocalDStore()
-	dFiles, err := dStore.ListInHour(hour)
+	dFiles, err := dStore.ListInHour(hr)
 	if err != nil {
 		return err
 	}
-	_, incorporatedDFiles, err := archive.CreateFromDFiles(session.Feed(), dFiles, dStore, session.LocalAStore())
+	bucketToDFiles := map[hour.Hour][]storage.DFile{}
+	for _, dFile := range dFiles {
+		bucket := hour.FromTimeWithWidth(dFile.Time, width)
+		if skipBucket != nil && bucket == *skipBucket {
+			continue
+		}
+		bucketToDFiles[bucket] = append(bucketToDFiles[bucket], dFile)
+	}
+	var errs []error
+	for bucket, dFiles := range bucketToDFiles {
+		_, incorporatedDFiles, err := archive.CreateFromDFiles(session.Feed(), dFiles, dStore, session.LocalAStore())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		session.LogWithHour(bucket).Debug(fmt.Sprintf("Deleting %d downloaded files", len(incorporatedDFiles)))
+		for _, dFile := range incorporatedDFiles {
+			if err := dStore.Delete(dFile); err != nil {
+				monitoring.RecordPackFileErrors(session.Feed(), err)
+				session.LogWithHour(bucket).Error(fmt.Sprintf("Failed to delete DFile %s: %s", dFile, err))
+			}
+		}
+	}
+	return util.NewMultipleError(errs...)
+}
+
+// RunPartialOnce packs the DFiles of the current bucket into a partial AFile, which is written
+// to the target AStore. The DFiles are not deleted. The boolean return value is false if there
+// are no DFiles in the current bucket, in which case no AFile is created.
+func RunPartialOnce(session *tasks.Session, targetAStore storage.WritableAStore) (storage.AFile, bool, error) {
+	width := session.Feed().BucketWidthActual()
+	currentBucket := hour.FromTimeWithWidth(time.Now(), width)
+	dStore := session.LocalDStore()
+	dFiles, err := dStore.ListInHour(currentBucket.ContainingHour())
 	if err != nil {
-		return err
+		return storage.AFile{}, false, err
 	}
-	session.LogWithHour(hour).Debug(fmt.Sprintf("Deleting %d downloaded files", len(incorporatedDFiles)))
-	for _, dFile := range incorporatedDFiles {
-		if err := dStore.Delete(dFile); err != nil {
-			monitoring.RecordPackFileErrors(session.Feed(), err)
-			session.LogWithHour(hour).Error(fmt.Sprintf("Failed to delete DFile %s: %s", dFile, err))
+	var dFilesInBucket []storage.DFile
+	for _, dFile := range dFiles {
+		if hour.FromTimeWithWidth(dFile.Time, width) == currentBucket {
+			dFilesInBucket = append(dFilesInBucket, dFile)
 		}
 	}
-	return nil
+	if len(dFilesInBucket) == 0 {
+		return storage.AFile{}, false, nil
+	}
+	session.LogWithHour(currentBucket).Debug(fmt.Sprintf("Packing %d downloaded files into a partial archive", len(dFilesInBucket)))
+	aFile, _, err := archive.CreatePartialFromDFiles(session.Feed(), dFilesInBucket, dStore, targetAStore)
+	if err != nil {
+		return storage.AFile{}, false, err
+	}
+	return aFile, true, nil
 }
@@ -2,9 +2,13 @@ package pack
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage"
+	"github.com/jamespfennell/hoard/internal/storage/hour"
 	"github.com/jamespfennell/hoard/internal/tasks"
 	"github.com/jamespfennell/hoard/internal/util/testutil"
 )
@@ -23,7 +27,7 @@ func TestPackHour(t *testing.T) {
 	errorOrFail(t, d.Store(data2.DFile, bytes.NewReader(data2.Content)))
 	errorOrFail(t, d.Store(data3.DFile, bytes.NewReader(data3.Content)))
 
-	errorOrFail(t, packHour(session, data1.Hour))
+	errorOrFail(t, packHour(session, data1.Hour, nil))
 
 	// TODO
 	// List all hours, ensure it's the hour we expect
@@ -32,8 +36,98 @@ func TestPackHour(t *testing.T) {
 	// Verify that it has 3 files and that the data is expected
 }
 
+func TestPackHour_SubHourBuckets(t *testing.T) {
+	session := tasks.NewInMemorySession(&config.Feed{BucketWidth: 5 * time.Minute})
+	d := session.LocalDStore()
+	for _, data := range testutil.Data {
+		errorOrFail(t, d.Store(data.DFile, bytes.NewReader(data.Content)))
+	}
+
+	errorOrFail(t, packHour(session, testutil.Data[0].Hour, nil))
+
+	searchResults, err := session.LocalAStore().Search(nil, testutil.Data[0].Hour)
+	errorOrFail(t, err)
+	buckets := map[hour.Hour]int{}
+	for _, searchResult := range searchResults {
+		buckets[searchResult.Hour] += len(searchResult.AFiles)
+	}
+	expected := map[hour.Hour]int{
+		hour.SubHourDate(2000, 1, 2, 3, 0): 1,
+		hour.SubHourDate(2000, 1, 2, 3, 5): 1,
+	}
+	if len(buckets) != len(expected) {
+		t.Fatalf("Unexpected buckets %v; expected %v", buckets, expected)
+	}
+	for bucket, n := range expected {
+		if buckets[bucket] != n {
+			t.Errorf("Unexpected number of AFiles %d in bucket %s; expected %d", buckets[bucket], bucket, n)
+		}
+	}
+}
+
 func errorOrFail(t *testing.T, err error) {
 	if err != nil {
 		t.Fatalf("Unexpected error '%s'", err)
 	}
 }
+
+func TestRunOnce_TrainsDictionary(t *testing.T) {
+	feed := &config.Feed{Compression: config.NewSpecWithLevel(config.ZstdDict, 3)}
+	session := tasks.NewInMemorySession(feed)
+	d := session.LocalDStore()
+	start := time.Date(2000, 1, 2, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		dFile := storage.DFile{
+			Prefix: "a",
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Hash:   storage.CalculateHash([]byte{byte(i)}),
+		}
+		content := fmt.Sprintf(`{"id":%d,"status":"ok","values":[%d,%d]}`, i, i*i, i%3)
+		errorOrFail(t, d.Store(dFile, bytes.NewReader([]byte(content))))
+	}
+
+	errorOrFail(t, RunOnce(session, false))
+
+	version, _, err := session.Dictionaries().Latest()
+	errorOrFail(t, err)
+	if version == "" {
+		t.Fatalf("Expected a dictionary to be trained")
+	}
+	aFiles, err := storage.ListAFilesInHour(session.LocalAStore(), hour.FromTime(start))
+	errorOrFail(t, err)
+	if len(aFiles) != 1 || aFiles[0].Compression.Format != config.ZstdDict || aFiles[0].Dictionary != version {
+		t.Errorf("Unexpected AFiles %v; expected one AFile compressed using dictionary version %s", aFiles, version)
+	}
+
+	// The dictionary is not retrained until the retrain period has passed.
+	trained, err := trainDictionaryIfNeeded(session, []hour.Hour{hour.FromTime(start)}, time.Now())
+	errorOrFail(t, err)
+	if trained {
+		t.Errorf("Unexpected retraining of the dictionary")
+	}
+}
+
+func TestReadSamples_SizeLimits(t *testing.T) {
+	d := tasks.NewInMemorySession(feed).LocalDStore()
+	start := time.Date(2000, 1, 2, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		dFile := storage.DFile{
+			Prefix: "a",
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Hash:   storage.CalculateHash([]byte{byte(i)}),
+		}
+		errorOrFail(t, d.Store(dFile, bytes.NewReader(make([]byte, maxSampleSize+10))))
+	}
+
+	samples, err := readSamples(d, []hour.Hour{hour.FromTime(start)}, 100, 2*maxSampleSize+5)
+	errorOrFail(t, err)
+
+	var sizes []int
+	for _, sample := range samples {
+		sizes = append(sizes, len(sample))
+	}
+	expected := []int{maxSampleSize, maxSampleSize, 5}
+	if fmt.Sprint(sizes) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected sample sizes %v; expected %v", sizes, expected)
+	}
+}
@@ -94,10 +94,13 @@ func (w *StatusWriter) refresh() {
 
 // RunOnceWithoutUnpacking retrieves remote data and stores it locally without
 // unpacking the archives. That is, the compressed archive files are just stored.
+//
+// Partial archives, which contain data for buckets that were in progress when the archives
+// were created, are skipped unless includePartials is true.
 func RunOnceWithoutUnpacking(session *tasks.Session, writer *StatusWriter,
-	start hour.Hour, end hour.Hour, targetAStore storage.WritableAStore) error {
+	start hour.Hour, end hour.Hour, includePartials bool, targetAStore storage.WritableAStore) error {
 	return run(
-		session, writer, start, end,
+		session, writer, start, end, includePartials,
 		func(aFile storage.AFile) error {
 			return storage.CopyAFile(session.RemoteAStore(), targetAStore, aFile)
 		},
@@ -105,10 +108,10 @@ func RunOnceWithoutUnpacking(session *tasks.Session, writer *StatusWriter,
 }
 
 // RunOnceWithUnpacking retrieves remote data, unpacks the archive, and stores it
-// locally.
+// locally. Partial archives are skipped unless includePartials is true.
 func RunOnceWithUnpacking(session *tasks.Session, writer *StatusWriter,
-	start hour.Hour, end hour.Hour, targetDStore storage.WritableDStore) error {
-	return run(session, writer, start, end,
+	start hour.Hour, end hour.Hour, includePartials bool, targetDStore storage.WritableDStore) error {
+	return run(session, writer, start, end, includePartials,
 		func(aFile storage.AFile) error {
 			return archive.Unpack(aFile, session.RemoteAStore(), targetDStore)
 		},
@@ -116,7 +119,7 @@ func RunOnceWithUnpacking(session *tasks.Session, writer *StatusWriter,
 }
 
 func run(session *tasks.Session,
-	writer *StatusWriter, start hour.Hour, end hour.Hour,
+	writer *StatusWriter, start hour.Hour, end hour.Hour, includePartials bool,
 	fn func(file storage.AFile) error) error {
 	searchResults, err := session.RemoteAStore().Search(&start, end)
 	if err != nil {
@@ -126,6 +129,9 @@ func run(session *tasks.Session,
 	var aFiles []storage.AFile
 	for _, searchResult := range searchResults {
 		for thisAFiles := range searchResult.AFiles {
+			if thisAFiles.Partial && !includePartials {
+				continue
+			}
 			aFiles = append(aFiles, thisAFiles)
 		}
 	}
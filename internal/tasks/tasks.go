@@ -9,8 +9,12 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/dictionary"
+	"github.com/jamespfennell/hoard/internal/ratelimit"
+	"github.com/jamespfennell/hoard/internal/replicas"
 	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/storage/astore"
 	"github.com/jamespfennell/hoard/internal/storage/dstore"
@@ -21,6 +25,9 @@ import (
 const DownloadsSubDir = "downloads"
 const ArchivesSubDir = "archives"
 const TmpSubDir = "tmp"
+const QuarantineSubDir = "quarantine"
+const StateSubDir = "state"
+const DictionariesSubDir = "dictionaries"
 
 // Session contains all the necessary pieces for performing tasks in Hoard. Each task takes
 // the Session as an input parameter and then uses the pieces it needs.
@@ -38,13 +45,20 @@ type Session struct {
 	localDStore      storage.DStore
 	localAStore      storage.AStore
 	remoteAStore     *astore.ReplicatedAStore
+	quarantineDStore storage.DStore
+	errorsDStore     storage.DStore
+	rateLimits       *ratelimit.Registry
+	replicas         *replicas.Coordinator
+	dictionariesMu   sync.Mutex
+	dictionaries     *dictionary.Store
 }
 
 // NewSession creates a new Session for production code.
 //
 // In this session, local stores are based on the filesystem, rooted at the provided workspace.
 // The remote AStore is based on the remote object storage configured in the configuration file.
-func NewSession(feed *config.Feed, c *config.Config, log *slog.Logger, ctx context.Context, enableMonitoring bool) *Session {
+// The rate limits and replica coordinator are shared by the sessions of all feeds.
+func NewSession(feed *config.Feed, c *config.Config, rateLimits *ratelimit.Registry, replicas *replicas.Coordinator, log *slog.Logger, ctx context.Context, enableMonitoring bool) *Session {
 	return &Session{
 		feed:             feed,
 		objectStorage:    c.ObjectStorage,
@@ -55,23 +69,30 @@ func NewSession(feed *config.Feed, c *config.Config, log *slog.Logger, ctx conte
 		localDStore:      nil,
 		localAStore:      nil,
 		remoteAStore:     nil,
+		quarantineDStore: nil,
+		rateLimits:       rateLimits,
+		replicas:         replicas,
 	}
 }
 
 // NewInMemorySession creates a new session in which all data is stored in-memory.
 // This session is used for testing.
 func NewInMemorySession(feed *config.Feed) *Session {
+	dictionaries := dictionary.NewInMemoryStore(feed)
 	remoteAStore := astore.NewReplicatedAStore(
-		astore.NewInMemoryAStore(), astore.NewInMemoryAStore())
+		astore.NewInMemoryAStore(), astore.NewInMemoryAStore()).WithDictionaries(dictionaries)
 	return &Session{
 		feed:             feed,
-		ctx:              nil,
+		ctx:              context.Background(),
 		log:              slog.With("feed", feed.ID),
 		workspace:        "",
 		enableMonitoring: false,
 		localDStore:      dstore.NewInMemoryDStore(),
-		localAStore:      astore.NewInMemoryAStore(),
+		localAStore:      astore.WithDictionaries(astore.NewInMemoryAStore(), dictionaries),
 		remoteAStore:     &remoteAStore,
+		quarantineDStore: dstore.NewInMemoryDStore(),
+		errorsDStore:     dstore.NewInMemoryDStore(),
+		dictionaries:     dictionaries,
 	}
 }
 
@@ -90,6 +111,73 @@ func (s *Session) Log() *slog.Logger {
 	return s.log
 }
 
+// RateLimits returns the rate limits that requests in this session go through. The
+// Registry may be nil, in which case there are no rate limits.
+func (s *Session) RateLimits() *ratelimit.Registry {
+	return s.rateLimits
+}
+
+// Replicas returns the coordinator used to offset downloads relative to other replicas. The
+// Coordinator is nil if downloads are not coordinated.
+func (s *Session) Replicas() *replicas.Coordinator {
+	return s.replicas
+}
+
+// Dictionaries returns the store of the feed's compression dictionaries. The dictionaries are
+// kept in remote object storage or, if none is configured, in the workspace. The Store is
+// available even if the feed's current compression format does not use a dictionary, so that
+// archives compressed using a dictionary in the past can still be read. It is nil if the
+// object storage for the dictionaries could not be initialized.
+func (s *Session) Dictionaries() *dictionary.Store {
+	s.dictionariesMu.Lock()
+	defer s.dictionariesMu.Unlock()
+	if s.dictionaries == nil {
+		if len(s.objectStorage) == 0 {
+			s.dictionaries = dictionary.NewDiskStore(path.Join(s.workspace, DictionariesSubDir), s.feed)
+			return s.dictionaries
+		}
+		d, err := dictionary.NewStore(s.ctx, s.objectStorage, s.feed)
+		if err != nil {
+			s.Log().Error(fmt.Sprintf("failed to initialize dictionary storage: %s", err))
+			return nil
+		}
+		s.dictionaries = d
+	}
+	return s.dictionaries
+}
+
+// withDictionaries returns the AStore, wrapped so that it provides the feed's compression
+// dictionaries.
+func (s *Session) withDictionaries(aStore storage.AStore) storage.AStore {
+	return astore.WithDictionaries(aStore, lazyDictionaries{s})
+}
+
+// lazyDictionaries provides the compression dictionaries of a session. The dictionary Store,
+// and the object storage clients it uses, are only created when a dictionary is first needed:
+// when an archive is written for a feed whose compression uses a dictionary, or when an
+// archive compressed using a dictionary is read.
+type lazyDictionaries struct {
+	session *Session
+}
+
+var errDictionariesUnavailable = fmt.Errorf("the dictionary storage is not available")
+
+func (d lazyDictionaries) Latest() (storage.Hash, []byte, error) {
+	store := d.session.Dictionaries()
+	if store == nil {
+		return "", nil, errDictionariesUnavailable
+	}
+	return store.Latest()
+}
+
+func (d lazyDictionaries) Get(hash storage.Hash) ([]byte, error) {
+	store := d.session.Dictionaries()
+	if store == nil {
+		return nil, errDictionariesUnavailable
+	}
+	return store.Get(hash)
+}
+
 // LogWithHour returns an object used for logging information about a specific hour in this session
 func (s *Session) LogWithHour(h hour.Hour) *slog.Logger {
 	return s.log.With("hour", h)
@@ -107,6 +195,35 @@ func (s *Session) LocalDStore() storage.DStore {
 	return s.localDStore
 }
 
+// QuarantineDStore returns the DStore, based on the local filesystem, in which downloads that
+// failed validation are kept. Files in this DStore are not packed or uploaded.
+func (s *Session) QuarantineDStore() storage.DStore {
+	if s.quarantineDStore == nil {
+		store := persistence.NewDiskPersistedStorage(path.Join(s.workspace, QuarantineSubDir, s.feed.ID))
+		if s.enableMonitoring {
+			go store.PeriodicallyReportUsageMetrics(s.ctx, QuarantineSubDir, s.feed.ID)
+		}
+		s.quarantineDStore = dstore.NewPersistedDStore(store, s.log)
+	}
+	return s.quarantineDStore
+}
+
+// ErrorsDStore returns the DStore, based on the local filesystem, in which failed responses
+// are kept. It is the local DStore of the feed's errors feed, so its files are packed and
+// uploaded by the session of the errors feed. It is nil if the feed does not keep failed
+// responses.
+func (s *Session) ErrorsDStore() storage.DStore {
+	errorsFeed := s.feed.ErrorsFeed()
+	if errorsFeed == nil {
+		return nil
+	}
+	if s.errorsDStore == nil {
+		store := persistence.NewDiskPersistedStorage(path.Join(s.workspace, DownloadsSubDir, errorsFeed.ID))
+		s.errorsDStore = dstore.NewPersistedDStore(store, s.log)
+	}
+	return s.errorsDStore
+}
+
 // LocalAStore returns the AStore based on the local filesystem.
 func (s *Session) LocalAStore() storage.AStore {
 	if s.localAStore == nil {
@@ -114,7 +231,7 @@ func (s *Session) LocalAStore() storage.AStore {
 		if s.enableMonitoring {
 			go store.PeriodicallyReportUsageMetrics(s.ctx, ArchivesSubDir, s.feed.ID)
 		}
-		s.localAStore = astore.NewPersistedAStore(store, s.log)
+		s.localAStore = s.withDictionaries(astore.NewPersistedAStore(store, s.log))
 	}
 	return s.localAStore
 }
@@ -140,7 +257,7 @@ func (s *Session) RemoteAStore() *astore.ReplicatedAStore {
 			}
 			remoteAStores = append(remoteAStores, astore.NewPersistedAStore(a, s.Log()))
 		}
-		remoteAStore := astore.NewReplicatedAStore(remoteAStores...)
+		remoteAStore := astore.NewReplicatedAStore(remoteAStores...).WithDictionaries(lazyDictionaries{s})
 		s.remoteAStore = &remoteAStore
 	}
 	return s.remoteAStore
@@ -157,7 +274,35 @@ func (s *Session) TempDStore() (storage.DStore, func() error) {
 // that must be invoked to clean up the AStore.
 func (s *Session) TempAStore() (storage.AStore, func() error) {
 	st, closer := s.tempPersistedStorage()
-	return astore.NewPersistedAStore(st, s.Log()), closer
+	return s.withDictionaries(astore.NewPersistedAStore(st, s.Log())), closer
+}
+
+// TmpDir returns the directory in the workspace used for temporary files, creating it if
+// it does not exist. If the session has no workspace, the empty string is returned; this
+// is interpreted as the default directory for temporary files by the os package.
+func (s *Session) TmpDir() (string, error) {
+	if s.workspace == "" {
+		return "", nil
+	}
+	dir := path.Join(s.workspace, TmpSubDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create the temporary directory: %w", err)
+	}
+	return dir, nil
+}
+
+// StateDir returns the directory in which tasks persist state for this session's feed
+// between runs. If the session is in-memory, the returned path is empty and state should
+// not be persisted.
+func (s *Session) StateDir() (string, error) {
+	if s.workspace == "" {
+		return "", nil
+	}
+	dir := path.Join(s.workspace, StateSubDir, s.feed.ID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create the state directory: %w", err)
+	}
+	return dir, nil
 }
 
 func (s *Session) tempPersistedStorage() (persistence.PersistedStorage, func() error) {
@@ -1,6 +1,8 @@
 // Package upload contains the upload task.
 //
 // This task uploads compressed archive files from local disk to remote object storage.
+// If enabled for the feed, it also periodically uploads partial archive files for the bucket
+// that is currently in progress.
 package upload
 
 import (
@@ -11,10 +13,12 @@ import (
 	"github.com/jamespfennell/hoard/internal/storage"
 	"github.com/jamespfennell/hoard/internal/tasks"
 	"github.com/jamespfennell/hoard/internal/tasks/merge"
+	"github.com/jamespfennell/hoard/internal/tasks/pack"
 	"github.com/jamespfennell/hoard/internal/util"
 )
 
-// RunPeriodically runs the upload task periodically with the prescribed period.
+// RunPeriodically runs the upload task periodically: every hour or, if the feed uses
+// sub-hour buckets, after every bucket.
 func RunPeriodically(session *tasks.Session) {
 	if session.RemoteAStore() == nil {
 		session.Log().Warn("No remote object storage is configured, periodic uploader will not run")
@@ -22,7 +26,13 @@ func RunPeriodically(session *tasks.Session) {
 	}
 	feed := session.Feed()
 	session.Log().Info("Starting periodic uploader")
-	ticker := util.NewPerHourTicker(time.Minute * 12)
+	var ticker util.Ticker
+	if width := feed.BucketWidthActual(); width < time.Hour {
+		// Sub-hour buckets are uploaded shortly after they have been packed.
+		ticker = util.NewAlignedTicker(width, func(time.Duration) time.Duration { return width / 5 })
+	} else {
+		ticker = util.NewPerHourTicker(time.Minute * 12)
+	}
 	defer ticker.Stop()
 	for {
 		select {
@@ -65,6 +75,72 @@ func RunOnce(session *tasks.Session) error {
 	return util.NewMultipleError(errs...)
 }
 
+// RunPartialsPeriodically uploads a partial archive of the current bucket with the feed's
+// partial archive period. It does nothing if partial archives are not enabled for the feed.
+func RunPartialsPeriodically(session *tasks.Session) {
+	feed := session.Feed()
+	if feed.PartialArchivePeriod == 0 {
+		return
+	}
+	if session.RemoteAStore() == nil {
+		session.Log().Warn("No remote object storage is configured, partial archives will not be uploaded")
+		return
+	}
+	session.Log().Info("Starting periodic partial archive uploader")
+	ticker := util.NewAlignedTicker(feed.PartialArchivePeriod, func(time.Duration) time.Duration { return 0 })
+	defer ticker.Stop()
+	var previous *storage.AFile
+	for {
+		select {
+		case <-ticker.C:
+			aFile, ok, err := RunPartialOnce(session, previous)
+			if err != nil {
+				session.Log().Error(fmt.Sprintf("Error while uploading partial archive: %s", err))
+			} else if ok {
+				previous = &aFile
+			}
+			monitoring.RecordPartialUpload(feed, err)
+		case <-session.Ctx().Done():
+			session.Log().Info("Stopped periodic partial archive uploader")
+			return
+		}
+	}
+}
+
+// RunPartialOnce packs the current bucket into a partial archive and uploads it. The boolean
+// return value is false if there was no data to upload.
+//
+// If the previous partial archive uploaded by this process is provided and is for the same
+// bucket, it is deleted from remote storage after the upload. Its data is contained in the
+// new partial archive because downloaded files are not deleted until the bucket is packed.
+func RunPartialOnce(session *tasks.Session, previous *storage.AFile) (storage.AFile, bool, error) {
+	if session.RemoteAStore() == nil {
+		return storage.AFile{}, false, fmt.Errorf("cannot upload because no remote object storage is configured")
+	}
+	// The partial archive is created in a temporary store so that it is not merged or
+	// uploaded by the regular uploader, which only handles final archives.
+	aStore, eraseAStore := session.TempAStore()
+	defer func() {
+		if err := eraseAStore(); err != nil {
+			session.Log().Error(fmt.Sprintf("Failed to erase temporary AStore: %s", err))
+		}
+	}()
+	aFile, ok, err := pack.RunPartialOnce(session, aStore)
+	if err != nil || !ok {
+		return aFile, ok, err
+	}
+	if err := storage.CopyAFile(aStore, session.RemoteAStore(), aFile); err != nil {
+		return storage.AFile{}, false, fmt.Errorf("upload error for %s: %w", aFile, err)
+	}
+	session.Log().Debug(fmt.Sprintf("Uploaded partial archive %s", aFile))
+	if previous != nil && previous.Hour == aFile.Hour && !previous.Equals(aFile) {
+		if err := session.RemoteAStore().Delete(*previous); err != nil {
+			session.Log().Error(fmt.Sprintf("Failed to delete previous partial archive %s: %s", *previous, err))
+		}
+	}
+	return aFile, true, nil
+}
+
 func uploadAFile(session *tasks.Session, aFile storage.AFile) error {
 	session.Log().Debug(fmt.Sprintf("Beginning upload of %s", aFile))
 	if err := storage.CopyAFile(session.LocalAStore(), session.RemoteAStore(), aFile); err != nil {
@@ -108,19 +108,38 @@ func (err multipleError) Error() string {
 }
 
 type Ticker struct {
-	C    chan struct{}
-	done chan struct{}
+	C     chan struct{}
+	done  chan struct{}
+	reset chan time.Duration
+}
+
+func newTicker() Ticker {
+	return Ticker{
+		C:     make(chan struct{}),
+		done:  make(chan struct{}),
+		reset: make(chan time.Duration, 1),
+	}
 }
 
 func (t Ticker) Stop() {
 	close(t.done)
 }
 
-func NewTicker(period time.Duration, variation time.Duration) Ticker {
-	t := Ticker{
-		C:    make(chan struct{}),
-		done: make(chan struct{}),
+// Reset changes the period of a ticker created using NewTicker. The next tick occurs
+// once the new period has elapsed. If Reset is called multiple times before the ticker
+// processes the change, only the last period is used.
+//
+// Reset must not be called concurrently from multiple goroutines.
+func (t Ticker) Reset(period time.Duration) {
+	select {
+	case <-t.reset:
+	default:
 	}
+	t.reset <- period
+}
+
+func NewTicker(period time.Duration, variation time.Duration) Ticker {
+	t := newTicker()
 	go func() {
 		t.C <- struct{}{}
 		internalT := time.NewTicker(period)
@@ -131,7 +150,42 @@ func NewTicker(period time.Duration, variation time.Duration) Ticker {
 				if wait(time.Duration(rand.Float64()*float64(variation)), t.done) {
 					t.C <- struct{}{}
 				}
+			case newPeriod := <-t.reset:
+				if newPeriod != period {
+					period = newPeriod
+					internalT.Reset(period)
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// NewAlignedTicker creates a ticker whose ticks are aligned to the wall clock: each tick
+// occurs at a time t such that the duration between the Unix epoch and t, modulo the period,
+// equals the offset. The offset function is called with the current period before each tick,
+// so the offset may change over time. Unlike NewTicker, the first tick is not immediate.
+//
+// The period of the ticker can be changed using Reset.
+func NewAlignedTicker(period time.Duration, offset func(period time.Duration) time.Duration) Ticker {
+	t := newTicker()
+	go func() {
+		for {
+			timer := time.NewTimer(untilAlignedTick(time.Now(), period, offset(period)))
+			select {
+			case <-timer.C:
+				select {
+				case t.C <- struct{}{}:
+				case <-t.done:
+					return
+				}
+			case newPeriod := <-t.reset:
+				timer.Stop()
+				period = newPeriod
 			case <-t.done:
+				timer.Stop()
 				return
 			}
 		}
@@ -139,6 +193,19 @@ func NewTicker(period time.Duration, variation time.Duration) Ticker {
 	return t
 }
 
+// untilAlignedTick returns the duration from now until the next aligned tick.
+func untilAlignedTick(now time.Time, period time.Duration, offset time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+	phase := time.Duration(now.UnixNano() % int64(period))
+	d := (offset%period - phase) % period
+	if d <= 0 {
+		d += period
+	}
+	return d
+}
+
 // wait blocks until the provided duration has passed or until the done
 // channel is closed, whichever is first. It returns true if and only if
 // the duration has passed.
@@ -167,10 +234,7 @@ func NewPerHourTicker(startOffset time.Duration) Ticker {
 	if startOffset < 0 || startOffset >= time.Hour {
 		startOffset = 0
 	}
-	t := Ticker{
-		C:    make(chan struct{}),
-		done: make(chan struct{}),
-	}
+	t := newTicker()
 	go func() {
 		now := time.Now().UTC()
 		startTime := now.Truncate(time.Hour).Add(time.Hour)
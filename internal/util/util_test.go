@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUntilAlignedTick(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, testCase := range []struct {
+		now      time.Time
+		period   time.Duration
+		offset   time.Duration
+		expected time.Duration
+	}{
+		{base, time.Minute, 0, time.Minute},
+		{base, time.Minute, 20 * time.Second, 20 * time.Second},
+		{base.Add(30 * time.Second), time.Minute, 20 * time.Second, 50 * time.Second},
+		{base.Add(10 * time.Second), time.Minute, 20 * time.Second, 10 * time.Second},
+		{base.Add(10 * time.Second), time.Minute, 80 * time.Second, 10 * time.Second},
+	} {
+		actual := untilAlignedTick(testCase.now, testCase.period, testCase.offset)
+		if actual != testCase.expected {
+			t.Errorf("Unexpected duration until tick %s for %+v; expected %s", actual, testCase, testCase.expected)
+		}
+	}
+}
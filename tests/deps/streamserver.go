@@ -0,0 +1,94 @@
+package deps
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamServer is a feed server for streaming feeds. It serves Server-Sent Events on the
+// path /sse and WebSocket connections on the path /websocket. On each connection it sends
+// a single random message and then keeps the connection open until the client closes it.
+type StreamServer struct {
+	listener      net.Listener
+	server        *http.Server
+	closedServerC chan struct{}
+	mu            sync.Mutex
+	responses     map[string]bool
+}
+
+func NewStreamServer() (*StreamServer, error) {
+	s := StreamServer{
+		closedServerC: make(chan struct{}),
+		responses:     map[string]bool{},
+	}
+	var err error
+	s.listener, err = net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprintf(writer, "data: %s\n\n", s.newResponse())
+		writer.(http.Flusher).Flush()
+		<-req.Context().Done()
+	})
+	mux.HandleFunc("/websocket", func(writer http.ResponseWriter, req *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(writer, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(s.newResponse())); err != nil {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(s.listener); err != nil {
+			fmt.Printf("Stream server stopped: %s\n", err)
+		}
+		close(s.closedServerC)
+	}()
+	return &s, nil
+}
+
+func (s *StreamServer) newResponse() string {
+	response := randSeq(20)
+	s.mu.Lock()
+	s.responses[response] = true
+	s.mu.Unlock()
+	fmt.Printf("Stream server on port %d: Sent message: %s\n", s.Port(), response)
+	return response
+}
+
+func (s *StreamServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *StreamServer) CleanUp() error {
+	// Shutdown does not close hijacked WebSocket connections or wait for open event
+	// streams, so we close all connections first.
+	err := s.server.Close()
+	<-s.closedServerC
+	return err
+}
+
+func (s *StreamServer) Responses() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	responses := map[string]bool{}
+	for response := range s.responses {
+		responses[response] = true
+	}
+	return responses
+}
@@ -4,14 +4,17 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jamespfennell/hoard"
 	"github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/hoard/internal/storage/persistence"
 	"github.com/jamespfennell/hoard/tests/deps"
 )
 
@@ -164,6 +167,41 @@ func TestDifferentCompressionFormats(t *testing.T) {
 	}
 }
 
+func Test_StreamingSources(t *testing.T) {
+	for _, source := range []config.Source{config.SourceSSE, config.SourceWebSocket} {
+		t.Run(string(source), func(t *testing.T) {
+			workspace := newFilesystem(t)
+			server := newStreamServer(t)
+			scheme := "http"
+			if source == config.SourceWebSocket {
+				scheme = "ws"
+			}
+
+			c := &config.Config{
+				WorkspacePath: workspace.String(),
+				Feeds: []config.Feed{
+					{
+						ID:      "feed1_",
+						Postfix: ".txt",
+						Source:  source,
+						URL:     fmt.Sprintf("%s://localhost:%d/%s", scheme, server.Port(), source),
+					},
+				},
+			}
+
+			tasks := []Task{
+				Download,
+				Download,
+				Download,
+				Pack,
+			}
+			requireNilErr(t, ExecuteMany(tasks, c))
+
+			verifyLocalFiles(t, workspace.SubDir(hoard.ArchivesSubDir), server, true)
+		})
+	}
+}
+
 func replaceCompressionFormat(c config.Config, compression config.Compression) *config.Config {
 	for i := range c.Feeds {
 		c.Feeds[i].Compression = compression
@@ -171,7 +209,7 @@ func replaceCompressionFormat(c config.Config, compression config.Compression) *
 	return &c
 }
 
-func verifyLocalFiles(t *testing.T, fs deps.Filesystem, server *deps.FeedServer, packed bool) {
+func verifyLocalFiles(t *testing.T, fs deps.Filesystem, server interface{ Responses() map[string]bool }, packed bool) {
 	archivePaths, err := fs.ListAllFiles()
 	if err != nil {
 		t.Errorf("Error when listing all archive files: %s\n", err)
@@ -244,6 +282,38 @@ func extract(b []byte) ([]string, error) {
 	return result, nil
 }
 
+func Test_ObjectStorageGetRange(t *testing.T) {
+	bucketName := newBucket(t, minioServer1)
+	objectStorage := minioServer1.Config(bucketName)
+	s, err := persistence.NewObjectPersistedStorage(context.Background(), &objectStorage, &config.Feed{ID: "feed"})
+	requireNilErr(t, err)
+	key := persistence.Key{Prefix: persistence.Prefix{"a"}, Name: "b"}
+	requireNilErr(t, s.Put(key, bytes.NewBufferString("some sample data"), time.Now()))
+
+	for _, testCase := range []struct {
+		offset   int64
+		length   int64
+		expected string
+	}{
+		{5, 6, "sample"},
+		{-4, 0, "data"},
+		{5, 0, ""},
+	} {
+		reader, err := persistence.GetRange(s, key, testCase.offset, testCase.length)
+		requireNilErr(t, err)
+		b, err := io.ReadAll(reader)
+		requireNilErr(t, err)
+		if string(b) != testCase.expected {
+			t.Errorf("Unexpected range %q for (%d, %d); expected %q",
+				b, testCase.offset, testCase.length, testCase.expected)
+		}
+	}
+	missingKey := persistence.Key{Prefix: persistence.Prefix{"a"}, Name: "c"}
+	if _, err := persistence.GetRange(s, missingKey, 0, 0); err == nil {
+		t.Errorf("Expected error for empty range of a missing key")
+	}
+}
+
 func newFilesystem(t *testing.T) deps.Filesystem {
 	f, err := deps.NewFilesystem(*hoardTmpDir)
 	cleanUp(t, f, err)
@@ -256,6 +326,12 @@ func newFeedServer(t *testing.T) *deps.FeedServer {
 	return s
 }
 
+func newStreamServer(t *testing.T) *deps.StreamServer {
+	s, err := deps.NewStreamServer()
+	cleanUp(t, s, err)
+	return s
+}
+
 func newBucket(t *testing.T, minioServer *deps.InProcessMinioServer) string {
 	requireNilErr(t, minioServer.EnsureLaunched())
 	bucketName, err := minioServer.NewBucket()